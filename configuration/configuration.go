@@ -1,28 +1,47 @@
 package configuration
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gookit/color"
 	"github.com/joho/godotenv"
 )
 
+var (
+	ErrInvalidConfiguration = errors.New("invalid configuration error") // Triggered when the env file cannot be loaded
+	ErrInvalidDuration      = errors.New("invalid duration")            // Triggered when a duration is neither a time.Duration nor an int, or is negative
+)
+
 type Configuration struct {
 	Configurations *map[string]any
 }
 
+// NewConfiguration loads the env file and creates the configuration, exiting the process on error.
 func NewConfiguration(envPath string) *Configuration {
+	app, err := NewConfigurationE(envPath)
+	if err != nil {
+		color.Redln(err)
+		os.Exit(1)
+	}
+	return app
+}
+
+// NewConfigurationE loads the env file and creates the configuration, returning any error to the caller.
+// The configuration is usable even when the env file cannot be loaded, reading only the process environment.
+func NewConfigurationE(envPath string) (*Configuration, error) {
 	app := &Configuration{}
 	configurations := make(map[string]any)
 	app.Configurations = &configurations
 	if err := godotenv.Load(envPath); err != nil {
-		color.Redln("Invalid Configuration error: " + err.Error())
-		os.Exit(0)
+		return app, fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
 	}
-	return app
+	return app, nil
 }
 
 // Env Get Configuration from env.
@@ -80,6 +99,25 @@ func (config *Configuration) Env(envName string, defaultValue interface{}) inter
 	return defaultValue
 }
 
+// Duration reads a duration of a config map, declared as a time.Duration or as an int in seconds.
+// It returns the default value when the key is missing, and ErrInvalidDuration for other types
+// and negative values.
+func Duration(values map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	switch value := values[key].(type) {
+	case nil:
+		return defaultValue, nil
+	case time.Duration:
+		if value >= 0 {
+			return value, nil
+		}
+	case int:
+		if value >= 0 {
+			return time.Duration(value) * time.Second, nil
+		}
+	}
+	return defaultValue, fmt.Errorf("%w: %s", ErrInvalidDuration, key)
+}
+
 func (config *Configuration) Add(name string, configuration any) {
 	(*config.Configurations)[name] = configuration
 }
@@ -0,0 +1,37 @@
+package configuration
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    time.Duration
+		wantErr error
+	}{
+		{name: "missing map", want: time.Minute},
+		{name: "missing key", values: map[string]interface{}{}, want: time.Minute},
+		{name: "duration", values: map[string]interface{}{"timeout": 1500 * time.Millisecond}, want: 1500 * time.Millisecond},
+		{name: "seconds", values: map[string]interface{}{"timeout": 5}, want: 5 * time.Second},
+		{name: "zero", values: map[string]interface{}{"timeout": 0}, want: 0},
+		{name: "negative duration", values: map[string]interface{}{"timeout": -time.Second}, want: time.Minute, wantErr: ErrInvalidDuration},
+		{name: "negative seconds", values: map[string]interface{}{"timeout": -1}, want: time.Minute, wantErr: ErrInvalidDuration},
+		{name: "string", values: map[string]interface{}{"timeout": "5s"}, want: time.Minute, wantErr: ErrInvalidDuration},
+		{name: "float", values: map[string]interface{}{"timeout": 1.5}, want: time.Minute, wantErr: ErrInvalidDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Duration(tt.values, "timeout", time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Duration() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Duration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package configuration
+
+import (
+	"strings"
+)
+
+// Errors aggregates every configuration problem found while booting a component,
+// so that all of them can be reported in one pass instead of stopping at the first one.
+type Errors struct {
+	Component string  // Name of the component being booted (e.g. database, router)
+	Problems  []error // Every problem found, in the order they were detected
+}
+
+// NewErrors creates an empty aggregate for the given component.
+func NewErrors(component string) *Errors {
+	return &Errors{Component: component}
+}
+
+// Add appends a problem to the aggregate, ignoring nil errors.
+func (e *Errors) Add(err error) {
+	if err != nil {
+		e.Problems = append(e.Problems, err)
+	}
+}
+
+// Err returns the aggregate as an error, or nil when no problem was found.
+func (e *Errors) Err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error implements the error interface listing every problem on its own line.
+func (e *Errors) Error() string {
+	var builder strings.Builder
+	builder.WriteString(e.Component)
+	builder.WriteString(" configuration errors:")
+	for _, problem := range e.Problems {
+		builder.WriteString("\n  - ")
+		builder.WriteString(problem.Error())
+	}
+	return builder.String()
+}
+
+// Unwrap exposes the problems to errors.Is and errors.As.
+func (e *Errors) Unwrap() []error {
+	return e.Problems
+}
@@ -0,0 +1,81 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Problem carrying the setting it was found in
+type settingError struct {
+	Key string
+}
+
+func (e *settingError) Error() string {
+	return "invalid " + e.Key
+}
+
+func TestErrors(t *testing.T) {
+	errMissing := errors.New("missing connection")
+	errTimezone := errors.New("invalid timezone")
+	tests := []struct {
+		name      string
+		problems  []error
+		wantError string // "" when Err returns nil
+	}{
+		{name: "no problem"},
+		{name: "nil problems are ignored", problems: []error{nil, nil}},
+		{
+			name:      "one problem",
+			problems:  []error{errMissing},
+			wantError: "database configuration errors:\n  - missing connection",
+		},
+		{
+			name:      "every problem",
+			problems:  []error{fmt.Errorf("%w: main", errMissing), nil, errTimezone, &settingError{Key: "retry.jitter"}},
+			wantError: "database configuration errors:\n  - missing connection: main\n  - invalid timezone\n  - invalid retry.jitter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := NewErrors("database")
+			for _, problem := range tt.problems {
+				problems.Add(problem)
+			}
+			err := problems.Err()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("Err() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantError {
+				t.Fatalf("Err() = %v, want %q", err, tt.wantError)
+			}
+			for _, problem := range tt.problems {
+				if problem != nil && !errors.Is(err, problem) {
+					t.Errorf("errors.Is(Err(), %v) = false", problem)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorsUnwrap(t *testing.T) {
+	problems := NewErrors("router")
+	problems.Add(errors.New("invalid app.env"))
+	problems.Add(fmt.Errorf("access log: %w", &settingError{Key: "http.access_log.format"}))
+	err := fmt.Errorf("booting: %w", problems.Err())
+
+	var setting *settingError
+	if !errors.As(err, &setting) || setting.Key != "http.access_log.format" {
+		t.Fatalf("errors.As() = %v, want the access log setting", setting)
+	}
+	var aggregate *Errors
+	if !errors.As(err, &aggregate) || len(aggregate.Problems) != 2 || aggregate.Component != "router" {
+		t.Fatalf("errors.As() = %+v, want the 2 router problems", aggregate)
+	}
+	if errors.Is(err, errors.New("invalid app.env")) {
+		t.Fatal("errors.Is() matched an error that was not added")
+	}
+}
@@ -8,6 +8,13 @@ type Resource struct {
 	Show    bool
 	Update  bool
 	Destroy bool
+	Patch   bool // PATCH /:id, see Patcher; registered by default when the controller implements it
+
+	// Optional routes, the controller must implement the matching interface
+	Restore     bool // POST /:id/restore, see RestoreController
+	Trashed     bool // GET /trashed, see TrashedController
+	ForceDelete bool // DELETE /:id/force, see ForceDeleteController
+	History     bool // GET /:id/history, see HistoryController
 }
 
 type ResourceController interface {
@@ -17,3 +24,23 @@ type ResourceController interface {
 	Update(ctx *gin.Context)
 	Destroy(ctx *gin.Context)
 }
+
+type RestoreController interface {
+	Restore(ctx *gin.Context)
+}
+
+type TrashedController interface {
+	Trashed(ctx *gin.Context)
+}
+
+type ForceDeleteController interface {
+	ForceDelete(ctx *gin.Context)
+}
+
+type HistoryController interface {
+	History(ctx *gin.Context)
+}
+
+type Patcher interface {
+	Patch(ctx *gin.Context)
+}
@@ -0,0 +1,337 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database/migrations"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	// TableName is the table where the audit entries are stored
+	TableName = "capyvel_audits"
+	// ActorKey is the gin.Context key where SetActor stores the actor of the request
+	ActorKey = "capyvel.audit.actor"
+
+	// Actions recorded in the audit table
+	ActionCreate      = "create"
+	ActionUpdate      = "update"
+	ActionDelete      = "delete"
+	ActionRestore     = "restore"
+	ActionForceDelete = "force_delete"
+)
+
+var (
+	ErrParsingModel   = errors.New("error parsing audited model")     // Triggered when the schema of a model cannot be parsed
+	ErrEncodingChange = errors.New("error encoding audit changes")    // Triggered when the diff cannot be serialized
+	ErrWritingEntry   = errors.New("error writing audit entry")       // Triggered when an entry cannot be stored
+	ErrReadingHistory = errors.New("error reading the audit history") // Triggered when the history of a record cannot be read
+
+	// Audit settings, nil while the audit trail is disabled
+	current   *Config
+	currentMu sync.RWMutex
+)
+
+// Migration creates the audit table; register it with migrations.Register to enable the audit trail
+var Migration = migrations.Migration{
+	ID:   "00000000000000_create_" + TableName,
+	Up:   func(tx *gorm.DB) error { return tx.AutoMigrate(&Entry{}) },
+	Down: func(tx *gorm.DB) error { return tx.Migrator().DropTable(&Entry{}) },
+}
+
+// Actor identifies who made a change
+type Actor struct {
+	ID   string
+	Name string
+}
+
+// ActorResolver returns the actor of a request
+type ActorResolver func(ctx *gin.Context) Actor
+
+// Config holds the settings of the audit trail
+type Config struct {
+	Actor  ActorResolver // Resolves the actor of the request, the one stored by SetActor when nil
+	Tables []string      // Tables audited, every table when empty
+	Ignore []string      // Fields never recorded (e.g. UpdatedAt or Password), by struct or column name
+}
+
+// Change is the previous and new value of a field
+type Change struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// Entry is a row of the audit table
+type Entry struct {
+	ID        uint64    `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Table     string    `gorm:"column:table_name;size:255;index:idx_capyvel_audits_record" json:"table"`
+	RecordKey string    `gorm:"column:record_key;size:255;index:idx_capyvel_audits_record" json:"recordKey"`
+	Action    string    `gorm:"column:action;size:32" json:"action"`
+	Changes   string    `gorm:"column:changes" json:"-"`
+	ActorID   string    `gorm:"column:actor_id;size:255" json:"actorId,omitempty"`
+	ActorName string    `gorm:"column:actor_name;size:255" json:"actorName,omitempty"`
+	IP        string    `gorm:"column:ip;size:64" json:"ip,omitempty"`
+	UserAgent string    `gorm:"column:user_agent;size:512" json:"userAgent,omitempty"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+// TableName implements gorm's Tabler
+func (Entry) TableName() string {
+	return TableName
+}
+
+// Diff decodes the field-level changes of the entry
+func (entry Entry) Diff() (map[string]Change, error) {
+	changes := map[string]Change{}
+	if entry.Changes == "" {
+		return changes, nil
+	}
+	err := json.Unmarshal([]byte(entry.Changes), &changes)
+	return changes, err
+}
+
+// MarshalJSON includes the decoded changes in the JSON of the entry
+func (entry Entry) MarshalJSON() ([]byte, error) {
+	type plain Entry
+	changes, err := entry.Diff()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		plain
+		Changes map[string]Change `json:"changes"`
+	}{plain(entry), changes})
+}
+
+// Enable turns the audit trail on for the writes of helpers.Orm
+func Enable(config Config) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = &config
+}
+
+// Disable turns the audit trail off
+func Disable() {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = nil
+}
+
+// Enabled reports whether the audit trail is on
+func Enabled() bool {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current != nil
+}
+
+// Audited reports whether the writes of the model are recorded: the audit trail is on and its
+// table is one of Config.Tables, when set
+func Audited(db *gorm.DB, model any) bool {
+	currentMu.RLock()
+	config := current
+	currentMu.RUnlock()
+	if config == nil {
+		return false
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		// Record reports the parsing error
+		return true
+	}
+	return config.audits(stmt.Schema.Table)
+}
+
+// Reports whether the table is audited
+func (config *Config) audits(table string) bool {
+	return len(config.Tables) == 0 || contains(config.Tables, table)
+}
+
+// SetActor stores the actor of the request, usually from an authentication middleware
+func SetActor(ctx *gin.Context, actor Actor) {
+	ctx.Set(ActorKey, actor)
+}
+
+// ContextActor returns the actor stored by SetActor
+func ContextActor(ctx *gin.Context) Actor {
+	if ctx == nil {
+		return Actor{}
+	}
+	value, _ := ctx.Get(ActorKey)
+	actor, _ := value.(Actor)
+	return actor
+}
+
+// Record stores the field-level diff between the previous and new state of the records.
+// before is nil for creations and after is nil for deletions; both may be a pointer to a
+// struct or to a slice of structs. The entries are written with db, so they belong to its
+// transaction, if any. Nothing is stored when the audit trail is disabled.
+func Record(ctx *gin.Context, db *gorm.DB, action string, before, after any) error {
+	currentMu.RLock()
+	config := current
+	currentMu.RUnlock()
+	if config == nil {
+		return nil
+	}
+
+	model := after
+	if model == nil {
+		model = before
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return fmt.Errorf("%w: %v", ErrParsingModel, err)
+	}
+	if !config.audits(stmt.Schema.Table) {
+		return nil
+	}
+
+	actorResolver := config.Actor
+	if actorResolver == nil {
+		actorResolver = ContextActor
+	}
+	actor := actorResolver(ctx)
+	var ip, userAgent string
+	if ctx != nil && ctx.Request != nil {
+		ip = ctx.ClientIP()
+		userAgent = ctx.Request.UserAgent()
+	}
+
+	var goCtx context.Context = context.Background()
+	if ctx != nil {
+		goCtx = ctx
+	}
+	beforeRows := rows(before)
+	afterRows := rows(after)
+	var entries []Entry
+	for i := 0; i < max(len(beforeRows), len(afterRows)); i++ {
+		var previous, next reflect.Value
+		if i < len(beforeRows) {
+			previous = beforeRows[i]
+		}
+		if i < len(afterRows) {
+			next = afterRows[i]
+		}
+		row := next
+		if !row.IsValid() {
+			row = previous
+		}
+		changes := diff(goCtx, stmt.Schema, config.Ignore, previous, next)
+		if len(changes) == 0 && action == ActionUpdate {
+			continue
+		}
+		encoded, err := json.Marshal(changes)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrEncodingChange, err)
+		}
+		entries = append(entries, Entry{
+			Table:     stmt.Schema.Table,
+			RecordKey: recordKey(goCtx, stmt.Schema, row),
+			Action:    action,
+			Changes:   string(encoded),
+			ActorID:   actor.ID,
+			ActorName: actor.Name,
+			IP:        ip,
+			UserAgent: userAgent,
+			CreatedAt: time.Now(),
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := db.Clauses(dbresolver.Write).WithContext(goCtx).Create(&entries).Error; err != nil {
+		return fmt.Errorf("%w: %v", ErrWritingEntry, err)
+	}
+	return nil
+}
+
+// History returns the audit entries of a record, oldest first
+func History(ctx context.Context, db *gorm.DB, table string, key any) ([]Entry, error) {
+	var entries []Entry
+	err := db.WithContext(ctx).
+		Where(clause.Eq{Column: clause.Column{Name: "table_name"}, Value: table}).
+		Where(clause.Eq{Column: clause.Column{Name: "record_key"}, Value: fmt.Sprint(key)}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}}).
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReadingHistory, err)
+	}
+	return entries, nil
+}
+
+// Key returns the table and the formatted primary key of a record, as stored in the audit table
+func Key(db *gorm.DB, obj any) (string, string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrParsingModel, err)
+	}
+	return stmt.Schema.Table, recordKey(context.Background(), stmt.Schema, reflect.Indirect(reflect.ValueOf(obj))), nil
+}
+
+// Returns the struct values held by a pointer to a struct or to a slice of structs
+func rows(obj any) []reflect.Value {
+	if obj == nil {
+		return nil
+	}
+	value := reflect.Indirect(reflect.ValueOf(obj))
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return []reflect.Value{value}
+	}
+	result := make([]reflect.Value, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		result = append(result, reflect.Indirect(value.Index(i)))
+	}
+	return result
+}
+
+// Computes the changed columns between two rows; an invalid row stands for a missing record
+func diff(ctx context.Context, model *schema.Schema, ignore []string, previous, next reflect.Value) map[string]Change {
+	changes := map[string]Change{}
+	for _, field := range model.Fields {
+		if field.DBName == "" || contains(ignore, field.Name) || contains(ignore, field.DBName) {
+			continue
+		}
+		var oldValue, newValue any
+		if previous.IsValid() {
+			oldValue, _ = field.ValueOf(ctx, previous)
+		}
+		if next.IsValid() {
+			newValue, _ = field.ValueOf(ctx, next)
+		}
+		if previous.IsValid() && next.IsValid() && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes[field.DBName] = Change{Old: oldValue, New: newValue}
+	}
+	return changes
+}
+
+// Formats the primary key of a row, joining composite keys with commas
+func recordKey(ctx context.Context, model *schema.Schema, row reflect.Value) string {
+	keys := make([]string, 0, len(model.PrimaryFields))
+	for _, field := range model.PrimaryFields {
+		value, _ := field.ValueOf(ctx, row)
+		keys = append(keys, fmt.Sprint(value))
+	}
+	return strings.Join(keys, ",")
+}
+
+// Reports whether the list contains the value, ignoring case
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
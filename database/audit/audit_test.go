@@ -0,0 +1,280 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+type account struct {
+	ID       uint `gorm:"primaryKey"`
+	Name     string
+	Password string
+}
+
+type membership struct {
+	TeamID uint `gorm:"primaryKey"`
+	UserID uint `gorm:"primaryKey"`
+	Role   string
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// Opens an in-memory database holding the audit table
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	if err := Migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Enables the audit trail for the duration of the test
+func useAudit(t *testing.T, config Config) {
+	t.Helper()
+	Enable(config)
+	t.Cleanup(Disable)
+}
+
+func TestAudited(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		model  any
+		want   bool
+	}{
+		{name: "disabled", model: &account{}},
+		{name: "every table", config: &Config{}, model: &account{}, want: true},
+		{name: "listed table", config: &Config{Tables: []string{"memberships", "accounts"}}, model: &account{}, want: true},
+		{name: "listed table in another case", config: &Config{Tables: []string{"Accounts"}}, model: &[]account{}, want: true},
+		{name: "unlisted table", config: &Config{Tables: []string{"memberships"}}, model: &account{}},
+	}
+	db := newTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != nil {
+				useAudit(t, *tt.config)
+			}
+			if got := Audited(db, tt.model); got != tt.want {
+				t.Fatalf("Audited() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		action      string
+		before      any
+		after       any
+		wantEntries []map[string]Change // Changes of each stored entry
+		wantKeys    []string
+	}{
+		{
+			name:        "create",
+			action:      ActionCreate,
+			after:       &account{ID: 1, Name: "ana", Password: "secret"},
+			wantEntries: []map[string]Change{{"id": {New: float64(1)}, "name": {New: "ana"}, "password": {New: "secret"}}},
+			wantKeys:    []string{"1"},
+		},
+		{
+			name:        "update records the changed fields",
+			action:      ActionUpdate,
+			before:      &account{ID: 1, Name: "ana", Password: "secret"},
+			after:       &account{ID: 1, Name: "ana maria", Password: "secret"},
+			wantEntries: []map[string]Change{{"name": {Old: "ana", New: "ana maria"}}},
+			wantKeys:    []string{"1"},
+		},
+		{
+			name:   "update without changes",
+			action: ActionUpdate,
+			before: &account{ID: 1, Name: "ana"},
+			after:  &account{ID: 1, Name: "ana"},
+		},
+		{
+			name:        "delete",
+			action:      ActionDelete,
+			before:      &account{ID: 1, Name: "ana"},
+			wantEntries: []map[string]Change{{"id": {Old: float64(1)}, "name": {Old: "ana"}, "password": {Old: ""}}},
+			wantKeys:    []string{"1"},
+		},
+		{
+			name:        "ignored fields by struct and column name",
+			config:      Config{Ignore: []string{"Password", "id"}},
+			action:      ActionCreate,
+			after:       &account{ID: 1, Name: "ana", Password: "secret"},
+			wantEntries: []map[string]Change{{"name": {New: "ana"}}},
+			wantKeys:    []string{"1"},
+		},
+		{
+			name:        "slices",
+			config:      Config{Ignore: []string{"id", "password"}},
+			action:      ActionCreate,
+			after:       &[]account{{ID: 1, Name: "ana"}, {ID: 2, Name: "luis"}},
+			wantEntries: []map[string]Change{{"name": {New: "ana"}}, {"name": {New: "luis"}}},
+			wantKeys:    []string{"1", "2"},
+		},
+		{
+			name:        "composite key",
+			config:      Config{Ignore: []string{"team_id", "user_id"}},
+			action:      ActionCreate,
+			after:       &membership{TeamID: 3, UserID: 4, Role: "owner"},
+			wantEntries: []map[string]Change{{"role": {New: "owner"}}},
+			wantKeys:    []string{"3,4"},
+		},
+		{
+			name:   "table not audited",
+			config: Config{Tables: []string{"memberships"}},
+			action: ActionCreate,
+			after:  &account{ID: 1, Name: "ana"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			useAudit(t, tt.config)
+			if err := Record(nil, db, tt.action, tt.before, tt.after); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+			var entries []Entry
+			if err := db.Order("id").Find(&entries).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.wantEntries) {
+				t.Fatalf("Record() stored %d entries, want %d", len(entries), len(tt.wantEntries))
+			}
+			for i, entry := range entries {
+				changes, err := entry.Diff()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry.Action != tt.action || entry.RecordKey != tt.wantKeys[i] || !reflect.DeepEqual(changes, tt.wantEntries[i]) {
+					t.Errorf("entry %d = %s %s %v, want %s %s %v", i, entry.Action, entry.RecordKey, changes, tt.action, tt.wantKeys[i], tt.wantEntries[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecordActor(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		stored   *Actor // Actor stored with SetActor
+		wantID   string
+		wantName string
+	}{
+		{name: "anonymous"},
+		{name: "context actor", stored: &Actor{ID: "7", Name: "ana"}, wantID: "7", wantName: "ana"},
+		{
+			name:     "resolver",
+			config:   Config{Actor: func(ctx *gin.Context) Actor { return Actor{ID: ctx.GetHeader("X-User")} }},
+			stored:   &Actor{ID: "7"},
+			wantID:   "9",
+			wantName: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			useAudit(t, tt.config)
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/accounts", nil)
+			ctx.Request.RemoteAddr = "10.0.0.1:1234"
+			ctx.Request.Header.Set("User-Agent", "tests")
+			ctx.Request.Header.Set("X-User", "9")
+			if tt.stored != nil {
+				SetActor(ctx, *tt.stored)
+			}
+			if err := Record(ctx, db, ActionCreate, nil, &account{ID: 1}); err != nil {
+				t.Fatal(err)
+			}
+			var entry Entry
+			if err := db.Take(&entry).Error; err != nil {
+				t.Fatal(err)
+			}
+			if entry.ActorID != tt.wantID || entry.ActorName != tt.wantName || entry.IP != "10.0.0.1" || entry.UserAgent != "tests" {
+				t.Fatalf("entry = %+v, want actor %q %q, IP 10.0.0.1 and user agent tests", entry, tt.wantID, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	db := newTestDB(t)
+	if err := Record(nil, db, ActionCreate, nil, &account{ID: 1}); err != nil {
+		t.Fatalf("Record() with the audit trail disabled error = %v", err)
+	}
+	useAudit(t, Config{})
+	if err := Record(nil, db, ActionCreate, nil, 42); !errors.Is(err, ErrParsingModel) {
+		t.Fatalf("Record() of a value that is not a model error = %v, want %v", err, ErrParsingModel)
+	}
+	if err := db.Migrator().DropTable(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(nil, db, ActionCreate, nil, &account{ID: 1}); !errors.Is(err, ErrWritingEntry) {
+		t.Fatalf("Record() without the audit table error = %v, want %v", err, ErrWritingEntry)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := newTestDB(t)
+	useAudit(t, Config{})
+	writes := []struct {
+		action        string
+		before, after any
+	}{
+		{ActionCreate, nil, &account{ID: 1, Name: "ana"}},
+		{ActionCreate, nil, &account{ID: 2, Name: "luis"}},
+		{ActionUpdate, &account{ID: 1, Name: "ana"}, &account{ID: 1, Name: "ana maria"}},
+		{ActionDelete, &account{ID: 1, Name: "ana maria"}, nil},
+	}
+	for _, write := range writes {
+		if err := Record(nil, db, write.action, write.before, write.after); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, key, err := Key(db, &account{ID: 1})
+	if err != nil || table != "accounts" || key != "1" {
+		t.Fatalf("Key() = %q %q %v, want accounts 1", table, key, err)
+	}
+	entries, err := History(context.Background(), db, table, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	if !reflect.DeepEqual(actions, []string{ActionCreate, ActionUpdate, ActionDelete}) {
+		t.Fatalf("History() actions = %v, want create, update and delete", actions)
+	}
+
+	encoded, err := json.Marshal(entries[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Action  string            `json:"action"`
+		Changes map[string]Change `json:"changes"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded.Changes["name"] != (Change{Old: "ana", New: "ana maria"}) {
+		t.Fatalf("entry JSON = %s, want the decoded changes", encoded)
+	}
+}
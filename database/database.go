@@ -1,18 +1,18 @@
 package database
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
-	"net"
 	"os"
+	"sort"
 	"time"
 
+	"github.com/nd-tools/capyvel/configuration"
 	"github.com/nd-tools/capyvel/foundation"
 
 	"github.com/gookit/color"
-	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 	"gorm.io/plugin/dbresolver"
 )
@@ -28,167 +28,260 @@ var (
 	ErrConnectionFailed          = errors.New("connection failed")                                                  // Triggered when a database connection cannot be established
 	ErrFailedToGetSQLDB          = errors.New("failed to get sqlDB from gorm.DB")                                   // Triggered when unable to retrieve the SQL database object from Gorm
 	ErrBindingConnection         = errors.New("error binding connection")                                           // Triggered when binding a specific connection fails
+	ErrRegisteringConnections    = errors.New("error registering connections")                                      // Triggered when the DB resolver cannot be applied
 )
 
 // Struct to hold the Gorm database context
 type Database struct {
-	Ctx *gorm.DB
+	Ctx         *gorm.DB
+	defaultName string          // Name of the default connection in the database config
+	connections map[string]bool // Names of the connections registered in the resolver
+	tenants     map[string]bool // Names of the connections declared with `tenant: true`
+	pools       []namedPool     // Pools opened by Boot, reported by Health
 }
 
-// Checks if a given host string is a valid IP address
-func isIPAddress(host string) bool {
-	return net.ParseIP(host) != nil
+// Connection returns a session bound to the named connection of the resolver,
+// or the default connection when the name is empty or is the default one
+func (database Database) Connection(name string) *gorm.DB {
+	if name == "" || name == database.defaultName {
+		return database.Ctx
+	}
+	return database.Ctx.Clauses(dbresolver.Use(name)).Session(&gorm.Session{})
 }
 
-// Builds the DSN (Data Source Name) for SQL Server connections
-func buildDSN(DBServer, DBUsername, DBPassword, DBDatabase, DBSsl, DBCharset string) string {
-	var dsn string
-	if isIPAddress(DBServer) {
-		// Use IP-based connection string
-		dsn = fmt.Sprintf("server=%s;user id=%s;password=%s;database=%s;encrypt=%s;connection timeout=30;charset=%s", DBServer, DBUsername, DBPassword, DBDatabase, DBSsl, DBCharset)
-	} else {
-		// Use DNS-based connection string
-		dsn = fmt.Sprintf("sqlserver://%s:%s@%s?database=%s&encrypt=%s&connection+timeout=30&charset=%s", DBUsername, DBPassword, DBServer, DBDatabase, DBSsl, DBCharset)
-	}
-	return dsn
+// HasConnection reports whether the name is the default connection or a connection registered in the resolver
+func (database Database) HasConnection(name string) bool {
+	return name == database.defaultName || database.connections[name]
 }
 
-// Builds the DSN using configuration data
-func buildDSNFromConfig(connection map[string]interface{}) string {
-	return buildDSN(
-		connection["server"].(string),
-		connection["username"].(string),
-		connection["password"].(string),
-		connection["database"].(string),
-		connection["ssl"].(string),
-		connection["charset"].(string),
-	)
+// IsTenant reports whether the named connection is declared as a tenant with `tenant: true`
+func (database Database) IsTenant(name string) bool {
+	return database.tenants[name] && database.HasConnection(name)
 }
 
-// Initializes the database connections and bootstraps the main configuration
+// DefaultName returns the name of the default connection
+func (database Database) DefaultName() string {
+	return database.defaultName
+}
+
+// Applies the `database.pool` settings to a connection pool
+func configurePool(sqlDB *sql.DB) {
+	if poolConfig, ok := foundation.App.Config.Get("database.pool", nil).(map[string]interface{}); ok {
+		// Set maximum idle connections
+		if maxIdleConns, exists := poolConfig["max_idle_conns"].(int); exists {
+			sqlDB.SetMaxIdleConns(maxIdleConns)
+		}
+		// Set maximum open connections
+		if maxOpenConns, exists := poolConfig["max_open_conns"].(int); exists {
+			sqlDB.SetMaxOpenConns(maxOpenConns)
+		}
+		// Set maximum idle time for connections
+		if connMaxIdleTime, exists := poolConfig["conn_max_idletime"].(int); exists {
+			sqlDB.SetConnMaxIdleTime(time.Duration(connMaxIdleTime) * time.Second)
+		}
+		// Set maximum lifetime for connections
+		if connMaxLifetime, exists := poolConfig["conn_max_lifetime"].(int); exists {
+			sqlDB.SetConnMaxLifetime(time.Duration(connMaxLifetime) * time.Second)
+		}
+	}
+}
+
+// Initializes the database connections, printing the error and exiting the process on failure
 func Boot() {
+	if err := BootE(); err != nil {
+		color.Redln(err)
+		os.Exit(1)
+	}
+}
+
+// Initializes the database connections and bootstraps the main configuration.
+// Every configuration problem is reported at once in a *configuration.Errors.
+func BootE() error {
+	problems := configuration.NewErrors("database")
+	problems.Add(foundation.App.Err())
+
 	// Retrieve all connections from the configuration
 	connections, ok := foundation.App.Config.Get("database.connections", nil).(map[string]interface{})
 	if !ok || connections == nil {
-		color.Redln(ErrNoConnections)
-		os.Exit(1)
+		problems.Add(ErrNoConnections)
 	}
 
 	// Retrieve the default connection name
 	defaultNameConnection, ok := foundation.App.Config.Get("database.default", "").(string)
 	if !ok || defaultNameConnection == "" {
-		color.Redln(ErrNoDefaultConnection)
-		os.Exit(1)
-	}
-
-	// Retrieve the configuration for the default connection
-	connectionMain, ok := connections[defaultNameConnection].(map[string]interface{})
-	if !ok {
-		color.Redln(ErrDefaultConnectionNotFound)
-		os.Exit(1)
+		problems.Add(ErrNoDefaultConnection)
+	} else if connections != nil {
+		// A default connection that is not a map is reported with the other connections
+		if _, ok := connections[defaultNameConnection]; !ok {
+			problems.Add(ErrDefaultConnectionNotFound)
+		}
 	}
 
-	// Build the DSN for the default connection
-	dsnMain := buildDSNFromConfig(connectionMain)
+	// Retrieve the retry settings used to open the connections
+	retryValues, _ := foundation.App.Config.Get("database.retry", nil).(map[string]interface{})
+	retry, err := retryConfigFromConfig(retryValues)
+	problems.Add(err)
 
-	// Determine the logging level based on the app's debug mode
+	// Determine the app's debug mode
 	var debug bool
 	if isDebug, ok := foundation.App.Config.Get("app.debug", true).(bool); ok && isDebug {
 		debug = isDebug
 	}
-	var logLevel logger.LogLevel
-	if debug {
-		logLevel = logger.Info // Debug mode: log info
-	} else {
-		logLevel = logger.Silent // Production mode: log only errors
+
+	// Build the query logger (slow queries and metrics) from the logging settings
+	loggingValues, _ := foundation.App.Config.Get("database.logging", nil).(map[string]interface{})
+	queryLogger, err := queryLoggerFromConfig(loggingValues, debug)
+	problems.Add(err)
+
+	// Build the dialector of the default connection and the resolver config of every connection
+	// in a stable order, so that all problems are collected before opening anything
+	names := make([]string, 0, len(connections))
+	for nameConnection := range connections {
+		names = append(names, nameConnection)
 	}
+	sort.Strings(names)
+
+	var dialectorMain gorm.Dialector
+	var resolverConfigs []dbresolver.Config
+	var resolverDatas [][]interface{}
+	var resolverNames []string
+	tenantNames := map[string]bool{}
+	for _, nameConnection := range names {
+		connectionMap, ok := connections[nameConnection].(map[string]interface{})
+		if !ok {
+			problems.Add(fmt.Errorf("%w: %s", ErrBindingConnection, nameConnection))
+			continue
+		}
+		// Only the connections declared as tenants may be selected by a tenant resolver
+		if tenant, exists := connectionMap["tenant"]; exists {
+			isTenant, ok := tenant.(bool)
+			if !ok {
+				problems.Add(fmt.Errorf("%w: %s: tenant must be a bool", ErrBindingConnection, nameConnection))
+				continue
+			}
+			tenantNames[nameConnection] = isTenant
+		}
 
-	// Open the main database connection
-	db, err := gorm.Open(sqlserver.Open(dsnMain), &gorm.Config{
-		Logger: logger.Default.LogMode(logLevel),
-		NamingStrategy: schema.NamingStrategy{
-			SingularTable: true, // Use singular table names
-			NoLowerCase:   true, // Keep case-sensitive table names
-		},
+		// Build the dialector for the default connection according to its driver
+		isDefault := nameConnection == defaultNameConnection
+		if isDefault {
+			dialector, err := dialectorFromConfig(connectionMap)
+			if err != nil {
+				problems.Add(fmt.Errorf("%w: %s: %w", ErrBindingConnection, nameConnection, err))
+				continue
+			}
+			dialectorMain = dialector
+		}
+
+		// Register the replicas of the default connection as the global resolver,
+		// and every additional connection (non-default) with its own sources and replicas
+		if isDefault && connectionMap["replicas"] == nil {
+			continue
+		}
+		config, err := resolverConfigFromConfig(connectionMap, !isDefault, debug)
+		if err != nil {
+			problems.Add(fmt.Errorf("%w: %s: %w", ErrBindingConnection, nameConnection, err))
+			continue
+		}
+		var datas []interface{}
+		if !isDefault {
+			datas, _ = connectionMap["datas"].([]interface{})
+			datas = append(datas, nameConnection)
+		}
+		resolverConfigs = append(resolverConfigs, config)
+		resolverDatas = append(resolverDatas, datas)
+		resolverNames = append(resolverNames, nameConnection)
+	}
+
+	if err := problems.Err(); err != nil {
+		return err
+	}
+
+	// Open the main database connection, retrying while it is not reachable
+	var db *gorm.DB
+	err = retry.Run("opening connection "+defaultNameConnection, func() error {
+		var err error
+		db, err = gorm.Open(dialectorMain, &gorm.Config{
+			Logger:  queryLogger,
+			Plugins: queryLogger.plugins(),
+			NamingStrategy: schema.NamingStrategy{
+				SingularTable: true, // Use singular table names
+				NoLowerCase:   true, // Keep case-sensitive table names
+			},
+		})
+		return err
 	})
 	if err != nil {
-		color.Redf("%s: %s: %v\n", ErrConnectionFailed, defaultNameConnection, err)
-		os.Exit(1)
+		return fmt.Errorf("%w: %s: %w", ErrConnectionFailed, defaultNameConnection, err)
 	}
 
 	// Configure the connection pool
 	sqlDB, err := db.DB()
 	if err != nil {
-		color.Redf("%s: %v\n", ErrFailedToGetSQLDB, err)
-		os.Exit(1)
+		return fmt.Errorf("%w: %v", ErrFailedToGetSQLDB, err)
 	}
 
-	poolConfig, ok := foundation.App.Config.Get("database.pool", nil).(map[string]interface{})
-	if ok {
-		// Set maximum idle connections
-		if maxIdleConns, exists := poolConfig["max_idle_conns"].(int); exists {
-			sqlDB.SetMaxIdleConns(maxIdleConns)
-		}
-		// Set maximum open connections
-		if maxOpenConns, exists := poolConfig["max_open_conns"].(int); exists {
-			sqlDB.SetMaxOpenConns(maxOpenConns)
-		}
-		// Set maximum idle time for connections
-		if connMaxIdleTime, exists := poolConfig["conn_max_idletime"].(int); exists {
-			sqlDB.SetConnMaxIdleTime(time.Duration(connMaxIdleTime) * time.Second)
+	configurePool(sqlDB)
+
+	// Wait until every resolver connection is reachable before registering it
+	for i, config := range resolverConfigs {
+		if retry.MaxAttempts == 1 {
+			break
 		}
-		// Set maximum lifetime for connections
-		if connMaxLifetime, exists := poolConfig["conn_max_lifetime"].(int); exists {
-			sqlDB.SetConnMaxLifetime(time.Duration(connMaxLifetime) * time.Second)
+		dialectors := append(append([]gorm.Dialector{}, config.Sources...), config.Replicas...)
+		for _, dialector := range dialectors {
+			operation := "registering resolver connection " + resolverNames[i]
+			if err := retry.Run(operation, func() error { return pingDialector(dialector) }); err != nil {
+				return fmt.Errorf("%w: %w", ErrRegisteringConnections, err)
+			}
 		}
 	}
 
 	// Initialize the DB resolver for multi-database configurations
 	var resolver *dbresolver.DBResolver
-
-	for nameConnection, connection := range connections {
-		connectionMap, ok := connection.(map[string]interface{})
-		if !ok {
-			color.Redf("%s: %s\n", ErrBindingConnection, nameConnection)
-			os.Exit(1)
-		}
-
-		// Register additional connections (non-default)
-		if nameConnection != defaultNameConnection {
-			dsn := buildDSNFromConfig(connectionMap)
-			datas := connectionMap["datas"].([]interface{})
-			datas = append(datas, nameConnection)
-
-			// Initialize resolver if it hasn't been created yet
-			if resolver == nil {
-				resolver = dbresolver.Register(dbresolver.Config{
-					Sources:           []gorm.Dialector{sqlserver.Open(dsn)},
-					Policy:            dbresolver.RandomPolicy{},
-					TraceResolverMode: debug,
-				}, datas...)
-			} else {
-				// Register additional configurations into the resolver
-				resolver = resolver.Register(dbresolver.Config{
-					Sources:           []gorm.Dialector{sqlserver.Open(dsn)},
-					Policy:            dbresolver.RandomPolicy{},
-					TraceResolverMode: debug,
-				}, datas...)
-			}
+	for i, config := range resolverConfigs {
+		// Initialize resolver if it hasn't been created yet
+		if resolver == nil {
+			resolver = dbresolver.Register(config, resolverDatas[i]...)
+		} else {
+			// Register additional configurations into the resolver
+			resolver = resolver.Register(config, resolverDatas[i]...)
 		}
 	}
 
-	// Apply resolver if defined
+	// Apply resolver if defined, collecting the pools it opens for Health
+	var connPools []gorm.ConnPool
 	if resolver != nil {
+		resolver.Call(func(connPool gorm.ConnPool) error {
+			connPools = append(connPools, connPool)
+			return nil
+		})
 		if err := db.Use(resolver); err != nil {
-			color.Redf("Error registering connections: %v\n", err)
-			os.Exit(1)
-		} else {
-			color.Greenf("Connections registered successfully.\n")
+			return fmt.Errorf("%w: %v", ErrRegisteringConnections, err)
 		}
+		color.Greenf("Connections registered successfully.\n")
 	} else {
 		color.Yellowf("No connections to register.\n")
 	}
 
 	// Assign the initialized database to the global `DB` variable
-	DB = Database{Ctx: db}
+	registered := make(map[string]bool, len(resolverNames))
+	for _, name := range resolverNames {
+		registered[name] = true
+	}
+	pools := append([]namedPool{{name: defaultNameConnection, role: RoleSource, db: sqlDB}},
+		resolverPools(resolverNames, resolverConfigs, connPools)...)
+	DB = Database{Ctx: db, defaultName: defaultNameConnection, connections: registered, tenants: tenantNames, pools: pools}
+	tenants.open()
+	return nil
+}
+
+// Close closes every pool opened by Boot and the lazily opened tenant pools,
+// e.g. from a Router.OnShutdown hook
+func Close() error {
+	errs := []error{CloseTenants()}
+	for _, pool := range DB.pools {
+		errs = append(errs, pool.db.Close())
+	}
+	return errors.Join(errs...)
 }
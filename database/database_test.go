@@ -0,0 +1,107 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/foundation"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// Boots DB on an in-memory database for the duration of the test
+func useTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := DB
+	DB = Database{Ctx: db, defaultName: "main", pools: []namedPool{{name: "main", role: RoleSource, db: sqlDB}}}
+	t.Cleanup(func() { DB = previous })
+	return db
+}
+
+// Replaces a top-level key of the application config for the duration of the test
+func setConfig(t *testing.T, name string, value any) {
+	t.Helper()
+	configurations := *foundation.App.Config.Configurations
+	previous, existed := configurations[name]
+	configurations[name] = value
+	t.Cleanup(func() {
+		if existed {
+			configurations[name] = previous
+		} else {
+			delete(configurations, name)
+		}
+	})
+}
+
+// Clears the env loading error of the test binary, which has no .env, so that BootE runs
+func useBootableApp(t *testing.T) {
+	t.Helper()
+	previous := foundation.App
+	foundation.App = foundation.Application{Config: previous.Config}
+	t.Cleanup(func() { foundation.App = previous })
+}
+
+// Restores DB and closes the pools opened by BootE at the end of the test
+func restoreDBAfterBoot(t *testing.T) {
+	t.Helper()
+	previous := DB
+	t.Cleanup(func() {
+		if DB.Ctx != previous.Ctx {
+			Close()
+		}
+		DB = previous
+	})
+}
+
+func TestBootEReportsEveryProblem(t *testing.T) {
+	useBootableApp(t)
+	restoreDBAfterBoot(t)
+	setConfig(t, "database", map[string]interface{}{
+		"default": "main",
+		"connections": map[string]interface{}{
+			"main":    map[string]interface{}{"driver": "oracle"},
+			"reports": map[string]interface{}{"driver": DriverSqlite, "policy": "nearest"},
+		},
+		"retry": map[string]interface{}{"max_attempts": 0},
+	})
+	err := BootE()
+	var problems *configuration.Errors
+	if !errors.As(err, &problems) || len(problems.Problems) != 3 {
+		t.Fatalf("BootE() error = %v, want 3 problems", err)
+	}
+	for _, want := range []error{ErrInvalidRetryConfig, ErrBindingConnection, ErrUnsupportedDriver, ErrUnknownPolicy} {
+		if !errors.Is(err, want) {
+			t.Errorf("BootE() error = %v, want %v", err, want)
+		}
+	}
+	for _, want := range []string{"max_attempts", "main", "reports"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("BootE() error = %q, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestBootEReportsTheEnvError(t *testing.T) {
+	if foundation.App.Err() == nil {
+		t.Skip("the test binary loaded an env file")
+	}
+	setConfig(t, "database", map[string]interface{}{})
+	err := BootE()
+	if !errors.Is(err, foundation.App.Err()) || !errors.Is(err, ErrNoConnections) || !errors.Is(err, ErrNoDefaultConnection) {
+		t.Fatalf("BootE() error = %v, want the env error along with the missing connections", err)
+	}
+}
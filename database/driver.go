@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nd-tools/capyvel/foundation"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+)
+
+// Supported database drivers for the `driver` key of a connection
+const (
+	DriverSqlserver = "sqlserver"
+	DriverPostgres  = "postgres"
+	DriverMysql     = "mysql"
+	DriverSqlite    = "sqlite"
+
+	// Driver used when a connection does not declare one
+	DefaultDriver = DriverSqlserver
+)
+
+var (
+	ErrUnsupportedDriver = errors.New("unsupported database driver") // Triggered when a connection declares an unknown driver
+)
+
+// Driver groups the DSN builder and the Gorm dialector constructor of a database engine
+type Driver struct {
+	BuildDSN func(connection map[string]interface{}) string // Builds the DSN from the connection config
+	Open     func(dsn string) gorm.Dialector                // Opens the Gorm dialector for the DSN
+}
+
+// drivers holds every supported driver indexed by name
+var drivers = map[string]Driver{
+	DriverSqlserver: {BuildDSN: buildDSNFromConfig, Open: sqlserver.Open},
+	DriverPostgres:  {BuildDSN: buildPostgresDSNFromConfig, Open: postgres.Open},
+	DriverMysql:     {BuildDSN: buildMysqlDSNFromConfig, Open: mysql.Open},
+	DriverSqlite:    {BuildDSN: buildSqliteDSNFromConfig, Open: sqlite.Open},
+}
+
+// Returns the driver name declared in the connection config, falling back to DefaultDriver
+func driverName(connection map[string]interface{}) string {
+	name := strings.ToLower(configString(connection, "driver", DefaultDriver))
+	if name == "" {
+		return DefaultDriver
+	}
+	return name
+}
+
+// ConnectionDriver returns the driver declared for the named connection in the database config.
+// An empty name refers to the default connection.
+func ConnectionDriver(name string) string {
+	if name == "" {
+		name, _ = foundation.App.Config.Get("database.default", "").(string)
+	}
+	connection, _ := foundation.App.Config.Get("database.connections."+name, nil).(map[string]interface{})
+	return driverName(connection)
+}
+
+// Builds the Gorm dialector for a connection according to its `driver` key
+func dialectorFromConfig(connection map[string]interface{}) (gorm.Dialector, error) {
+	name := driverName(connection)
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, name)
+	}
+	return driver.Open(driver.BuildDSN(connection)), nil
+}
+
+// Reads a string value from a connection config, returning the default when missing or of another type
+func configString(connection map[string]interface{}, key, defaultValue string) string {
+	if value, ok := connection[key].(string); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// Reads a bool value from a connection config, returning the default when missing or of another type
+func configBool(connection map[string]interface{}, key string, defaultValue bool) bool {
+	if value, ok := connection[key].(bool); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// Reads an int value from a connection config, returning the default when missing or of another type
+func configInt(connection map[string]interface{}, key string, defaultValue int) int {
+	if value, ok := connection[key].(int); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// Builds the DSN for SQLite connections, where `database` is the file path or ":memory:"
+func buildSqliteDSNFromConfig(connection map[string]interface{}) string {
+	return configString(connection, "database", "file::memory:?cache=shared")
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+)
+
+func TestDriverName(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection map[string]interface{}
+		want       string
+	}{
+		{name: "missing config", want: DriverSqlserver},
+		{name: "missing driver", connection: map[string]interface{}{}, want: DriverSqlserver},
+		{name: "empty driver", connection: map[string]interface{}{"driver": ""}, want: DriverSqlserver},
+		{name: "driver of another type", connection: map[string]interface{}{"driver": 1}, want: DriverSqlserver},
+		{name: "declared driver", connection: map[string]interface{}{"driver": "Postgres"}, want: DriverPostgres},
+		{name: "unknown driver", connection: map[string]interface{}{"driver": "oracle"}, want: "oracle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := driverName(tt.connection); got != tt.want {
+				t.Fatalf("driverName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialectorFromConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection map[string]interface{}
+		want       string // Name of the dialector
+		wantDSN    string // DSN of the SQLite dialectors
+		wantErr    error
+	}{
+		{name: "default driver", connection: map[string]interface{}{"database": "app"}, want: "sqlserver"},
+		{name: "postgres", connection: map[string]interface{}{"driver": DriverPostgres, "database": "app"}, want: "postgres"},
+		{name: "mysql", connection: map[string]interface{}{"driver": DriverMysql, "database": "app"}, want: "mysql"},
+		{name: "sqlite default database", connection: map[string]interface{}{"driver": DriverSqlite}, want: "sqlite", wantDSN: "file::memory:?cache=shared"},
+		{name: "sqlite file", connection: map[string]interface{}{"driver": DriverSqlite, "database": "data/app.db"}, want: "sqlite", wantDSN: "data/app.db"},
+		{name: "unsupported driver", connection: map[string]interface{}{"driver": "oracle"}, wantErr: ErrUnsupportedDriver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialector, err := dialectorFromConfig(tt.connection)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("dialectorFromConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if dialector.Name() != tt.want {
+				t.Fatalf("dialectorFromConfig() = %s, want %s", dialector.Name(), tt.want)
+			}
+			if tt.wantDSN != "" && dialector.(*sqlite.Dialector).DSN != tt.wantDSN {
+				t.Fatalf("DSN = %q, want %q", dialector.(*sqlite.Dialector).DSN, tt.wantDSN)
+			}
+		})
+	}
+}
+
+func TestConnectionDriver(t *testing.T) {
+	setConfig(t, "database", map[string]interface{}{
+		"default": "main",
+		"connections": map[string]interface{}{
+			"main":    map[string]interface{}{"driver": DriverPostgres},
+			"reports": map[string]interface{}{"driver": DriverMysql},
+			"legacy":  map[string]interface{}{},
+		},
+	})
+	tests := []struct {
+		connection string
+		want       string
+	}{
+		{connection: "", want: DriverPostgres},
+		{connection: "reports", want: DriverMysql},
+		{connection: "legacy", want: DriverSqlserver},
+		{connection: "missing", want: DriverSqlserver},
+	}
+	for _, tt := range tests {
+		if got := ConnectionDriver(tt.connection); got != tt.want {
+			t.Errorf("ConnectionDriver(%q) = %q, want %q", tt.connection, got, tt.want)
+		}
+	}
+}
+
+func TestBootSqliteSourcesAndReplicas(t *testing.T) {
+	useBootableApp(t)
+	restoreDBAfterBoot(t)
+	memory := func(name string) map[string]interface{} {
+		return map[string]interface{}{"database": "file:boot_" + name + "?mode=memory&cache=shared"}
+	}
+	setConfig(t, "database", map[string]interface{}{
+		"default": "main",
+		"connections": map[string]interface{}{
+			"main": map[string]interface{}{
+				"driver":   DriverSqlite,
+				"database": "file:boot_main?mode=memory&cache=shared",
+				"replicas": []interface{}{memory("main_replica")},
+			},
+			"reports": map[string]interface{}{
+				"driver":   DriverSqlite,
+				"database": "file:boot_reports?mode=memory&cache=shared",
+				"replicas": []interface{}{memory("reports_replica_1"), memory("reports_replica_2")},
+				"policy":   PolicyRoundRobin,
+			},
+		},
+	})
+	if err := BootE(); err != nil {
+		t.Fatalf("BootE() error = %v", err)
+	}
+
+	if DB.Ctx.Dialector.Name() != "sqlite" || DB.DefaultName() != "main" || !DB.HasConnection("reports") {
+		t.Fatalf("DB = %+v, want the sqlite main connection and the reports connection", DB)
+	}
+	var roles []string
+	for _, pool := range DB.pools {
+		roles = append(roles, pool.name+" "+pool.role)
+	}
+	want := []string{"main " + RoleSource, "main " + RoleReplica, "reports " + RoleSource, "reports " + RoleReplica, "reports " + RoleReplica}
+	if len(roles) != len(want) {
+		t.Fatalf("pools = %q, want %q", roles, want)
+	}
+	for i := range want {
+		if roles[i] != want[i] {
+			t.Fatalf("pools = %q, want %q", roles, want)
+		}
+	}
+	if report := Health(context.Background()); !report.Healthy() {
+		t.Fatalf("Health() = %+v, want every pool up", report)
+	}
+	if err := DB.Connection("reports").Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("query on the reports connection: %v", err)
+	}
+}
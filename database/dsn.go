@@ -0,0 +1,183 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Default connection timeout, in seconds, of SQL Server connections
+const DefaultConnectionTimeout = 30
+
+// SqlServerOptions holds the connection options of a SQL Server connection
+type SqlServerOptions struct {
+	Server                 string            // Host name or IP address
+	Port                   int               // TCP port, omitted when 0
+	Instance               string            // Named instance (e.g. SQLEXPRESS)
+	Username               string            // User id
+	Password               string            // Password, escaped when building the DSN
+	Database               string            // Database name
+	Encrypt                string            // Encryption mode: true, false or disable
+	Charset                string            // Connection charset
+	AppName                string            // Application name reported to the server
+	FailoverPartner        string            // Failover partner for database mirroring
+	TrustServerCertificate bool              // Skip the validation of the server certificate
+	ConnectionTimeout      int               // Connection timeout in seconds
+	Params                 map[string]string // Additional driver parameters passed through as-is
+}
+
+// Builds the SQL Server options from the connection config
+func sqlServerOptionsFromConfig(connection map[string]interface{}) SqlServerOptions {
+	return SqlServerOptions{
+		Server:                 configString(connection, "server", "localhost"),
+		Port:                   configInt(connection, "port", 0),
+		Instance:               configString(connection, "instance", ""),
+		Username:               configString(connection, "username", ""),
+		Password:               configString(connection, "password", ""),
+		Database:               configString(connection, "database", ""),
+		Encrypt:                configString(connection, "ssl", ""),
+		Charset:                configString(connection, "charset", ""),
+		AppName:                configString(connection, "app_name", ""),
+		FailoverPartner:        configString(connection, "failover_partner", ""),
+		TrustServerCertificate: configBool(connection, "trust_server_certificate", false),
+		ConnectionTimeout:      configInt(connection, "connection_timeout", DefaultConnectionTimeout),
+		Params:                 configParams(connection),
+	}
+}
+
+// DSN builds the URL-encoded DSN (Data Source Name) of the SQL Server connection
+func (options SqlServerOptions) DSN() string {
+	query := url.Values{}
+	setParam(query, "database", options.Database)
+	setParam(query, "encrypt", options.Encrypt)
+	setParam(query, "charset", options.Charset)
+	setParam(query, "app name", options.AppName)
+	setParam(query, "failoverpartner", options.FailoverPartner)
+	if options.TrustServerCertificate {
+		query.Set("TrustServerCertificate", "true")
+	}
+	if options.ConnectionTimeout > 0 {
+		query.Set("connection timeout", strconv.Itoa(options.ConnectionTimeout))
+	}
+	for key, value := range options.Params {
+		query.Set(key, value)
+	}
+
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(options.Username, options.Password),
+		Host:     hostPort(options.Server, options.Port),
+		RawQuery: query.Encode(),
+	}
+	if options.Instance != "" {
+		dsn.Path = options.Instance
+	}
+	return dsn.String()
+}
+
+// Builds the DSN of SQL Server connections using configuration data
+func buildDSNFromConfig(connection map[string]interface{}) string {
+	return sqlServerOptionsFromConfig(connection).DSN()
+}
+
+// Builds the URL-encoded DSN for PostgreSQL connections
+func buildPostgresDSNFromConfig(connection map[string]interface{}) string {
+	query := url.Values{}
+	query.Set("sslmode", postgresSSLMode(connection))
+	query.Set("TimeZone", configString(connection, "timezone", "UTC"))
+	if timeout := configInt(connection, "connection_timeout", 0); timeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(timeout))
+	}
+	setParam(query, "application_name", configString(connection, "app_name", ""))
+	for key, value := range configParams(connection) {
+		query.Set(key, value)
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(configString(connection, "username", ""), configString(connection, "password", "")),
+		Host:     hostPort(configString(connection, "server", "localhost"), configInt(connection, "port", 5432)),
+		Path:     "/" + configString(connection, "database", ""),
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// Returns the sslmode of a PostgreSQL connection from its `sslmode` key, or else from the `ssl` key
+// shared with SQL Server, where true means require and false means disable
+func postgresSSLMode(connection map[string]interface{}) string {
+	if mode := configString(connection, "sslmode", ""); mode != "" {
+		return mode
+	}
+	if ssl, ok := connection["ssl"].(bool); ok {
+		if ssl {
+			return "require"
+		}
+		return "disable"
+	}
+	switch ssl := configString(connection, "ssl", ""); ssl {
+	case "", "false":
+		return "disable"
+	case "true":
+		return "require"
+	default:
+		return ssl
+	}
+}
+
+// Builds the DSN for MySQL connections with the driver's own formatter
+func buildMysqlDSNFromConfig(connection map[string]interface{}) string {
+	config := mysql.NewConfig()
+	config.User = configString(connection, "username", "")
+	config.Passwd = configString(connection, "password", "")
+	config.Net = "tcp"
+	config.Addr = hostPort(configString(connection, "server", "localhost"), configInt(connection, "port", 3306))
+	config.DBName = configString(connection, "database", "")
+	config.ParseTime = true
+	config.Loc = time.Local
+	config.Params = map[string]string{"charset": configString(connection, "charset", "utf8mb4")}
+	if timeout := configInt(connection, "connection_timeout", 0); timeout > 0 {
+		config.Timeout = time.Duration(timeout) * time.Second
+	}
+	for key, value := range configParams(connection) {
+		config.Params[key] = value
+	}
+	return config.FormatDSN()
+}
+
+// Joins the host and the port, bracketing IPv6 addresses
+func hostPort(host string, port int) string {
+	if port > 0 {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// Sets a query parameter only when its value is not empty
+func setParam(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// Reads the `params` key of a connection config, used to pass arbitrary parameters to the driver
+func configParams(connection map[string]interface{}) map[string]string {
+	params := map[string]string{}
+	switch values := connection["params"].(type) {
+	case map[string]string:
+		for key, value := range values {
+			params[key] = value
+		}
+	case map[string]interface{}:
+		for key, value := range values {
+			params[key] = fmt.Sprint(value)
+		}
+	}
+	return params
+}
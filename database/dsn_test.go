@@ -0,0 +1,214 @@
+package database
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Password with every character that breaks a naively formatted DSN
+const trickyPassword = "p@ss:w/rd?&=#% ;"
+
+func TestSqlServerDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection map[string]interface{}
+		wantHost   string
+		wantPath   string
+		wantQuery  map[string]string
+	}{
+		{
+			name:       "defaults",
+			connection: map[string]interface{}{"username": "sa", "password": trickyPassword, "database": "app"},
+			wantHost:   "localhost",
+			wantQuery:  map[string]string{"database": "app", "connection timeout": "30"},
+		},
+		{
+			name: "port, instance and options",
+			connection: map[string]interface{}{
+				"server": "db.local", "port": 1433, "instance": "SQLEXPRESS", "username": "sa", "password": trickyPassword,
+				"database": "app", "ssl": "true", "app_name": "my app", "trust_server_certificate": true, "connection_timeout": 5,
+			},
+			wantHost:  "db.local:1433",
+			wantPath:  "/SQLEXPRESS",
+			wantQuery: map[string]string{"encrypt": "true", "app name": "my app", "TrustServerCertificate": "true", "connection timeout": "5"},
+		},
+		{
+			name:       "ipv6 without port",
+			connection: map[string]interface{}{"server": "::1", "password": trickyPassword},
+			wantHost:   "[::1]",
+		},
+		{
+			name: "passthrough params",
+			connection: map[string]interface{}{
+				"password": trickyPassword,
+				"params":   map[string]interface{}{"dial timeout": 10, "log": "63"},
+			},
+			wantHost:  "localhost",
+			wantQuery: map[string]string{"dial timeout": "10", "log": "63"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := buildDSNFromConfig(tt.connection)
+			parsed, err := url.Parse(dsn)
+			if err != nil {
+				t.Fatalf("DSN %q does not parse: %v", dsn, err)
+			}
+			if password, _ := parsed.User.Password(); password != trickyPassword {
+				t.Errorf("password = %q, want %q", password, trickyPassword)
+			}
+			if parsed.Scheme != "sqlserver" || parsed.Host != tt.wantHost || parsed.Path != tt.wantPath {
+				t.Errorf("DSN %q, want host %q and path %q", dsn, tt.wantHost, tt.wantPath)
+			}
+			query := parsed.Query()
+			for key, want := range tt.wantQuery {
+				if got := query.Get(key); got != want {
+					t.Errorf("param %q = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection map[string]interface{}
+		wantHost   string
+		wantQuery  map[string]string
+	}{
+		{
+			name:       "defaults",
+			connection: map[string]interface{}{"username": "app", "password": trickyPassword, "database": "app"},
+			wantHost:   "localhost:5432",
+			wantQuery:  map[string]string{"sslmode": "disable", "TimeZone": "UTC"},
+		},
+		{
+			name: "options and params",
+			connection: map[string]interface{}{
+				"server": "::1", "port": 6432, "password": trickyPassword, "database": "app", "ssl": "require",
+				"timezone": "America/Mexico_City", "connection_timeout": 3, "app_name": "api",
+				"params": map[string]string{"search_path": "tenant,public"},
+			},
+			wantHost: "[::1]:6432",
+			wantQuery: map[string]string{
+				"sslmode": "require", "TimeZone": "America/Mexico_City", "connect_timeout": "3",
+				"application_name": "api", "search_path": "tenant,public",
+			},
+		},
+		{
+			name:       "ssl true",
+			connection: map[string]interface{}{"password": trickyPassword, "database": "app", "ssl": "true"},
+			wantHost:   "localhost:5432",
+			wantQuery:  map[string]string{"sslmode": "require"},
+		},
+		{
+			name:       "ssl false",
+			connection: map[string]interface{}{"password": trickyPassword, "database": "app", "ssl": "false"},
+			wantHost:   "localhost:5432",
+			wantQuery:  map[string]string{"sslmode": "disable"},
+		},
+		{
+			name:       "ssl bool",
+			connection: map[string]interface{}{"password": trickyPassword, "database": "app", "ssl": true},
+			wantHost:   "localhost:5432",
+			wantQuery:  map[string]string{"sslmode": "require"},
+		},
+		{
+			name:       "sslmode over ssl",
+			connection: map[string]interface{}{"password": trickyPassword, "database": "app", "ssl": "true", "sslmode": "verify-full"},
+			wantHost:   "localhost:5432",
+			wantQuery:  map[string]string{"sslmode": "verify-full"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := buildPostgresDSNFromConfig(tt.connection)
+			parsed, err := url.Parse(dsn)
+			if err != nil {
+				t.Fatalf("DSN %q does not parse: %v", dsn, err)
+			}
+			if password, _ := parsed.User.Password(); password != trickyPassword {
+				t.Errorf("password = %q, want %q", password, trickyPassword)
+			}
+			if parsed.Host != tt.wantHost || parsed.Path != "/app" {
+				t.Errorf("DSN %q, want host %q and database app", dsn, tt.wantHost)
+			}
+			query := parsed.Query()
+			for key, want := range tt.wantQuery {
+				if got := query.Get(key); got != want {
+					t.Errorf("param %q = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMysqlDSN(t *testing.T) {
+	tests := []struct {
+		name        string
+		connection  map[string]interface{}
+		wantAddr    string
+		wantTimeout time.Duration
+		wantParams  map[string]string
+	}{
+		{
+			name:       "defaults",
+			connection: map[string]interface{}{"username": "app", "password": trickyPassword, "database": "app"},
+			wantAddr:   "localhost:3306",
+			wantParams: map[string]string{"charset": "utf8mb4"},
+		},
+		{
+			name: "options and params",
+			connection: map[string]interface{}{
+				"server": "db.local", "port": 3307, "username": "app", "password": trickyPassword, "database": "app",
+				"charset": "latin1", "connection_timeout": 4, "params": map[string]interface{}{"sql_mode": "'ANSI'"},
+			},
+			wantAddr:    "db.local:3307",
+			wantTimeout: 4 * time.Second,
+			wantParams:  map[string]string{"charset": "latin1", "sql_mode": "'ANSI'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := buildMysqlDSNFromConfig(tt.connection)
+			config, err := mysql.ParseDSN(dsn)
+			if err != nil {
+				t.Fatalf("DSN %q does not parse: %v", dsn, err)
+			}
+			if config.Passwd != trickyPassword || config.User != "app" || config.DBName != "app" {
+				t.Errorf("DSN %q, want user app, database app and the password", dsn)
+			}
+			if config.Addr != tt.wantAddr || config.Timeout != tt.wantTimeout || !config.ParseTime {
+				t.Errorf("DSN %q, want address %q and timeout %s", dsn, tt.wantAddr, tt.wantTimeout)
+			}
+			for key, want := range tt.wantParams {
+				if got := config.Params[key]; got != want {
+					t.Errorf("param %q = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		want string
+	}{
+		{host: "localhost", want: "localhost"},
+		{host: "localhost", port: 1433, want: "localhost:1433"},
+		{host: "10.0.0.1", want: "10.0.0.1"},
+		{host: "::1", want: "[::1]"},
+		{host: "::1", port: 5432, want: "[::1]:5432"},
+	}
+	for _, tt := range tests {
+		if got := hostPort(tt.host, tt.port); got != tt.want {
+			t.Errorf("hostPort(%q, %d) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package database
+
+import "gorm.io/gorm/logger"
+
+// NewTestQueryLogger returns a QueryLogger writing every query to the writer, for the tests of
+// the database_test package
+func NewTestQueryLogger(writer logger.Writer) *QueryLogger {
+	return &QueryLogger{
+		Interface: logger.Discard,
+		writer:    writer,
+		config:    logger.Config{LogLevel: logger.Info},
+		metrics:   NewMetrics(DefaultBuckets),
+	}
+}
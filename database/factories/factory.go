@@ -0,0 +1,317 @@
+package factories
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/nd-tools/capyvel/database"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var (
+	ErrModelNotStruct     = errors.New("factory model must be a struct")       // Triggered when T is not a struct
+	ErrFieldNotFound      = errors.New("field not found in the factory model") // Triggered when an attribute names an unknown field
+	ErrFieldNotAssignable = errors.New("value is not assignable to the field") // Triggered when a generator returns a value of another type
+	ErrCreatingModel      = errors.New("error creating factory model")         // Triggered when persisting a model fails
+)
+
+// Generator produces the value of an attribute. The sequence starts at 1 and grows with every model built.
+type Generator func(sequence int) any
+
+// Attributes maps struct field names to their generators
+type Attributes map[string]Generator
+
+// Related is implemented by every Factory so it can be used as a parent or child of another one
+type Related interface {
+	createRelated(db *gorm.DB, values map[string]any) ([]reflect.Value, error)
+	single() Related
+}
+
+// Factory builds and persists models of type T
+type Factory[T any] struct {
+	definition Attributes
+	overrides  Attributes
+	states     []func(model *T)
+	count      int
+	db         *gorm.DB
+	parents    []parentRelation
+	children   []childRelation
+	sequence   *int64
+}
+
+// Parent model created before each model, whose key is copied into the foreign key of the model
+type parentRelation struct {
+	factory    Related
+	foreignKey string
+	parentKey  string
+}
+
+// Child models created after each model, whose foreign key receives the key of the model
+type childRelation struct {
+	factory    Related
+	foreignKey string
+	localKey   string
+}
+
+// New creates a factory from the default attribute generators of the model
+func New[T any](definition Attributes) *Factory[T] {
+	return &Factory[T]{
+		definition: definition,
+		count:      1,
+		sequence:   new(int64),
+	}
+}
+
+// clone returns a copy of the factory so that chained calls do not modify the original one
+func (f *Factory[T]) clone() *Factory[T] {
+	copied := *f
+	copied.overrides = Attributes{}
+	for name, generator := range f.overrides {
+		copied.overrides[name] = generator
+	}
+	copied.states = append([]func(model *T){}, f.states...)
+	copied.parents = append([]parentRelation{}, f.parents...)
+	copied.children = append([]childRelation{}, f.children...)
+	return &copied
+}
+
+// With overrides attribute generators of the definition
+func (f *Factory[T]) With(attributes Attributes) *Factory[T] {
+	copied := f.clone()
+	for name, generator := range attributes {
+		copied.overrides[name] = generator
+	}
+	return copied
+}
+
+// State applies a function to every model after its attributes are generated
+func (f *Factory[T]) State(state func(model *T)) *Factory[T] {
+	copied := f.clone()
+	copied.states = append(copied.states, state)
+	return copied
+}
+
+// Count sets how many models are built or created, none when it is negative
+func (f *Factory[T]) Count(count int) *Factory[T] {
+	copied := f.clone()
+	copied.count = max(count, 0)
+	return copied
+}
+
+// On sets the connection used to persist the models, database.DB.Ctx by default
+func (f *Factory[T]) On(db *gorm.DB) *Factory[T] {
+	copied := f.clone()
+	copied.db = db
+	return copied
+}
+
+// For creates a parent model with the given factory before each model,
+// copying the parentKey field of the parent into the foreignKey field of the model.
+// The parent factory creates a single model whatever its Count.
+func (f *Factory[T]) For(parent Related, foreignKey, parentKey string) *Factory[T] {
+	copied := f.clone()
+	copied.parents = append(copied.parents, parentRelation{factory: parent.single(), foreignKey: foreignKey, parentKey: parentKey})
+	return copied
+}
+
+// Has creates child models with the given factory after each model,
+// copying the localKey field of the model into the foreignKey field of the children
+func (f *Factory[T]) Has(children Related, foreignKey, localKey string) *Factory[T] {
+	copied := f.clone()
+	copied.children = append(copied.children, childRelation{factory: children, foreignKey: foreignKey, localKey: localKey})
+	return copied
+}
+
+// Make builds the models without persisting them
+func (f *Factory[T]) Make() ([]T, error) {
+	models := make([]T, 0, f.count)
+	for i := 0; i < f.count; i++ {
+		model, err := f.build(nil)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, *model)
+	}
+	return models, nil
+}
+
+// MakeOne builds a single model without persisting it
+func (f *Factory[T]) MakeOne() (*T, error) {
+	return f.build(nil)
+}
+
+// Create builds and persists the models with their related models in a transaction,
+// so that nothing is left behind when one of them fails
+func (f *Factory[T]) Create() ([]T, error) {
+	db, err := f.connection()
+	if err != nil {
+		return nil, err
+	}
+	var values []reflect.Value
+	err = db.Transaction(func(tx *gorm.DB) error {
+		values, err = f.createRelated(tx, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	models := make([]T, 0, len(values))
+	for _, value := range values {
+		models = append(models, *value.Interface().(*T))
+	}
+	return models, nil
+}
+
+// CreateOne builds and persists a single model with its related models
+func (f *Factory[T]) CreateOne() (*T, error) {
+	models, err := f.Count(1).Create()
+	if err != nil {
+		return nil, err
+	}
+	return &models[0], nil
+}
+
+// createRelated implements Related, forcing the given field values on every model
+func (f *Factory[T]) createRelated(db *gorm.DB, values map[string]any) ([]reflect.Value, error) {
+	created := make([]reflect.Value, 0, f.count)
+	for i := 0; i < f.count; i++ {
+		model, err := f.build(values)
+		if err != nil {
+			return nil, err
+		}
+		modelValue := reflect.ValueOf(model).Elem()
+
+		for _, parent := range f.parents {
+			parents, err := parent.factory.createRelated(db, nil)
+			if err != nil {
+				return nil, err
+			}
+			key, err := fieldValue(parents[0].Elem(), parent.parentKey)
+			if err != nil {
+				return nil, err
+			}
+			if err := assign(modelValue, parent.foreignKey, key); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := db.Create(model).Error; err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCreatingModel, err)
+		}
+
+		for _, child := range f.children {
+			key, err := fieldValue(modelValue, child.localKey)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := child.factory.createRelated(db, map[string]any{child.foreignKey: key}); err != nil {
+				return nil, err
+			}
+		}
+		created = append(created, reflect.ValueOf(model))
+	}
+	return created, nil
+}
+
+// single implements Related, returning a copy of the factory creating one model
+func (f *Factory[T]) single() Related {
+	return f.Count(1)
+}
+
+// build generates a model from the definition, the overrides, the forced values and the states
+func (f *Factory[T]) build(values map[string]any) (*T, error) {
+	model := new(T)
+	modelValue := reflect.ValueOf(model).Elem()
+	if modelValue.Kind() != reflect.Struct {
+		return nil, ErrModelNotStruct
+	}
+	sequence := int(atomic.AddInt64(f.sequence, 1))
+	for name, generator := range f.definition {
+		if _, overridden := f.overrides[name]; overridden {
+			continue
+		}
+		if err := assign(modelValue, name, generator(sequence)); err != nil {
+			return nil, err
+		}
+	}
+	for name, generator := range f.overrides {
+		if err := assign(modelValue, name, generator(sequence)); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range values {
+		if err := assign(modelValue, name, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, state := range f.states {
+		state(model)
+	}
+	return model, nil
+}
+
+// Returns the connection used to persist the models
+func (f *Factory[T]) connection() (*gorm.DB, error) {
+	if f.db != nil {
+		return f.db, nil
+	}
+	if database.DB.Ctx == nil {
+		return nil, database.ErrDatabaseNotBooted
+	}
+	return database.DB.Ctx.Clauses(dbresolver.Write), nil
+}
+
+// Reads a field of a struct value
+func fieldValue(structValue reflect.Value, name string) (any, error) {
+	field := structValue.FieldByName(name)
+	if !field.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, name)
+	}
+	return field.Interface(), nil
+}
+
+// Sets a field of a struct value, converting between pointers and values when needed
+func assign(structValue reflect.Value, name string, value any) error {
+	field := structValue.FieldByName(name)
+	if !field.IsValid() || !field.CanSet() {
+		return fmt.Errorf("%w: %s", ErrFieldNotFound, name)
+	}
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	val := reflect.ValueOf(value)
+	switch {
+	case val.Type().AssignableTo(field.Type()):
+		field.Set(val)
+	case val.Kind() == reflect.Ptr && val.Type().Elem().AssignableTo(field.Type()):
+		if val.IsNil() {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(val.Elem())
+		}
+	case field.Kind() == reflect.Ptr && val.Type().AssignableTo(field.Type().Elem()):
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(val)
+		field.Set(ptr)
+	case isNumber(val.Kind()) && isNumber(field.Kind()):
+		field.Set(val.Convert(field.Type()))
+	default:
+		return fmt.Errorf("%w: %s (%s to %s)", ErrFieldNotAssignable, name, val.Type(), field.Type())
+	}
+	return nil
+}
+
+// Reports whether the kind is an integer or a float
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
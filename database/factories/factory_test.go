@@ -0,0 +1,276 @@
+package factories
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nd-tools/capyvel/helpers/ptr"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+type user struct {
+	ID       uint
+	Name     string
+	Email    string
+	Age      int
+	Nickname *string
+}
+
+type post struct {
+	ID     uint
+	UserID uint
+	Title  string
+}
+
+// Returns an in-memory database with the tables of the test models
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &user{}, &post{})
+}
+
+func userFactory() *Factory[user] {
+	return New[user](Attributes{
+		"Name":  Value("Ana"),
+		"Email": Email(),
+		"Age":   IntBetween(18, 60),
+	})
+}
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		name    string
+		factory *Factory[user]
+		want    []user // Expected models, ignoring Age
+		wantErr error
+	}{
+		{
+			name:    "definition",
+			factory: userFactory(),
+			want:    []user{{Name: "Ana", Email: "user1@example.com"}},
+		},
+		{
+			name:    "count and sequence",
+			factory: userFactory().Count(2),
+			want:    []user{{Name: "Ana", Email: "user1@example.com"}, {Name: "Ana", Email: "user2@example.com"}},
+		},
+		{
+			name:    "override",
+			factory: userFactory().With(Attributes{"Name": Sequence("name-%d")}),
+			want:    []user{{Name: "name-1", Email: "user1@example.com"}},
+		},
+		{
+			name:    "state after attributes",
+			factory: userFactory().State(func(u *user) { u.Name += "!" }),
+			want:    []user{{Name: "Ana!", Email: "user1@example.com"}},
+		},
+		{
+			name:    "value into pointer field",
+			factory: userFactory().With(Attributes{"Nickname": Value("an")}),
+			want:    []user{{Name: "Ana", Email: "user1@example.com", Nickname: ptr.String("an")}},
+		},
+		{
+			name:    "zero count",
+			factory: userFactory().Count(0),
+			want:    []user{},
+		},
+		{
+			name:    "negative count",
+			factory: userFactory().Count(-1),
+			want:    []user{},
+		},
+		{
+			name:    "unknown field",
+			factory: userFactory().With(Attributes{"Missing": Value(1)}),
+			wantErr: ErrFieldNotFound,
+		},
+		{
+			name:    "value of another type",
+			factory: userFactory().With(Attributes{"Name": Value(true)}),
+			wantErr: ErrFieldNotAssignable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.factory.Make()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Make() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Make() = %+v, want %+v", got, tt.want)
+			}
+			for i, model := range got {
+				want := tt.want[i]
+				if model.Name != want.Name || model.Email != want.Email {
+					t.Errorf("Make()[%d] = %+v, want %+v", i, model, want)
+				}
+				if (model.Nickname == nil) != (want.Nickname == nil) || (model.Nickname != nil && *model.Nickname != *want.Nickname) {
+					t.Errorf("Make()[%d].Nickname = %v, want %v", i, model.Nickname, want.Nickname)
+				}
+				if model.Age < 18 || model.Age > 60 {
+					t.Errorf("Make()[%d].Age = %d, want between 18 and 60", i, model.Age)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeNotStruct(t *testing.T) {
+	if _, err := New[int](nil).Make(); !errors.Is(err, ErrModelNotStruct) {
+		t.Fatalf("Make() error = %v, want %v", err, ErrModelNotStruct)
+	}
+}
+
+func TestCreateRelated(t *testing.T) {
+	tests := []struct {
+		name      string
+		factory   func() *Factory[post]
+		wantPosts int
+		wantUsers int
+		wantErr   error
+	}{
+		{
+			name: "for parent",
+			factory: func() *Factory[post] {
+				return New[post](Attributes{"Title": Sequence("post-%d")}).Count(2).For(userFactory(), "UserID", "ID")
+			},
+			wantPosts: 2,
+			wantUsers: 2,
+		},
+		{
+			name: "parent with a count",
+			factory: func() *Factory[post] {
+				return New[post](nil).Count(2).For(userFactory().Count(3), "UserID", "ID")
+			},
+			wantPosts: 2,
+			wantUsers: 2,
+		},
+		{
+			name: "parent with a zero count",
+			factory: func() *Factory[post] {
+				return New[post](nil).For(userFactory().Count(0), "UserID", "ID")
+			},
+			wantPosts: 1,
+			wantUsers: 1,
+		},
+		{
+			name: "negative count",
+			factory: func() *Factory[post] {
+				return New[post](nil).Count(-1).For(userFactory(), "UserID", "ID")
+			},
+		},
+		{
+			name: "unknown parent key rolls back the parent",
+			factory: func() *Factory[post] {
+				return New[post](nil).For(userFactory(), "UserID", "Missing")
+			},
+			wantErr: ErrFieldNotFound,
+		},
+		{
+			name: "failing child rolls back the models",
+			factory: func() *Factory[post] {
+				return New[post](nil).Count(2).For(userFactory().Has(New[post](Attributes{"Missing": Value(1)}), "UserID", "ID"), "UserID", "ID")
+			},
+			wantErr: ErrFieldNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			posts, err := tt.factory().On(db).Create()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(posts) != tt.wantPosts {
+				t.Fatalf("Create() returned %d posts, want %d", len(posts), tt.wantPosts)
+			}
+			for _, p := range posts {
+				if p.UserID == 0 {
+					t.Errorf("post %d has no user", p.ID)
+				}
+			}
+			var users, stored int64
+			db.Model(&user{}).Count(&users)
+			db.Model(&post{}).Count(&stored)
+			if int(users) != tt.wantUsers || int(stored) != tt.wantPosts {
+				t.Fatalf("stored %d users and %d posts, want %d and %d", users, stored, tt.wantUsers, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestCreateHasChildren(t *testing.T) {
+	db := newTestDB(t)
+	posts := New[post](Attributes{"Title": Value("child")}).Count(3)
+	users, err := userFactory().Count(2).Has(posts, "UserID", "ID").On(db).Create()
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, u := range users {
+		var count int64
+		db.Model(&post{}).Where("user_id = ?", u.ID).Count(&count)
+		if count != 3 {
+			t.Errorf("user %d has %d posts, want 3", u.ID, count)
+		}
+	}
+}
+
+func TestCreateOne(t *testing.T) {
+	db := newTestDB(t)
+	created, err := userFactory().Count(5).On(db).CreateOne()
+	if err != nil {
+		t.Fatalf("CreateOne() error = %v", err)
+	}
+	if created.ID == 0 {
+		t.Fatal("CreateOne() returned a model without ID")
+	}
+	var count int64
+	db.Model(&user{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("stored %d users, want 1", count)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	type target struct {
+		Int      int
+		Int64    int64
+		Float    float64
+		Text     string
+		Pointer  *string
+		Optional *int
+	}
+	tests := []struct {
+		name    string
+		field   string
+		value   any
+		check   func(target) bool
+		wantErr error
+	}{
+		{name: "same type", field: "Text", value: "a", check: func(v target) bool { return v.Text == "a" }},
+		{name: "number conversion", field: "Int64", value: 7, check: func(v target) bool { return v.Int64 == 7 }},
+		{name: "float to int", field: "Int", value: 2.0, check: func(v target) bool { return v.Int == 2 }},
+		{name: "int to float", field: "Float", value: 3, check: func(v target) bool { return v.Float == 3 }},
+		{name: "pointer to value", field: "Text", value: ptr.String("b"), check: func(v target) bool { return v.Text == "b" }},
+		{name: "nil pointer to value", field: "Text", value: (*string)(nil), check: func(v target) bool { return v.Text == "" }},
+		{name: "value to pointer", field: "Pointer", value: "c", check: func(v target) bool { return v.Pointer != nil && *v.Pointer == "c" }},
+		{name: "nil", field: "Optional", value: nil, check: func(v target) bool { return v.Optional == nil }},
+		{name: "unknown field", field: "Missing", value: 1, wantErr: ErrFieldNotFound},
+		{name: "incompatible type", field: "Int", value: "1", wantErr: ErrFieldNotAssignable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value target
+			value.Optional = ptr.Int(1)
+			value.Text = "initial"
+			err := assign(reflect.ValueOf(&value).Elem(), tt.field, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("assign() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(value) {
+				t.Fatalf("assign() left %+v", value)
+			}
+		})
+	}
+}
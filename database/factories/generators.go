@@ -0,0 +1,125 @@
+package factories
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/nd-tools/capyvel/helpers/timeformats"
+	"github.com/nd-tools/capyvel/helpers/uuid"
+)
+
+var (
+	firstNames = []string{"Ana", "Luis", "María", "José", "Sofía", "Carlos", "Lucía", "Miguel", "Valeria", "Diego"}
+	lastNames  = []string{"García", "Hernández", "López", "Martínez", "González", "Pérez", "Rodríguez", "Sánchez", "Ramírez", "Torres"}
+	words      = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do"}
+)
+
+// Value always returns the same value
+func Value(value any) Generator {
+	return func(int) any { return value }
+}
+
+// Sequence formats the sequence number with the given format (e.g. "user-%d")
+func Sequence(format string) Generator {
+	return func(sequence int) any { return fmt.Sprintf(format, sequence) }
+}
+
+// OneOf returns one of the given values at random, or nil without values
+func OneOf(values ...any) Generator {
+	return func(int) any {
+		if len(values) == 0 {
+			return nil
+		}
+		return values[rand.Intn(len(values))]
+	}
+}
+
+// UUID returns a new helpers/uuid UUID
+func UUID() Generator {
+	return func(int) any { return uuid.New() }
+}
+
+// IntBetween returns a random int between min and max, both included. The bounds are swapped when max is lower than min.
+func IntBetween(min, max int) Generator {
+	if max < min {
+		min, max = max, min
+	}
+	return func(int) any { return min + rand.Intn(max-min+1) }
+}
+
+// FloatBetween returns a random float64 between min and max. The bounds are swapped when max is lower than min.
+func FloatBetween(min, max float64) Generator {
+	if max < min {
+		min, max = max, min
+	}
+	return func(int) any { return min + rand.Float64()*(max-min) }
+}
+
+// Bool returns a random bool
+func Bool() Generator {
+	return func(int) any { return rand.Intn(2) == 1 }
+}
+
+// FirstName returns a random first name
+func FirstName() Generator {
+	return func(int) any { return firstNames[rand.Intn(len(firstNames))] }
+}
+
+// LastName returns a random last name
+func LastName() Generator {
+	return func(int) any { return lastNames[rand.Intn(len(lastNames))] }
+}
+
+// Name returns a random full name
+func Name() Generator {
+	return func(int) any {
+		return firstNames[rand.Intn(len(firstNames))] + " " + lastNames[rand.Intn(len(lastNames))]
+	}
+}
+
+// Email returns a unique email using the sequence number
+func Email() Generator {
+	return func(sequence int) any { return fmt.Sprintf("user%d@example.com", sequence) }
+}
+
+// Sentence returns a random sentence with the given number of words, empty when it is not positive
+func Sentence(count int) Generator {
+	count = max(count, 0)
+	return func(int) any {
+		sentence := make([]string, count)
+		for i := range sentence {
+			sentence[i] = words[rand.Intn(len(words))]
+		}
+		return strings.Join(sentence, " ")
+	}
+}
+
+// DateBetween returns a random helpers/timeformats Date between from and to
+func DateBetween(from, to time.Time) Generator {
+	return func(int) any {
+		return timeformats.Date{Time: randomTime(from, to).Truncate(24 * time.Hour)}
+	}
+}
+
+// DateTimeBetween returns a random helpers/timeformats DateTime between from and to
+func DateTimeBetween(from, to time.Time) Generator {
+	return func(int) any {
+		return timeformats.DateTime{Time: randomTime(from, to)}
+	}
+}
+
+// Now returns the current time as a helpers/timeformats DateTime
+func Now() Generator {
+	return func(int) any { return timeformats.DateTime{Time: time.Now()} }
+}
+
+// Returns a random time between from and to
+func randomTime(from, to time.Time) time.Time {
+	span := to.Sub(from)
+	if span <= 0 {
+		return from
+	}
+	return from.Add(time.Duration(rand.Int63n(int64(span))))
+}
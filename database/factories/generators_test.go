@@ -0,0 +1,105 @@
+package factories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nd-tools/capyvel/helpers/timeformats"
+)
+
+func TestIntBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		wantMin  int
+		wantMax  int
+	}{
+		{name: "range", min: 1, max: 3, wantMin: 1, wantMax: 3},
+		{name: "single value", min: 5, max: 5, wantMin: 5, wantMax: 5},
+		{name: "negative", min: -2, max: 2, wantMin: -2, wantMax: 2},
+		{name: "inverted bounds", min: 3, max: 1, wantMin: 1, wantMax: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := IntBetween(tt.min, tt.max)
+			seen := map[int]bool{}
+			for i := 1; i <= 200; i++ {
+				value := generator(i).(int)
+				if value < tt.wantMin || value > tt.wantMax {
+					t.Fatalf("IntBetween(%d, %d) = %d", tt.min, tt.max, value)
+				}
+				seen[value] = true
+			}
+			if len(seen) != tt.wantMax-tt.wantMin+1 {
+				t.Errorf("IntBetween(%d, %d) produced %v, want every value of the range", tt.min, tt.max, seen)
+			}
+		})
+	}
+}
+
+func TestFloatBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max float64
+		wantMin  float64
+		wantMax  float64
+	}{
+		{name: "range", min: 0.5, max: 1.5, wantMin: 0.5, wantMax: 1.5},
+		{name: "single value", min: 2, max: 2, wantMin: 2, wantMax: 2},
+		{name: "inverted bounds", min: 1, max: -1, wantMin: -1, wantMax: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := FloatBetween(tt.min, tt.max)
+			for i := 1; i <= 100; i++ {
+				if value := generator(i).(float64); value < tt.wantMin || value > tt.wantMax {
+					t.Fatalf("FloatBetween(%v, %v) = %v", tt.min, tt.max, value)
+				}
+			}
+		})
+	}
+}
+
+func TestSequenceGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator Generator
+		sequence  int
+		want      any
+	}{
+		{name: "value", generator: Value("fixed"), sequence: 3, want: "fixed"},
+		{name: "sequence", generator: Sequence("user-%d"), sequence: 3, want: "user-3"},
+		{name: "email", generator: Email(), sequence: 7, want: "user7@example.com"},
+		{name: "one of a single value", generator: OneOf("only"), sequence: 1, want: "only"},
+		{name: "one of no values", generator: OneOf(), sequence: 1, want: nil},
+		{name: "empty sentence", generator: Sentence(0), sequence: 1, want: ""},
+		{name: "negative sentence", generator: Sentence(-1), sequence: 1, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.generator(tt.sequence); got != tt.want {
+				t.Fatalf("generator(%d) = %v, want %v", tt.sequence, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateTimeBetween(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		from, to time.Time
+	}{
+		{name: "range", from: from, to: from.Add(48 * time.Hour)},
+		{name: "empty range", from: from, to: from},
+		{name: "inverted range", from: from, to: from.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := DateTimeBetween(tt.from, tt.to)(1).(timeformats.DateTime)
+			if value.Before(tt.from) || (tt.to.After(tt.from) && value.After(tt.to)) {
+				t.Fatalf("DateTimeBetween() = %v, want between %v and %v", value.Time, tt.from, tt.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/foundation"
+	"github.com/nd-tools/capyvel/responses"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Health statuses of a connection and of the whole report
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+
+	// Roles of a connection pool in the resolver
+	RoleSource  = "source"
+	RoleReplica = "replica"
+
+	// Timeout of every ping when database.health.timeout is not set
+	DefaultHealthTimeout = 2 * time.Second
+)
+
+// ConnectionHealth is the status of a single connection pool
+type ConnectionHealth struct {
+	Name    string        `json:"name"`            // Name of the connection in the database config
+	Role    string        `json:"role"`            // RoleSource or RoleReplica
+	Status  string        `json:"status"`          // HealthUp or HealthDown
+	Latency time.Duration `json:"latency"`         // Duration of the ping, in nanoseconds
+	Error   string        `json:"error,omitempty"` // Error of the ping, if any
+	Stats   sql.DBStats   `json:"stats"`           // Statistics of the pool (open, in use, wait count...)
+}
+
+// HealthReport is the status of every configured connection pool
+type HealthReport struct {
+	Status      string             `json:"status"` // HealthUp when every connection is up
+	Connections []ConnectionHealth `json:"connections"`
+}
+
+// Healthy reports whether every connection is up
+func (report HealthReport) Healthy() bool {
+	return report.Status == HealthUp
+}
+
+// A connection pool opened by Boot, with the name of its connection
+type namedPool struct {
+	name string
+	role string
+	db   *sql.DB
+}
+
+// Health pings every connection pool opened by Boot (the default connection, the resolver
+// connections and their replicas) concurrently, each one with the database.health.timeout
+func Health(ctx context.Context) HealthReport {
+	if DB.Ctx == nil {
+		return HealthReport{Status: HealthDown, Connections: []ConnectionHealth{{
+			Name:   DB.defaultName,
+			Role:   RoleSource,
+			Status: HealthDown,
+			Error:  ErrDatabaseNotBooted.Error(),
+		}}}
+	}
+
+	values, _ := foundation.App.Config.Get("database.health", nil).(map[string]interface{})
+	timeout, err := configuration.Duration(values, "timeout", DefaultHealthTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+
+	report := HealthReport{Status: HealthUp, Connections: make([]ConnectionHealth, len(DB.pools))}
+	var wg sync.WaitGroup
+	for i, pool := range DB.pools {
+		wg.Add(1)
+		go func(i int, pool namedPool) {
+			defer wg.Done()
+			report.Connections[i] = pingPool(ctx, pool, timeout)
+		}(i, pool)
+	}
+	wg.Wait()
+
+	for _, connection := range report.Connections {
+		if connection.Status != HealthUp {
+			report.Status = HealthDown
+			break
+		}
+	}
+	return report
+}
+
+// HealthHandler responds with the Health report: 200 OK when every connection is up and
+// 503 Service Unavailable otherwise, so it can be mounted with RegisterFunctions as a probe
+func HealthHandler(ctx *gin.Context) {
+	report := Health(ctx.Request.Context())
+	status := http.StatusOK
+	message := "database is healthy"
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+		message = "database is unhealthy"
+	}
+	ctx.JSON(status, responses.Api{
+		Data:    report,
+		Message: message,
+		Status:  status,
+		Success: report.Healthy(),
+	})
+}
+
+// Pings a connection pool and reads its statistics
+func pingPool(ctx context.Context, pool namedPool, timeout time.Duration) ConnectionHealth {
+	health := ConnectionHealth{Name: pool.name, Role: pool.role, Status: HealthUp}
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := pool.db.PingContext(pingCtx)
+	health.Latency = time.Since(start)
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	health.Stats = pool.db.Stats()
+	return health
+}
+
+// Collects the pools opened by the resolver for every config, in registration order.
+// dbresolver runs the compile callbacks over the sources and then the replicas of each config;
+// a config without sources uses the default pool, and one without replicas reuses its sources.
+func resolverPools(names []string, configs []dbresolver.Config, connPools []gorm.ConnPool) []namedPool {
+	var pools []namedPool
+	index := 0
+	collect := func(name, role string, count int, report bool) {
+		for j := 0; j < count && index < len(connPools); j++ {
+			if sqlDB, ok := connPools[index].(*sql.DB); ok && report {
+				pools = append(pools, namedPool{name: name, role: role, db: sqlDB})
+			}
+			index++
+		}
+	}
+	for i, config := range configs {
+		// The default pool is already reported as the default connection
+		sources := len(config.Sources)
+		collect(names[i], RoleSource, max(sources, 1), sources > 0)
+		if len(config.Replicas) == 0 {
+			collect(names[i], RoleReplica, max(sources, 1), false)
+		} else {
+			collect(names[i], RoleReplica, len(config.Replicas), true)
+		}
+	}
+	return pools
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Opens a standalone in-memory pool
+func openTestPool(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name       string
+		replicas   []bool // Whether each replica pool is reachable
+		wantStatus string
+		wantHTTP   int
+	}{
+		{name: "default connection up", wantStatus: HealthUp, wantHTTP: http.StatusOK},
+		{name: "replicas up", replicas: []bool{true, true}, wantStatus: HealthUp, wantHTTP: http.StatusOK},
+		{name: "one replica down", replicas: []bool{true, false}, wantStatus: HealthDown, wantHTTP: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			for _, up := range tt.replicas {
+				pool := openTestPool(t)
+				if !up {
+					pool.Close()
+				}
+				DB.pools = append(DB.pools, namedPool{name: "reports", role: RoleReplica, db: pool})
+			}
+
+			report := Health(context.Background())
+			if report.Status != tt.wantStatus || report.Healthy() != (tt.wantStatus == HealthUp) {
+				t.Fatalf("Health() status = %s, want %s", report.Status, tt.wantStatus)
+			}
+			if len(report.Connections) != 1+len(tt.replicas) {
+				t.Fatalf("Health() reported %d connections, want %d", len(report.Connections), 1+len(tt.replicas))
+			}
+			for i, connection := range report.Connections {
+				up := i == 0 || tt.replicas[i-1]
+				if (connection.Status == HealthUp) != up || (connection.Error == "") != up {
+					t.Errorf("connection %d = %+v, want up %v", i, connection, up)
+				}
+			}
+
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/health", nil)
+			HealthHandler(ctx)
+			if recorder.Code != tt.wantHTTP {
+				t.Fatalf("HealthHandler() status = %d, want %d", recorder.Code, tt.wantHTTP)
+			}
+			var body struct {
+				Data HealthReport `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body.Data.Status != tt.wantStatus {
+				t.Fatalf("HealthHandler() body = %s", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestHealthNotBooted(t *testing.T) {
+	previous := DB
+	DB = Database{defaultName: "main"}
+	t.Cleanup(func() { DB = previous })
+	report := Health(context.Background())
+	if report.Healthy() || len(report.Connections) != 1 || !strings.Contains(report.Connections[0].Error, ErrDatabaseNotBooted.Error()) {
+		t.Fatalf("Health() = %+v, want the default connection down", report)
+	}
+}
+
+func TestResolverPools(t *testing.T) {
+	dialector := sqlite.Open("file::memory:")
+	dialectors := func(count int) []gorm.Dialector {
+		list := make([]gorm.Dialector, count)
+		for i := range list {
+			list[i] = dialector
+		}
+		return list
+	}
+	type want struct {
+		name, role string
+		pool       int // Index of the pool opened by the resolver
+	}
+	tests := []struct {
+		name    string
+		names   []string
+		configs []dbresolver.Config
+		opened  int // Pools opened by the resolver
+		want    []want
+	}{
+		{
+			name:    "replicas of the default connection",
+			names:   []string{"main"},
+			configs: []dbresolver.Config{{Replicas: dialectors(2)}},
+			opened:  3,
+			want:    []want{{"main", RoleReplica, 1}, {"main", RoleReplica, 2}},
+		},
+		{
+			name:    "connection without replicas",
+			names:   []string{"reports"},
+			configs: []dbresolver.Config{{Sources: dialectors(1)}},
+			opened:  2,
+			want:    []want{{"reports", RoleSource, 0}},
+		},
+		{
+			name:    "connections with sources and replicas",
+			names:   []string{"main", "reports"},
+			configs: []dbresolver.Config{{Replicas: dialectors(1)}, {Sources: dialectors(2), Replicas: dialectors(1)}},
+			opened:  5,
+			want:    []want{{"main", RoleReplica, 1}, {"reports", RoleSource, 2}, {"reports", RoleSource, 3}, {"reports", RoleReplica, 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connPools := make([]gorm.ConnPool, tt.opened)
+			opened := make([]*sql.DB, tt.opened)
+			for i := range connPools {
+				opened[i] = openTestPool(t)
+				connPools[i] = opened[i]
+			}
+			pools := resolverPools(tt.names, tt.configs, connPools)
+			if len(pools) != len(tt.want) {
+				t.Fatalf("resolverPools() = %+v, want %+v", pools, tt.want)
+			}
+			for i, pool := range pools {
+				if pool.name != tt.want[i].name || pool.role != tt.want[i].role || pool.db != opened[tt.want[i].pool] {
+					t.Errorf("resolverPools()[%d] = %s %s, want %+v", i, pool.name, pool.role, tt.want[i])
+				}
+			}
+		})
+	}
+}
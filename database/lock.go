@@ -0,0 +1,374 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/foundation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	// LockTableName is the table used by the drivers without native application locks
+	LockTableName = "capyvel_locks"
+
+	// Expiration of the rows of the lock table when database.locks.ttl is not set
+	DefaultLockTTL = 5 * time.Minute
+	// Wait between two attempts while a lock is held by another owner
+	lockPollInterval = 200 * time.Millisecond
+)
+
+var (
+	ErrLockNameRequired = errors.New("lock name is required")            // Triggered when locking without name
+	ErrLockTimeout      = errors.New("timeout acquiring lock")           // Triggered when the lock is still held by another owner after the timeout
+	ErrLockConnection   = errors.New("lock connection not found")        // Triggered when the named connection has no pool
+	ErrAcquiringLock    = errors.New("error acquiring lock")             // Triggered when the database fails while acquiring a lock
+	ErrReleasingLock    = errors.New("error releasing lock")             // Triggered when the database fails while releasing a lock
+	ErrLockReleased     = errors.New("lock has already been released")   // Triggered when releasing a lock twice
+	ErrLockTable        = errors.New("error preparing the lock table")   // Triggered when the lock table cannot be created
+	ErrLockLost         = errors.New("lock was lost before its release") // Triggered when a table lock expires because it could not be renewed
+
+	// Connections whose lock table was already created
+	lockTables   = map[string]bool{}
+	lockTablesMu sync.Mutex
+)
+
+// LockRecord is a row of the lock table
+type LockRecord struct {
+	Name      string    `gorm:"column:name;primaryKey;size:255"`
+	Owner     string    `gorm:"column:owner;size:64"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+// TableName implements gorm's Tabler
+func (LockRecord) TableName() string {
+	return LockTableName
+}
+
+// LockHandle is an acquired lock, held until Release is called
+type LockHandle struct {
+	Name     string
+	mu       sync.Mutex
+	release  func(ctx context.Context) error
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// Lost returns a channel closed when the lock is lost before its release: the rows of the lock
+// table are renewed while the lock is held, and the lock is lost when a renewal finds the row
+// taken by another owner, or keeps failing until the row expires
+func (handle *LockHandle) Lost() <-chan struct{} {
+	return handle.lost
+}
+
+// Marks the lock as lost
+func (handle *LockHandle) markLost() {
+	handle.lostOnce.Do(func() { close(handle.lost) })
+}
+
+// Release frees the lock; it fails with ErrLockReleased when called twice
+func (handle *LockHandle) Release(ctx context.Context) error {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	if handle.release == nil {
+		return ErrLockReleased
+	}
+	err := handle.release(ctx)
+	handle.release = nil
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrReleasingLock, handle.Name, err)
+	}
+	return nil
+}
+
+// Lock acquires a lock shared by every process using the default connection, waiting up to
+// timeout while another owner holds it. A zero timeout tries once and a negative one waits
+// until the context is done. SQL Server uses sp_getapplock, Postgres advisory locks and the
+// other drivers the lock table, whose rows expire after database.locks.ttl.
+func Lock(ctx context.Context, name string, timeout time.Duration) (*LockHandle, error) {
+	return LockConnection(ctx, "", name, timeout)
+}
+
+// LockConnection acquires a lock on the named connection of database.Boot, see Lock
+func LockConnection(ctx context.Context, connection, name string, timeout time.Duration) (*LockHandle, error) {
+	if name == "" {
+		return nil, ErrLockNameRequired
+	}
+	if DB.Ctx == nil {
+		return nil, ErrDatabaseNotBooted
+	}
+	if connection == "" {
+		connection = DB.defaultName
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	handle := &LockHandle{Name: name, lost: make(chan struct{})}
+	var err error
+	switch ConnectionDriver(connection) {
+	case DriverSqlserver:
+		handle.release, err = lockSqlserver(ctx, connection, name, timeout)
+	case DriverPostgres:
+		handle.release, err = lockPostgres(ctx, connection, name, timeout)
+	default:
+		handle.release, err = lockTable(ctx, connection, name, timeout, handle.markLost)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// WithLock runs fn while holding the named lock of the default connection, releasing it afterwards.
+// The context of fn is canceled when the lock is lost, and WithLock then fails with ErrLockLost.
+func WithLock(ctx context.Context, name string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	handle, err := Lock(ctx, name, timeout)
+	if err != nil {
+		return err
+	}
+	defer handle.Release(context.WithoutCancel(ctx))
+
+	fnCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-handle.Lost():
+			cancel(fmt.Errorf("%w: %s", ErrLockLost, name))
+		case <-fnCtx.Done():
+		}
+	}()
+	err = fn(fnCtx)
+	if cause := context.Cause(fnCtx); errors.Is(cause, ErrLockLost) {
+		return errors.Join(cause, err)
+	}
+	return err
+}
+
+// Returns the source pool of the named connection
+func lockPool(connection string) (*sql.DB, error) {
+	for _, pool := range DB.pools {
+		if pool.name == connection && pool.role == RoleSource {
+			return pool.db, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrLockConnection, connection)
+}
+
+// Returns the dedicated connection of a session lock to the pool. After an error the session may
+// still hold the lock, so the connection is discarded instead.
+func closeLockConn(conn *sql.Conn, err error) error {
+	if err != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		return err
+	}
+	return conn.Close()
+}
+
+// Returns the error of a lock attempt that ran out of time
+func lockError(ctx context.Context, name string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %s", ErrLockTimeout, name)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrAcquiringLock, name, err)
+}
+
+// Acquires a session lock with sp_getapplock on a dedicated connection
+func lockSqlserver(ctx context.Context, connection, name string, timeout time.Duration) (func(ctx context.Context) error, error) {
+	pool, err := lockPool(connection)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, lockError(ctx, name, err)
+	}
+	lockTimeout := int64(-1)
+	if timeout >= 0 {
+		lockTimeout = timeout.Milliseconds()
+	}
+	// sp_getapplock returns 0 or 1 when granted, -1 on timeout and lower values on errors
+	var result int
+	err = conn.QueryRowContext(ctx, `DECLARE @result int;
+EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2;
+SELECT @result`, name, lockTimeout).Scan(&result)
+	if err != nil {
+		closeLockConn(conn, err)
+		return nil, lockError(ctx, name, err)
+	}
+	if result < 0 {
+		conn.Close()
+		if result == -1 {
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, name)
+		}
+		return nil, fmt.Errorf("%w: %s: sp_getapplock returned %d", ErrAcquiringLock, name, result)
+	}
+	return func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, "EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'", name)
+		return closeLockConn(conn, err)
+	}, nil
+}
+
+// Acquires a session advisory lock on a dedicated connection, polling until it is granted
+func lockPostgres(ctx context.Context, connection, name string, timeout time.Duration) (func(ctx context.Context) error, error) {
+	pool, err := lockPool(connection)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, lockError(ctx, name, err)
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	key := int64(hash.Sum64())
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+			closeLockConn(conn, err)
+			return nil, lockError(ctx, name, err)
+		}
+		if acquired {
+			break
+		}
+		if err := waitLock(ctx, timeout); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, name)
+		}
+	}
+	return func(ctx context.Context) error {
+		var released bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", key).Scan(&released)
+		if err == nil && !released {
+			err = fmt.Errorf("advisory lock %d was not held by the session", key)
+		}
+		return closeLockConn(conn, err)
+	}, nil
+}
+
+// Acquires a lock by inserting its row in the lock table, polling until the row is free or expired.
+// The row is renewed until the release, calling lost when the lock cannot be kept.
+func lockTable(ctx context.Context, connection, name string, timeout time.Duration, lost func()) (func(ctx context.Context) error, error) {
+	db := DB.Connection(connection).Clauses(dbresolver.Write)
+	if err := ensureLockTable(db, connection); err != nil {
+		return nil, err
+	}
+	values, _ := foundation.App.Config.Get("database.locks", nil).(map[string]interface{})
+	ttl, err := configuration.Duration(values, "ttl", DefaultLockTTL)
+	if err != nil || ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	owner, err := lockOwner()
+	if err != nil {
+		return nil, lockError(ctx, name, err)
+	}
+	nameColumn := clause.Eq{Column: clause.Column{Name: "name"}, Value: name}
+	for {
+		// Free the row of an owner that did not release the lock before it expired
+		err := db.WithContext(ctx).Where(nameColumn).
+			Where(clause.Lt{Column: clause.Column{Name: "expires_at"}, Value: time.Now()}).
+			Delete(&LockRecord{}).Error
+		if err != nil {
+			return nil, lockError(ctx, name, err)
+		}
+		record := LockRecord{Name: name, Owner: owner, ExpiresAt: time.Now().Add(ttl)}
+		result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return nil, lockError(ctx, name, result.Error)
+		}
+		if result.RowsAffected == 1 {
+			break
+		}
+		if err := waitLock(ctx, timeout); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, name)
+		}
+	}
+	ownerColumn := clause.Eq{Column: clause.Column{Name: "owner"}, Value: owner}
+	done := make(chan struct{})
+	go renewLock(db.Session(&gorm.Session{}).Where(nameColumn).Where(ownerColumn), ttl, done, lost)
+	return func(ctx context.Context) error {
+		close(done)
+		return db.WithContext(ctx).Where(nameColumn).Where(ownerColumn).Delete(&LockRecord{}).Error
+	}, nil
+}
+
+// Pushes back the expiration of a row of the lock table every third of its ttl until done is
+// closed. The lock is lost when the row was taken by another owner, or when the renewals keep
+// failing until the row expires.
+func renewLock(row *gorm.DB, ttl time.Duration, done <-chan struct{}, lost func()) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	expiresAt := time.Now().Add(ttl)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		next := time.Now().Add(ttl)
+		result := row.WithContext(ctx).Model(&LockRecord{}).Update("expires_at", next)
+		cancel()
+		switch {
+		case result.Error == nil && result.RowsAffected == 0:
+			lost()
+			return
+		case result.Error == nil:
+			expiresAt = next
+		case !time.Now().Before(expiresAt):
+			lost()
+			return
+		}
+	}
+}
+
+// Creates the lock table of a connection the first time it is used
+func ensureLockTable(db *gorm.DB, connection string) error {
+	lockTablesMu.Lock()
+	defer lockTablesMu.Unlock()
+	if lockTables[connection] {
+		return nil
+	}
+	if err := db.AutoMigrate(&LockRecord{}); err != nil {
+		return fmt.Errorf("%w: %v", ErrLockTable, err)
+	}
+	lockTables[connection] = true
+	return nil
+}
+
+// Generates a random identifier for the owner of a table lock
+func lockOwner() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Waits before the next attempt, failing when the context is done or the timeout is zero
+func waitLock(ctx context.Context, timeout time.Duration) error {
+	if timeout == 0 {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(lockPollInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Boots DB with table locks expiring after ttl for the duration of the test
+func useTestLocks(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	useTestDB(t)
+	setConfig(t, "database", map[string]any{
+		"connections": map[string]any{"main": map[string]any{"driver": DriverSqlite}},
+		"locks":       map[string]any{"ttl": ttl},
+	})
+	// The lock table of the previous test database is gone
+	lockTablesMu.Lock()
+	lockTables = map[string]bool{}
+	lockTablesMu.Unlock()
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    string
+		held    *LockRecord // Row of another owner
+		timeout time.Duration
+		wantErr error
+	}{
+		{name: "free", lock: "reports"},
+		{name: "without name", wantErr: ErrLockNameRequired},
+		{name: "held without waiting", lock: "reports", held: &LockRecord{Name: "reports", ExpiresAt: time.Now().Add(time.Hour)}, wantErr: ErrLockTimeout},
+		{name: "held until the timeout", lock: "reports", held: &LockRecord{Name: "reports", ExpiresAt: time.Now().Add(time.Hour)}, timeout: 50 * time.Millisecond, wantErr: ErrLockTimeout},
+		{name: "other lock held", lock: "reports", held: &LockRecord{Name: "invoices", ExpiresAt: time.Now().Add(time.Hour)}},
+		{name: "expired", lock: "reports", held: &LockRecord{Name: "reports", ExpiresAt: time.Now().Add(-time.Second)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestLocks(t, time.Minute)
+			if tt.held != nil {
+				if err := ensureLockTable(DB.Ctx, "main"); err != nil {
+					t.Fatal(err)
+				}
+				held := *tt.held
+				held.Owner = "other"
+				if err := DB.Ctx.Create(&held).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			start := time.Now()
+			handle, err := Lock(context.Background(), tt.lock, tt.timeout)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lock() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if handle != nil {
+					t.Fatal("Lock() returned a handle with an error")
+				}
+				if elapsed := time.Since(start); elapsed < tt.timeout {
+					t.Fatalf("Lock() gave up after %s, before the timeout %s", elapsed, tt.timeout)
+				}
+				return
+			}
+			if err := handle.Release(context.Background()); err != nil {
+				t.Fatalf("Release() error = %v", err)
+			}
+			if err := handle.Release(context.Background()); !errors.Is(err, ErrLockReleased) {
+				t.Fatalf("second Release() error = %v, want %v", err, ErrLockReleased)
+			}
+			var rows int64
+			if err := DB.Ctx.Model(&LockRecord{}).Where("name = ?", tt.lock).Count(&rows).Error; err != nil || rows != 0 {
+				t.Fatalf("%d rows left after the release (%v)", rows, err)
+			}
+		})
+	}
+}
+
+func TestLockNotBooted(t *testing.T) {
+	previous := DB
+	DB = Database{}
+	t.Cleanup(func() { DB = previous })
+	if _, err := Lock(context.Background(), "reports", 0); !errors.Is(err, ErrDatabaseNotBooted) {
+		t.Fatalf("Lock() error = %v, want %v", err, ErrDatabaseNotBooted)
+	}
+}
+
+func TestLockWaitsForTheRelease(t *testing.T) {
+	useTestLocks(t, time.Minute)
+	first, err := Lock(context.Background(), "reports", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Release(context.Background())
+	}()
+	second, err := Lock(context.Background(), "reports", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Lock() after the release error = %v", err)
+	}
+	second.Release(context.Background())
+}
+
+func TestLockRenewal(t *testing.T) {
+	ttl := 90 * time.Millisecond
+	useTestLocks(t, ttl)
+	handle, err := Lock(context.Background(), "reports", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Release(context.Background())
+
+	// Held for several times its ttl
+	time.Sleep(3 * ttl)
+	if _, err := Lock(context.Background(), "reports", 0); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("Lock() of a renewed lock error = %v, want %v", err, ErrLockTimeout)
+	}
+	select {
+	case <-handle.Lost():
+		t.Fatal("a renewed lock was lost")
+	default:
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	useTestLocks(t, 60*time.Millisecond)
+	handle, err := Lock(context.Background(), "reports", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another owner took the row, e.g. after a pause longer than the ttl
+	if err := DB.Ctx.Model(&LockRecord{}).Where("name = ?", "reports").Update("owner", "other").Error; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handle.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock was not reported lost")
+	}
+	if err := handle.Release(context.Background()); err != nil {
+		t.Fatalf("Release() of a lost lock error = %v", err)
+	}
+	var record LockRecord
+	if err := DB.Ctx.Take(&record, "name = ?", "reports").Error; err != nil || record.Owner != "other" {
+		t.Fatalf("Release() of a lost lock removed the row of the new owner: %+v %v", record, err)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	failure := errors.New("failed")
+	tests := []struct {
+		name    string
+		fn      func(ctx context.Context) error
+		wantErr []error
+	}{
+		{name: "success", fn: func(context.Context) error { return nil }},
+		{name: "failure", fn: func(context.Context) error { return failure }, wantErr: []error{failure}},
+		{
+			name: "lost",
+			fn: func(ctx context.Context) error {
+				DB.Ctx.Model(&LockRecord{}).Where("name = ?", "reports").Update("owner", "other")
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+					return nil
+				}
+			},
+			wantErr: []error{ErrLockLost, context.Canceled},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestLocks(t, 60*time.Millisecond)
+			err := WithLock(context.Background(), "reports", 0, tt.fn)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("WithLock() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("WithLock() error = %v, want %v", err, want)
+				}
+			}
+			if tt.name == "lost" {
+				return
+			}
+			// The lock is released afterwards
+			handle, err := Lock(context.Background(), "reports", 0)
+			if err != nil {
+				t.Fatalf("Lock() after WithLock() error = %v", err)
+			}
+			handle.Release(context.Background())
+		})
+	}
+}
+
+func TestLockPool(t *testing.T) {
+	useTestDB(t)
+	if pool, err := lockPool("main"); err != nil || pool == nil {
+		t.Fatalf("lockPool(main) = %v, %v", pool, err)
+	}
+	if _, err := lockPool("reports"); !errors.Is(err, ErrLockConnection) {
+		t.Fatalf("lockPool(reports) error = %v, want %v", err, ErrLockConnection)
+	}
+}
@@ -0,0 +1,363 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/helpers/requestid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	ErrInvalidLoggingConfig = errors.New("invalid database.logging configuration") // Triggered when a database.logging key has a wrong type or value
+
+	// Global collector of query metrics, filled by the callbacks of the connections
+	metrics = NewMetrics(DefaultBuckets)
+
+	// Import path of this module, whose frames are skipped when looking for the calling file
+	modulePath = strings.TrimSuffix(reflect.TypeOf(QueryLogger{}).PkgPath(), "database")
+
+	// Matches the table of the most common statements
+	tablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update|join)\s+([\[\]"` + "`" + `\w.]+)`)
+)
+
+// DefaultBuckets are the upper bounds of the latency histogram
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Log levels accepted by the `database.logging.level` key
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// QueryLogger is a Gorm logger that logs slow queries with their SQL, duration, rows and calling
+// file. It also holds the metrics settings, recorded by the callbacks of the metricsPlugin.
+type QueryLogger struct {
+	logger.Interface               // Base logger used for the Info, Warn and Error messages
+	writer           logger.Writer // Destination of the query lines
+	config           logger.Config
+	metrics          *Metrics
+	disableMetrics   bool
+}
+
+// LogMode implements logger.Interface
+func (l *QueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.Interface = l.Interface.LogMode(level)
+	copied.config.LogLevel = level
+	return &copied
+}
+
+// Trace implements logger.Interface, logging the query when it failed, when it is slower than the
+// threshold or when the level is info. The SQL is only rendered for the logged lines.
+// The line carries the request ID of the context, see helpers/requestid.
+func (l *QueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	elapsed := time.Since(begin)
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	if l.config.LogLevel <= logger.Silent {
+		return
+	}
+
+	var prefix string
+	switch {
+	case err != nil && l.config.LogLevel >= logger.Error && !(notFound && l.config.IgnoreRecordNotFoundError):
+		prefix = err.Error()
+	case l.config.SlowThreshold > 0 && elapsed > l.config.SlowThreshold && l.config.LogLevel >= logger.Warn:
+		prefix = fmt.Sprintf("SLOW SQL >= %v", l.config.SlowThreshold)
+	case l.config.LogLevel == logger.Info:
+	default:
+		return
+	}
+	sql, rows := fc()
+	rowsText := "-"
+	if rows >= 0 {
+		rowsText = fmt.Sprint(rows)
+	}
+	requestText := ""
+	if id := requestid.FromContext(ctx); id != "" {
+		requestText = " [request:" + id + "]"
+	}
+	l.writer.Printf("%s %s\n[%.3fms] [rows:%s]%s %s", callerFile(), prefix, float64(elapsed.Nanoseconds())/1e6, rowsText, requestText, sql)
+}
+
+// Builds the Gorm logger from the `database.logging` settings. The level defaults to info in
+// debug mode, to warn when a slow threshold is declared (so slow queries are always logged)
+// and to silent otherwise.
+func queryLoggerFromConfig(values map[string]interface{}, debug bool) (*QueryLogger, error) {
+	slowThreshold, err := configuration.Duration(values, "slow_threshold", 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: slow_threshold", ErrInvalidLoggingConfig)
+	}
+
+	level := logger.Silent
+	if debug {
+		level = logger.Info
+	} else if slowThreshold > 0 {
+		level = logger.Warn
+	}
+	if name, ok := values["level"].(string); ok {
+		if level, ok = logLevels[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("%w: level", ErrInvalidLoggingConfig)
+		}
+	}
+
+	disableMetrics := false
+	if enabled, ok := values["metrics"].(bool); ok {
+		disableMetrics = !enabled
+	}
+
+	writer := log.New(os.Stdout, "\r\n", log.LstdFlags)
+	config := logger.Config{
+		SlowThreshold:             slowThreshold,
+		LogLevel:                  level,
+		IgnoreRecordNotFoundError: !debug,
+	}
+	return &QueryLogger{
+		Interface:      logger.New(writer, config),
+		writer:         writer,
+		config:         config,
+		metrics:        metrics,
+		disableMetrics: disableMetrics,
+	}, nil
+}
+
+// Key of the statement instance holding the start time of the metrics
+const metricsStartKey = "capyvel:metrics_start"
+
+// Gorm plugin recording the metrics of every statement from the callbacks of the connection,
+// using the table and the SQL of the statement rather than the rendered SQL of the logger
+type metricsPlugin struct {
+	metrics *Metrics
+}
+
+// Name implements gorm.Plugin
+func (metricsPlugin) Name() string {
+	return "capyvel:metrics"
+}
+
+// Initialize implements gorm.Plugin, timing the statements of every processor. The operation of
+// the Row and Raw processors is read from their SQL.
+func (p metricsPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Create().After("*").Register("capyvel:metrics", p.observe("INSERT")),
+		callbacks.Query().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Query().After("*").Register("capyvel:metrics", p.observe("SELECT")),
+		callbacks.Update().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Update().After("*").Register("capyvel:metrics", p.observe("UPDATE")),
+		callbacks.Delete().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Delete().After("*").Register("capyvel:metrics", p.observe("DELETE")),
+		callbacks.Row().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Row().After("*").Register("capyvel:metrics", p.observe("")),
+		callbacks.Raw().Before("*").Register(metricsStartKey, startMetrics),
+		callbacks.Raw().After("*").Register("capyvel:metrics", p.observe("")),
+	)
+}
+
+// Stores the start time of the statement
+func startMetrics(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+// Returns the callback recording the statement, with the given operation or the one of its SQL
+func (p metricsPlugin) observe(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(metricsStartKey)
+		if !ok || db.Statement.DryRun {
+			return
+		}
+		begin, _ := value.(time.Time)
+		table, statementOperation := db.Statement.Table, operation
+		if table == "" || statementOperation == "" {
+			sql := db.Statement.SQL.String()
+			if table == "" {
+				table = tableFromSQL(sql)
+			}
+			if statementOperation == "" {
+				statementOperation = operationFromSQL(sql)
+			}
+		}
+		failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+		p.metrics.Observe(table, statementOperation, time.Since(begin), failed)
+	}
+}
+
+// Returns the plugins of the connections using the logger, recording their metrics unless disabled.
+// The map is new on every call, as Gorm adds the plugins used later on to it.
+func (l *QueryLogger) plugins() map[string]gorm.Plugin {
+	plugins := map[string]gorm.Plugin{}
+	if !l.disableMetrics {
+		plugin := metricsPlugin{metrics: l.metrics}
+		plugins[plugin.Name()] = plugin
+	}
+	return plugins
+}
+
+// Returns the file and line of the first caller outside of Gorm and of this module, whose test
+// files are still reported. When the query comes from a helper of this module called by the
+// router (e.g. helpers.Orm) rather than by the application, the helper is reported.
+func callerFile() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	helper := ""
+	for {
+		frame, more := frames.Next()
+		switch {
+		case strings.HasPrefix(frame.Function, "gorm.io/"):
+		case strings.HasPrefix(frame.Function, modulePath) && !strings.HasSuffix(frame.File, "_test.go"):
+			if helper == "" {
+				helper = frame.File + ":" + strconv.Itoa(frame.Line)
+			}
+		case strings.HasPrefix(frame.Function, "github.com/gin-gonic/"):
+			return helper
+		default:
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return helper
+		}
+	}
+}
+
+// Returns the first table referenced by the SQL, without quotes or schema
+func tableFromSQL(sql string) string {
+	match := tablePattern.FindStringSubmatch(sql)
+	if len(match) < 2 {
+		return ""
+	}
+	table := match[1]
+	if index := strings.LastIndex(table, "."); index >= 0 {
+		table = table[index+1:]
+	}
+	return strings.Trim(table, "[]\"`")
+}
+
+// Returns the operation of the SQL in upper case (SELECT, INSERT, UPDATE, DELETE...)
+func operationFromSQL(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// QueryMetrics returns the global collector of query metrics
+func QueryMetrics() *Metrics {
+	return metrics
+}
+
+// Metrics aggregates query counters and latency histograms per table and operation
+type Metrics struct {
+	mu      sync.Mutex
+	buckets []time.Duration
+	stats   map[string]*QueryStats
+}
+
+// QueryStats holds the counters and the latency histogram of a table and operation
+type QueryStats struct {
+	Table     string          `json:"table"`
+	Operation string          `json:"operation"`
+	Count     int64           `json:"count"`
+	Errors    int64           `json:"errors"`
+	Total     time.Duration   `json:"total"`
+	Max       time.Duration   `json:"max"`
+	Buckets   []time.Duration `json:"buckets"`   // Upper bounds of the histogram
+	Histogram []int64         `json:"histogram"` // Queries per bucket, the last one counts the queries above every bound
+}
+
+// Average returns the mean latency of the queries
+func (s QueryStats) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// NewMetrics creates a collector with the given histogram bounds
+func NewMetrics(buckets []time.Duration) *Metrics {
+	return &Metrics{
+		buckets: buckets,
+		stats:   map[string]*QueryStats{},
+	}
+}
+
+// Observe records a query
+func (m *Metrics) Observe(table, operation string, elapsed time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := table + "|" + operation
+	stats, ok := m.stats[key]
+	if !ok {
+		stats = &QueryStats{
+			Table:     table,
+			Operation: operation,
+			Buckets:   m.buckets,
+			Histogram: make([]int64, len(m.buckets)+1),
+		}
+		m.stats[key] = stats
+	}
+	stats.Count++
+	if failed {
+		stats.Errors++
+	}
+	stats.Total += elapsed
+	if elapsed > stats.Max {
+		stats.Max = elapsed
+	}
+	bucket := sort.Search(len(m.buckets), func(i int) bool { return elapsed <= m.buckets[i] })
+	stats.Histogram[bucket]++
+}
+
+// Snapshot returns a copy of the metrics sorted by table and operation
+func (m *Metrics) Snapshot() []QueryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make([]QueryStats, 0, len(m.stats))
+	for _, stats := range m.stats {
+		copied := *stats
+		copied.Histogram = append([]int64{}, stats.Histogram...)
+		snapshot = append(snapshot, copied)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Table != snapshot[j].Table {
+			return snapshot[i].Table < snapshot[j].Table
+		}
+		return snapshot[i].Operation < snapshot[j].Operation
+	})
+	return snapshot
+}
+
+// Reset clears every counter
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats = map[string]*QueryStats{}
+}
@@ -0,0 +1,52 @@
+package database_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database"
+	"github.com/nd-tools/capyvel/helpers"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// Record read through helpers.Orm
+type entry struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+// Writer keeping the lines of a QueryLogger
+type lineWriter struct {
+	lines []string
+}
+
+func (w *lineWriter) Printf(format string, args ...interface{}) {
+	w.lines = append(w.lines, fmt.Sprintf(format, args...))
+}
+
+func TestQueryLoggerReportsTheCallerOfTheOrm(t *testing.T) {
+	db := testdb.Open(t, &entry{})
+	if err := db.Create(&entry{ID: "1", Name: "first"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	writer := &lineWriter{}
+	db = db.Session(&gorm.Session{Logger: database.NewTestQueryLogger(writer)})
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/entries/1", nil)
+	ctx.Params = gin.Params{{Key: helpers.DefaultKeyParam, Value: "1"}}
+	if _, errRes := (&helpers.Orm{}).Get(ctx, &entry{}, helpers.GetConfig{Db: db}); errRes != nil {
+		t.Fatalf("Get() error = %+v", errRes)
+	}
+
+	if len(writer.lines) != 1 {
+		t.Fatalf("lines = %v, want the query", writer.lines)
+	}
+	if caller := strings.Fields(writer.lines[0])[0]; !strings.Contains(caller, "logger_orm_test.go:") {
+		t.Errorf("caller = %q, want the test calling helpers.Orm", caller)
+	}
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nd-tools/capyvel/helpers/requestid"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Writer keeping the lines of a QueryLogger
+type lineWriter struct {
+	lines []string
+}
+
+func (w *lineWriter) Printf(format string, args ...interface{}) {
+	w.lines = append(w.lines, fmt.Sprintf(format, args...))
+}
+
+func TestTableAndOperationFromSQL(t *testing.T) {
+	tests := []struct {
+		sql           string
+		wantTable     string
+		wantOperation string
+	}{
+		{sql: `SELECT * FROM "users" WHERE id = 1`, wantTable: "users", wantOperation: "SELECT"},
+		{sql: "insert into `orders` (id) values (1)", wantTable: "orders", wantOperation: "INSERT"},
+		{sql: `UPDATE [dbo].[items] SET name = 'a'`, wantTable: "items", wantOperation: "UPDATE"},
+		{sql: `DELETE FROM public.carts`, wantTable: "carts", wantOperation: "DELETE"},
+		{sql: `SELECT count(*) FROM users JOIN orders ON orders.user_id = users.id`, wantTable: "users", wantOperation: "SELECT"},
+		{sql: `  BEGIN`, wantOperation: "BEGIN"},
+		{sql: ``},
+	}
+	for _, tt := range tests {
+		if got := tableFromSQL(tt.sql); got != tt.wantTable {
+			t.Errorf("tableFromSQL(%q) = %q, want %q", tt.sql, got, tt.wantTable)
+		}
+		if got := operationFromSQL(tt.sql); got != tt.wantOperation {
+			t.Errorf("operationFromSQL(%q) = %q, want %q", tt.sql, got, tt.wantOperation)
+		}
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	buckets := []time.Duration{10 * time.Millisecond, 100 * time.Millisecond}
+	tests := []struct {
+		name          string
+		elapsed       []time.Duration
+		failed        int
+		wantHistogram []int64
+		wantMax       time.Duration
+		wantAverage   time.Duration
+	}{
+		{name: "bounds are inclusive", elapsed: []time.Duration{10 * time.Millisecond, 100 * time.Millisecond}, wantHistogram: []int64{1, 1, 0}, wantMax: 100 * time.Millisecond, wantAverage: 55 * time.Millisecond},
+		{name: "above every bound", elapsed: []time.Duration{time.Second}, wantHistogram: []int64{0, 0, 1}, wantMax: time.Second, wantAverage: time.Second},
+		{name: "errors", elapsed: []time.Duration{time.Millisecond, time.Millisecond}, failed: 1, wantHistogram: []int64{2, 0, 0}, wantMax: time.Millisecond, wantAverage: time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMetrics(buckets)
+			for i, elapsed := range tt.elapsed {
+				m.Observe("users", "SELECT", elapsed, i < tt.failed)
+			}
+			snapshot := m.Snapshot()
+			if len(snapshot) != 1 {
+				t.Fatalf("Snapshot() = %+v, want one entry", snapshot)
+			}
+			stats := snapshot[0]
+			if stats.Count != int64(len(tt.elapsed)) || stats.Errors != int64(tt.failed) {
+				t.Errorf("count = %d errors = %d, want %d and %d", stats.Count, stats.Errors, len(tt.elapsed), tt.failed)
+			}
+			if fmt.Sprint(stats.Histogram) != fmt.Sprint(tt.wantHistogram) {
+				t.Errorf("histogram = %v, want %v", stats.Histogram, tt.wantHistogram)
+			}
+			if stats.Max != tt.wantMax || stats.Average() != tt.wantAverage {
+				t.Errorf("max = %s average = %s, want %s and %s", stats.Max, stats.Average(), tt.wantMax, tt.wantAverage)
+			}
+		})
+	}
+}
+
+func TestMetricsSnapshotIsACopy(t *testing.T) {
+	m := NewMetrics([]time.Duration{time.Second})
+	m.Observe("b", "SELECT", time.Millisecond, false)
+	m.Observe("a", "UPDATE", time.Millisecond, false)
+	m.Observe("a", "SELECT", time.Millisecond, false)
+	snapshot := m.Snapshot()
+	var order []string
+	for _, stats := range snapshot {
+		order = append(order, stats.Table+" "+stats.Operation)
+	}
+	if strings.Join(order, ",") != "a SELECT,a UPDATE,b SELECT" {
+		t.Fatalf("Snapshot() order = %v", order)
+	}
+	snapshot[0].Histogram[0] = 100
+	if m.Snapshot()[0].Histogram[0] != 1 {
+		t.Fatal("Snapshot() shares its histograms with the collector")
+	}
+	m.Reset()
+	if len(m.Snapshot()) != 0 {
+		t.Fatal("Reset() kept the metrics")
+	}
+}
+
+func TestQueryLoggerTrace(t *testing.T) {
+	failure := errors.New("syntax error")
+	tests := []struct {
+		name       string
+		level      logger.LogLevel
+		slow       time.Duration
+		ignoreNF   bool
+		elapsed    time.Duration
+		err        error
+		wantPrefix string // Expected start of the logged line, after the caller; empty when nothing is logged
+	}{
+		{name: "silent", level: logger.Silent, err: failure},
+		{name: "error", level: logger.Error, err: failure, wantPrefix: "syntax error"},
+		{name: "fast query at warn", level: logger.Warn, slow: time.Second, elapsed: time.Millisecond},
+		{name: "slow query at warn", level: logger.Warn, slow: 10 * time.Millisecond, elapsed: 50 * time.Millisecond, wantPrefix: "SLOW SQL >= 10ms"},
+		{name: "slow query at error", level: logger.Error, slow: 10 * time.Millisecond, elapsed: 50 * time.Millisecond},
+		{name: "every query at info", level: logger.Info, elapsed: time.Millisecond, wantPrefix: ""},
+		{name: "ignored not found", level: logger.Warn, ignoreNF: true, err: gorm.ErrRecordNotFound},
+		{name: "reported not found", level: logger.Warn, err: gorm.ErrRecordNotFound, wantPrefix: gorm.ErrRecordNotFound.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &lineWriter{}
+			l := &QueryLogger{
+				Interface: logger.Discard,
+				writer:    writer,
+				config:    logger.Config{LogLevel: tt.level, SlowThreshold: tt.slow, IgnoreRecordNotFoundError: tt.ignoreNF},
+				metrics:   NewMetrics(DefaultBuckets),
+			}
+			ctx := requestid.WithContext(context.Background(), "req-1")
+			rendered := 0
+			l.Trace(ctx, time.Now().Add(-tt.elapsed), func() (string, int64) {
+				rendered++
+				return "SELECT * FROM users", 3
+			}, tt.err)
+
+			if stats := l.metrics.Snapshot(); len(stats) != 0 {
+				t.Errorf("metrics = %+v, want them left to the callbacks", stats)
+			}
+			logged := len(writer.lines) > 0
+			wantLogged := tt.wantPrefix != "" || tt.level == logger.Info
+			if logged != wantLogged {
+				t.Fatalf("logged %v, want %v", writer.lines, wantLogged)
+			}
+			if wantRendered := map[bool]int{true: 1}[wantLogged]; rendered != wantRendered {
+				t.Errorf("SQL rendered %d times, want %d", rendered, wantRendered)
+			}
+			if !logged {
+				return
+			}
+			line := writer.lines[0]
+			if !strings.Contains(line, "logger_test.go") {
+				t.Errorf("line %q does not report the calling file", line)
+			}
+			if !strings.Contains(line, " "+tt.wantPrefix+"\n") {
+				t.Errorf("line %q does not start with %q", line, tt.wantPrefix)
+			}
+			if !strings.Contains(line, "[rows:3] [request:req-1] SELECT * FROM users") {
+				t.Errorf("line %q lacks the rows, request ID or SQL", line)
+			}
+		})
+	}
+}
+
+func TestQueryLoggerFromConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      map[string]interface{}
+		debug       bool
+		wantLevel   logger.LogLevel
+		wantSlow    time.Duration
+		wantMetrics bool
+		wantErr     error
+	}{
+		{name: "silent by default", wantLevel: logger.Silent, wantMetrics: true},
+		{name: "info in debug", debug: true, wantLevel: logger.Info, wantMetrics: true},
+		{name: "warn with a slow threshold", values: map[string]interface{}{"slow_threshold": 200 * time.Millisecond}, wantLevel: logger.Warn, wantSlow: 200 * time.Millisecond, wantMetrics: true},
+		{name: "slow threshold in seconds", values: map[string]interface{}{"slow_threshold": 2}, wantLevel: logger.Warn, wantSlow: 2 * time.Second, wantMetrics: true},
+		{name: "explicit level", values: map[string]interface{}{"level": "ERROR"}, debug: true, wantLevel: logger.Error, wantMetrics: true},
+		{name: "metrics disabled", values: map[string]interface{}{"metrics": false}, wantLevel: logger.Silent},
+		{name: "unknown level", values: map[string]interface{}{"level": "verbose"}, wantErr: ErrInvalidLoggingConfig},
+		{name: "invalid slow threshold", values: map[string]interface{}{"slow_threshold": "1s"}, wantErr: ErrInvalidLoggingConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := queryLoggerFromConfig(tt.values, tt.debug)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("queryLoggerFromConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if l.config.LogLevel != tt.wantLevel || l.config.SlowThreshold != tt.wantSlow || l.disableMetrics == tt.wantMetrics {
+				t.Fatalf("config = %+v metrics %v, want level %v, slow %s and metrics %v", l.config, !l.disableMetrics, tt.wantLevel, tt.wantSlow, tt.wantMetrics)
+			}
+		})
+	}
+}
+
+func TestMetricsPlugin(t *testing.T) {
+	type product struct {
+		ID   int
+		Name string
+	}
+	tests := []struct {
+		name      string
+		run       func(db *gorm.DB) error
+		wantStats []string // Table, operation, count and errors of every entry
+	}{
+		{
+			name:      "create",
+			run:       func(db *gorm.DB) error { return db.Create(&product{ID: 2, Name: "b"}).Error },
+			wantStats: []string{"products INSERT 1 0"},
+		},
+		{
+			name:      "duplicate create",
+			run:       func(db *gorm.DB) error { return db.Create(&product{ID: 1, Name: "b"}).Error },
+			wantStats: []string{"products INSERT 1 1"},
+		},
+		{
+			name: "queries",
+			run: func(db *gorm.DB) error {
+				var found []product
+				db.First(&product{}, 1)
+				db.First(&product{}, 9)
+				return db.Find(&found).Error
+			},
+			wantStats: []string{"products SELECT 3 0"},
+		},
+		{
+			name:      "update",
+			run:       func(db *gorm.DB) error { return db.Model(&product{ID: 1}).Update("name", "c").Error },
+			wantStats: []string{"products UPDATE 1 0"},
+		},
+		{
+			name:      "delete",
+			run:       func(db *gorm.DB) error { return db.Delete(&product{ID: 1}).Error },
+			wantStats: []string{"products DELETE 1 0"},
+		},
+		{
+			name: "raw and row",
+			run: func(db *gorm.DB) error {
+				var count int
+				if err := db.Raw(`SELECT count(*) FROM "products"`).Scan(&count).Error; err != nil {
+					return err
+				}
+				return db.Exec(`UPDATE "products" SET "name" = 'd'`).Error
+			},
+			wantStats: []string{"products SELECT 1 0", "products UPDATE 1 0"},
+		},
+		{
+			name:      "dry run",
+			run:       func(db *gorm.DB) error { return db.Session(&gorm.Session{DryRun: true}).First(&product{}).Error },
+			wantStats: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := metricsPlugin{metrics: NewMetrics(DefaultBuckets)}
+			db := testdb.Open(t, &product{})
+			if err := db.Create(&product{ID: 1, Name: "a"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Use(plugin); err != nil {
+				t.Fatal(err)
+			}
+			_ = tt.run(db)
+
+			stats := []string{}
+			for _, entry := range plugin.metrics.Snapshot() {
+				stats = append(stats, fmt.Sprintf("%s %s %d %d", entry.Table, entry.Operation, entry.Count, entry.Errors))
+			}
+			if strings.Join(stats, ",") != strings.Join(tt.wantStats, ",") {
+				t.Errorf("metrics = %v, want %v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestQueryLoggerPlugins(t *testing.T) {
+	enabled := &QueryLogger{metrics: NewMetrics(DefaultBuckets)}
+	if plugins := enabled.plugins(); len(plugins) != 1 {
+		t.Errorf("plugins() = %v, want the metrics plugin", plugins)
+	}
+	if enabled.plugins()["capyvel:metrics"] == nil {
+		t.Error("plugins() does not return a new map on every call")
+	}
+	disabled := &QueryLogger{disableMetrics: true}
+	if plugins := disabled.plugins(); len(plugins) != 0 {
+		t.Errorf("plugins() = %v, want none with the metrics disabled", plugins)
+	}
+}
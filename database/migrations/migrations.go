@@ -0,0 +1,313 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// TableName is the table where the applied migrations are recorded
+const TableName = "capyvel_migrations"
+
+var (
+	ErrMigrationIDRequired  = errors.New("migration ID is required")                    // Triggered when registering a migration without ID
+	ErrDuplicatedMigration  = errors.New("migration already registered")                // Triggered when two migrations share the same ID
+	ErrMigrationWithoutUp   = errors.New("migration has no Up function nor SQL file")   // Triggered when a migration cannot be applied
+	ErrMigrationWithoutDown = errors.New("migration has no Down function nor SQL file") // Triggered when a migration cannot be rolled back
+	ErrMigrationNotFound    = errors.New("applied migration is not registered")         // Triggered when rolling back a migration missing from the registry
+	ErrTrackingTable        = errors.New("error preparing the migrations table")        // Triggered when the tracking table cannot be created
+	ErrMigrationFailed      = errors.New("migration failed")                            // Triggered when the Up or Down step of a migration fails
+	ErrReadingSQLFile       = errors.New("error reading migration SQL file")            // Triggered when a SQL file cannot be read
+
+	// Global registry of migrations
+	registry   = map[string]Migration{}
+	registryMu sync.RWMutex
+)
+
+// Migration describes a versioned schema change. Up and Down may be Go functions, SQL files or both;
+// when both are declared the SQL file runs first.
+type Migration struct {
+	ID                 string                  // Unique and sortable identifier (e.g. 20240101120000_create_users)
+	Up                 func(tx *gorm.DB) error // Applies the change
+	Down               func(tx *gorm.DB) error // Reverts the change
+	UpFile             string                  // Optional SQL file applied on Up
+	DownFile           string                  // Optional SQL file applied on Down
+	FS                 fs.FS                   // File system for UpFile and DownFile, the working directory when nil
+	DisableTransaction bool                    // Run outside of a transaction even if the driver supports it
+}
+
+// Record is a row of the tracking table
+type Record struct {
+	ID        string    `gorm:"column:id;primaryKey;size:255"`
+	Batch     int       `gorm:"column:batch"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+// TableName implements gorm's Tabler
+func (Record) TableName() string {
+	return TableName
+}
+
+// State describes whether a registered migration was applied
+type State struct {
+	ID        string
+	Applied   bool
+	Batch     int
+	AppliedAt *time.Time
+}
+
+// Register adds migrations to the global registry
+func Register(migrations ...Migration) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, migration := range migrations {
+		if migration.ID == "" {
+			return ErrMigrationIDRequired
+		}
+		if _, exists := registry[migration.ID]; exists {
+			return fmt.Errorf("%w: %s", ErrDuplicatedMigration, migration.ID)
+		}
+		if migration.Up == nil && migration.UpFile == "" {
+			return fmt.Errorf("%w: %s", ErrMigrationWithoutUp, migration.ID)
+		}
+		registry[migration.ID] = migration
+	}
+	return nil
+}
+
+// Registered returns the registered migrations sorted by ID
+func Registered() []Migration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	migrations := make([]Migration, 0, len(registry))
+	for _, migration := range registry {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].ID < migrations[j].ID })
+	return migrations
+}
+
+// Migrator runs the registered migrations against a connection
+type Migrator struct {
+	db            *gorm.DB
+	transactional bool
+}
+
+// New creates a migrator for the default connection (database.DB.Ctx)
+func New() *Migrator {
+	return Connection("")
+}
+
+// Connection creates a migrator for a named connection of database.Boot
+func Connection(name string) *Migrator {
+	return &Migrator{
+		db:            database.DB.Connection(name).Clauses(dbresolver.Write).Session(&gorm.Session{}),
+		transactional: database.ConnectionDriver(name) != database.DriverMysql, // MySQL commits DDL implicitly
+	}
+}
+
+// Migrate applies every pending migration in a new batch
+func Migrate() error { return New().Migrate() }
+
+// Rollback reverts the given number of migrations, or the last batch when steps <= 0
+func Rollback(steps int) error { return New().Rollback(steps) }
+
+// Status returns every registered migration with its applied state
+func Status() ([]State, error) { return New().Status() }
+
+// Fresh reverts every applied migration and applies them all again
+func Fresh() error { return New().Fresh() }
+
+// Migrate applies every pending migration in a new batch
+func (m *Migrator) Migrate() error {
+	records, err := m.applied()
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]bool, len(records))
+	batch := 0
+	for _, record := range records {
+		applied[record.ID] = true
+		if record.Batch > batch {
+			batch = record.Batch
+		}
+	}
+	batch++
+
+	pending := 0
+	for _, migration := range Registered() {
+		if applied[migration.ID] {
+			continue
+		}
+		err := m.run(migration, func(tx *gorm.DB) error {
+			if err := runStep(tx, migration, migration.UpFile, migration.Up); err != nil {
+				return err
+			}
+			return tx.Create(&Record{ID: migration.ID, Batch: batch, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrMigrationFailed, migration.ID, err)
+		}
+		color.Greenf("Migrated: %s\n", migration.ID)
+		pending++
+	}
+	if pending == 0 {
+		color.Yellowf("Nothing to migrate.\n")
+	}
+	return nil
+}
+
+// Rollback reverts the given number of migrations, or the last batch when steps <= 0
+func (m *Migrator) Rollback(steps int) error {
+	records, err := m.applied()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		color.Yellowf("Nothing to rollback.\n")
+		return nil
+	}
+	newestFirst(records)
+	if steps <= 0 {
+		lastBatch := records[0].Batch
+		steps = 0
+		for _, record := range records {
+			if record.Batch == lastBatch {
+				steps++
+			}
+		}
+	}
+	if steps > len(records) {
+		steps = len(records)
+	}
+	return m.rollback(records[:steps])
+}
+
+// Fresh reverts every applied migration and applies them all again
+func (m *Migrator) Fresh() error {
+	records, err := m.applied()
+	if err != nil {
+		return err
+	}
+	newestFirst(records)
+	if err := m.rollback(records); err != nil {
+		return err
+	}
+	return m.Migrate()
+}
+
+// Status returns every registered migration with its applied state
+func (m *Migrator) Status() ([]State, error) {
+	records, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]Record, len(records))
+	for _, record := range records {
+		applied[record.ID] = record
+	}
+	var statuses []State
+	for _, migration := range Registered() {
+		status := State{ID: migration.ID}
+		if record, ok := applied[migration.ID]; ok {
+			appliedAt := record.AppliedAt
+			status.Applied = true
+			status.Batch = record.Batch
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Reverts the given records in order
+func (m *Migrator) rollback(records []Record) error {
+	for _, record := range records {
+		registryMu.RLock()
+		migration, ok := registry[record.ID]
+		registryMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMigrationNotFound, record.ID)
+		}
+		if migration.Down == nil && migration.DownFile == "" {
+			return fmt.Errorf("%w: %s", ErrMigrationWithoutDown, record.ID)
+		}
+		err := m.run(migration, func(tx *gorm.DB) error {
+			if err := runStep(tx, migration, migration.DownFile, migration.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&Record{}, clause.Eq{Column: clause.Column{Name: "id"}, Value: record.ID}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrMigrationFailed, record.ID, err)
+		}
+		color.Greenf("Rolled back: %s\n", record.ID)
+	}
+	return nil
+}
+
+// Sorts the records with the most recent migrations first
+func newestFirst(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Batch != records[j].Batch {
+			return records[i].Batch > records[j].Batch
+		}
+		return records[i].ID > records[j].ID
+	})
+}
+
+// Runs a migration step inside a transaction when the driver and the migration allow it
+func (m *Migrator) run(migration Migration, fn func(tx *gorm.DB) error) error {
+	if m.transactional && !migration.DisableTransaction {
+		return m.db.Transaction(fn)
+	}
+	return fn(m.db)
+}
+
+// Returns the applied migrations, creating the tracking table if needed
+func (m *Migrator) applied() ([]Record, error) {
+	if err := m.db.AutoMigrate(&Record{}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrackingTable, err)
+	}
+	var records []Record
+	if err := m.db.Order("batch, id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrackingTable, err)
+	}
+	return records, nil
+}
+
+// Runs the SQL file and then the Go function of a migration step
+func runStep(tx *gorm.DB, migration Migration, file string, fn func(tx *gorm.DB) error) error {
+	if file != "" {
+		var content []byte
+		var err error
+		if migration.FS != nil {
+			content, err = fs.ReadFile(migration.FS, file)
+		} else {
+			content, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrReadingSQLFile, file, err)
+		}
+		if sql := strings.TrimSpace(string(content)); sql != "" {
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if fn != nil {
+		return fn(tx)
+	}
+	return nil
+}
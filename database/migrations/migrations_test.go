@@ -0,0 +1,269 @@
+package migrations
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// Returns a migrator on an in-memory database with an empty registry
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	db := testdb.Open(t)
+	registryMu.Lock()
+	registry = map[string]Migration{}
+	registryMu.Unlock()
+	return &Migrator{db: db, transactional: true}
+}
+
+// Returns a migration creating and dropping a table of the same name
+func tableMigration(id string) Migration {
+	return Migration{
+		ID:   id,
+		Up:   func(tx *gorm.DB) error { return tx.Exec("CREATE TABLE " + id + " (id integer)").Error },
+		Down: func(tx *gorm.DB) error { return tx.Exec("DROP TABLE " + id).Error },
+	}
+}
+
+// Returns the IDs of the applied migrations
+func appliedIDs(t *testing.T, m *Migrator) []string {
+	t.Helper()
+	records, err := m.applied()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []Migration
+		wantErr    error
+	}{
+		{name: "valid", migrations: []Migration{tableMigration("a"), tableMigration("b")}},
+		{name: "sql file only", migrations: []Migration{{ID: "a", UpFile: "a.up.sql"}}},
+		{name: "missing ID", migrations: []Migration{{Up: func(*gorm.DB) error { return nil }}}, wantErr: ErrMigrationIDRequired},
+		{name: "duplicated ID", migrations: []Migration{tableMigration("a"), tableMigration("a")}, wantErr: ErrDuplicatedMigration},
+		{name: "without up", migrations: []Migration{{ID: "a"}}, wantErr: ErrMigrationWithoutUp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestMigrator(t)
+			err := Register(tt.migrations...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]string // Migrations applied in each batch
+		steps   int
+		want    []string // Migrations still applied
+	}{
+		{name: "nothing applied, last batch", steps: 0},
+		{name: "nothing applied, negative steps", steps: -1},
+		{name: "nothing applied, some steps", steps: 2},
+		{name: "last batch", batches: [][]string{{"a", "b"}, {"c", "d"}}, steps: 0, want: []string{"a", "b"}},
+		{name: "negative steps roll back the last batch", batches: [][]string{{"a", "b"}, {"c"}}, steps: -1, want: []string{"a", "b"}},
+		{name: "steps across batches", batches: [][]string{{"a", "b"}, {"c"}}, steps: 2, want: []string{"a"}},
+		{name: "more steps than applied", batches: [][]string{{"a"}, {"b"}}, steps: 10, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMigrator(t)
+			for _, batch := range tt.batches {
+				for _, id := range batch {
+					if err := Register(tableMigration(id)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := m.Migrate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.Rollback(tt.steps); err != nil {
+				t.Fatalf("Rollback(%d) error = %v", tt.steps, err)
+			}
+			if got := appliedIDs(t, m); !slices.Equal(got, tt.want) {
+				t.Fatalf("applied = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbackErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(m *Migrator) error
+		wantErr error
+	}{
+		{
+			name: "without down",
+			setup: func(m *Migrator) error {
+				return Register(Migration{ID: "a", Up: func(*gorm.DB) error { return nil }})
+			},
+			wantErr: ErrMigrationWithoutDown,
+		},
+		{
+			name: "not registered",
+			setup: func(m *Migrator) error {
+				if _, err := m.applied(); err != nil {
+					return err
+				}
+				return m.db.Create(&Record{ID: "missing", Batch: 1}).Error
+			},
+			wantErr: ErrMigrationNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMigrator(t)
+			if err := tt.setup(m); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Migrate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Rollback(0); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rollback() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	files := fstest.MapFS{
+		"create.up.sql":   {Data: []byte("CREATE TABLE files (id integer);")},
+		"create.down.sql": {Data: []byte("DROP TABLE files;")},
+		"broken.up.sql":   {Data: []byte("CREATE TABLE;")},
+	}
+	tests := []struct {
+		name      string
+		migration Migration
+		wantErr   error
+		wantTable string // Table expected after Migrate
+	}{
+		{name: "go function", migration: tableMigration("functions"), wantTable: "functions"},
+		{name: "sql file", migration: Migration{ID: "files", UpFile: "create.up.sql", DownFile: "create.down.sql", FS: files}, wantTable: "files"},
+		{
+			name: "sql file then go function",
+			migration: Migration{ID: "both", UpFile: "create.up.sql", FS: files, Up: func(tx *gorm.DB) error {
+				return tx.Exec("INSERT INTO files (id) VALUES (1)").Error
+			}},
+			wantTable: "files",
+		},
+		{name: "missing sql file", migration: Migration{ID: "missing", UpFile: "missing.sql", FS: files}, wantErr: ErrReadingSQLFile},
+		{name: "invalid sql", migration: Migration{ID: "broken", UpFile: "broken.up.sql", FS: files}, wantErr: ErrMigrationFailed},
+		{
+			name: "failed step is rolled back",
+			migration: Migration{ID: "partial", Up: func(tx *gorm.DB) error {
+				if err := tx.Exec("CREATE TABLE partial (id integer)").Error; err != nil {
+					return err
+				}
+				return errors.New("step failed")
+			}},
+			wantErr: ErrMigrationFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMigrator(t)
+			if err := Register(tt.migration); err != nil {
+				t.Fatal(err)
+			}
+			err := m.Migrate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Migrate() error = %v, want %v", err, tt.wantErr)
+			}
+			applied := appliedIDs(t, m)
+			if tt.wantErr != nil {
+				if len(applied) != 0 {
+					t.Fatalf("applied = %v after a failed migration", applied)
+				}
+				if m.db.Migrator().HasTable(tt.migration.ID) {
+					t.Fatalf("table %s kept after a failed migration", tt.migration.ID)
+				}
+				return
+			}
+			if !slices.Equal(applied, []string{tt.migration.ID}) {
+				t.Fatalf("applied = %v, want [%s]", applied, tt.migration.ID)
+			}
+			if !m.db.Migrator().HasTable(tt.wantTable) {
+				t.Fatalf("table %s not created", tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	m := newTestMigrator(t)
+	if err := Register(tableMigration("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(tableMigration("b")); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []State{{ID: "a", Applied: true, Batch: 1}, {ID: "b"}}
+	if len(statuses) != len(want) {
+		t.Fatalf("Status() = %v, want %v", statuses, want)
+	}
+	for i, status := range statuses {
+		if status.ID != want[i].ID || status.Applied != want[i].Applied || status.Batch != want[i].Batch {
+			t.Errorf("Status()[%d] = %+v, want %+v", i, status, want[i])
+		}
+		if status.Applied != (status.AppliedAt != nil) {
+			t.Errorf("Status()[%d].AppliedAt = %v with Applied %v", i, status.AppliedAt, status.Applied)
+		}
+	}
+}
+
+func TestFresh(t *testing.T) {
+	m := newTestMigrator(t)
+	if err := Register(tableMigration("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(tableMigration("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Fresh(); err != nil {
+		t.Fatalf("Fresh() error = %v", err)
+	}
+	records, err := m.applied()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.Batch != 1 {
+			t.Errorf("record %s in batch %d after Fresh, want 1", record.ID, record.Batch)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("applied %d migrations after Fresh, want 2", len(records))
+	}
+}
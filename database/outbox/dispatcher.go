@@ -0,0 +1,218 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Default dispatcher settings
+const (
+	DefaultInterval    = 5 * time.Second
+	DefaultBatchSize   = 50
+	DefaultLockTimeout = time.Minute
+)
+
+// Default retry settings of the deliveries
+var DefaultRetry = database.RetryConfig{
+	MaxAttempts:  10,
+	InitialDelay: 5 * time.Second,
+	MaxDelay:     10 * time.Minute,
+	Jitter:       0.2,
+}
+
+// DispatcherConfig holds the settings of a Dispatcher
+type DispatcherConfig struct {
+	Interval    time.Duration        // Polling interval, DefaultInterval when zero
+	BatchSize   int                  // Messages read per poll, DefaultBatchSize when zero
+	LockTimeout time.Duration        // Time a claimed message is hidden from other dispatchers and bound of its delivery, DefaultLockTimeout when zero
+	Retry       database.RetryConfig // Attempts and backoff of the deliveries, DefaultRetry when MaxAttempts is zero
+	Sinks       map[string]Sink      // Sinks by topic
+	Default     Sink                 // Sink of the topics without their own sink
+}
+
+// Dispatcher delivers the pending messages of the outbox to their sinks in the background.
+// Several dispatchers may run against the same table: each message is claimed before delivery,
+// and a claim expires after LockTimeout so that messages of a crashed dispatcher are retried.
+type Dispatcher struct {
+	db     *gorm.DB
+	config DispatcherConfig
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDispatcher creates a dispatcher for the outbox of the default connection
+func NewDispatcher(config DispatcherConfig) *Dispatcher {
+	return NewDispatcherFor(database.DB.Ctx, config)
+}
+
+// NewDispatcherFor creates a dispatcher for the outbox of the given connection
+func NewDispatcherFor(db *gorm.DB, config DispatcherConfig) *Dispatcher {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = DefaultLockTimeout
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry = DefaultRetry
+	}
+	return &Dispatcher{db: db, config: config}
+}
+
+// Start polls the outbox in a goroutine until Stop is called or the context is done
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		return ErrDispatcherState
+	}
+	if d.db == nil {
+		return database.ErrDatabaseNotBooted
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.done = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+		for {
+			// The errors of a batch interrupted by Stop are not reported
+			if _, err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+				color.Redf("[outbox] %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}(d.done)
+	return nil
+}
+
+// Stop stops the polling and waits for the current batch to finish
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.cancel, d.done = nil, nil
+	d.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Dispatch delivers one batch of available messages and returns how many were sent
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	db := d.db.Clauses(dbresolver.Write).WithContext(ctx)
+	var messages []Message
+	err := db.Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: StatusPending}).
+		Where(clause.Lte{Column: clause.Column{Name: "available_at"}, Value: time.Now()}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}}).
+		Limit(d.config.BatchSize).
+		Find(&messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrReadingMessages, err)
+	}
+
+	sent := 0
+	var errs []error
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		claimed, err := d.claim(db, &message)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := d.deliver(ctx, db, message); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// Claims a message by pushing its availability past the lock timeout, counting the attempt.
+// The claim fails when another dispatcher claimed the message first.
+func (d *Dispatcher) claim(db *gorm.DB, message *Message) (bool, error) {
+	availableAt := time.Now().Add(d.config.LockTimeout)
+	result := db.Model(&Message{}).
+		Where(clause.Eq{Column: clause.Column{Name: "id"}, Value: message.ID}).
+		Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: StatusPending}).
+		Where(clause.Eq{Column: clause.Column{Name: "attempts"}, Value: message.Attempts}).
+		Updates(map[string]interface{}{"available_at": availableAt, "attempts": message.Attempts + 1})
+	if result.Error != nil {
+		return false, fmt.Errorf("%w: %d: %v", ErrUpdatingMessage, message.ID, result.Error)
+	}
+	message.Attempts++
+	message.AvailableAt = availableAt
+	return result.RowsAffected == 1, nil
+}
+
+// Sends a claimed message to its sink and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, db *gorm.DB, message Message) error {
+	sink, ok := d.config.Sinks[message.Topic]
+	if !ok {
+		sink = d.config.Default
+	}
+	var sendErr error
+	if sink == nil {
+		sendErr = fmt.Errorf("%w: %s", ErrNoSink, message.Topic)
+	} else {
+		// Give up before the claim expires, when another dispatcher may deliver the message again
+		sendCtx, cancel := context.WithDeadline(ctx, message.AvailableAt.Add(-d.config.LockTimeout/10))
+		sendErr = sink.Send(sendCtx, message)
+		cancel()
+	}
+
+	values := map[string]interface{}{}
+	if sendErr == nil {
+		now := time.Now()
+		values["status"] = StatusSent
+		values["sent_at"] = now
+		values["last_error"] = ""
+	} else {
+		values["last_error"] = truncate(sendErr.Error(), 1024)
+		if message.Attempts >= d.config.Retry.MaxAttempts {
+			values["status"] = StatusFailed
+		} else {
+			values["available_at"] = time.Now().Add(d.config.Retry.Delay(message.Attempts))
+		}
+	}
+	err := db.Model(&Message{}).Where(clause.Eq{Column: clause.Column{Name: "id"}, Value: message.ID}).Updates(values).Error
+	if err != nil {
+		return fmt.Errorf("%w: %d: %v", ErrUpdatingMessage, message.ID, err)
+	}
+	if sendErr != nil {
+		return fmt.Errorf("%w: %s #%d (attempt %d/%d): %v", ErrSinkFailed, message.Topic, message.ID, message.Attempts, d.config.Retry.MaxAttempts, sendErr)
+	}
+	return nil
+}
+
+// Cuts a string to the size of its column
+func truncate(value string, size int) string {
+	if len(value) > size {
+		return value[:size]
+	}
+	return value
+}
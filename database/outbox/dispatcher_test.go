@@ -0,0 +1,256 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nd-tools/capyvel/database"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// Sink failing every delivery
+var failingSink = SinkFunc(func(context.Context, Message) error { return errUnreachable })
+
+// Returns a sink counting its deliveries
+func countingSink(calls *int) Sink {
+	return SinkFunc(func(context.Context, Message) error {
+		*calls++
+		return nil
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	retry := database.RetryConfig{MaxAttempts: 3, InitialDelay: time.Minute}
+	sentAt := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name         string
+		message      Message
+		sinks        map[string]Sink
+		fallback     bool // Whether the default sink is a failing one
+		wantSent     int
+		wantErr      error
+		wantStatus   string
+		wantAttempts int
+		wantDelay    time.Duration // Delay before the next attempt of a pending message
+		wantError    string        // Stored error of the last attempt
+	}{
+		{
+			name:         "delivered",
+			message:      Message{Topic: "orders"},
+			sinks:        map[string]Sink{"orders": SinkFunc(func(context.Context, Message) error { return nil })},
+			wantSent:     1,
+			wantStatus:   StatusSent,
+			wantAttempts: 1,
+		},
+		{
+			name:         "topic sink before the default",
+			message:      Message{Topic: "orders"},
+			sinks:        map[string]Sink{"orders": SinkFunc(func(context.Context, Message) error { return nil })},
+			fallback:     true,
+			wantSent:     1,
+			wantStatus:   StatusSent,
+			wantAttempts: 1,
+		},
+		{
+			name:         "default sink",
+			message:      Message{Topic: "invoices"},
+			sinks:        map[string]Sink{"orders": SinkFunc(func(context.Context, Message) error { return nil })},
+			fallback:     true,
+			wantErr:      ErrSinkFailed,
+			wantStatus:   StatusPending,
+			wantAttempts: 1,
+			wantDelay:    time.Minute,
+			wantError:    errUnreachable.Error(),
+		},
+		{
+			name:         "no sink",
+			message:      Message{Topic: "invoices"},
+			wantErr:      ErrSinkFailed,
+			wantStatus:   StatusPending,
+			wantAttempts: 1,
+			wantDelay:    time.Minute,
+			wantError:    ErrNoSink.Error(),
+		},
+		{
+			name:         "backoff grows with the attempts",
+			message:      Message{Topic: "orders", Attempts: 1},
+			fallback:     true,
+			wantErr:      ErrSinkFailed,
+			wantStatus:   StatusPending,
+			wantAttempts: 2,
+			wantDelay:    2 * time.Minute,
+			wantError:    errUnreachable.Error(),
+		},
+		{
+			name:         "last attempt",
+			message:      Message{Topic: "orders", Attempts: 2},
+			fallback:     true,
+			wantErr:      ErrSinkFailed,
+			wantStatus:   StatusFailed,
+			wantAttempts: 3,
+			wantError:    errUnreachable.Error(),
+		},
+		{
+			name:         "not available yet",
+			message:      Message{Topic: "orders", AvailableAt: time.Now().Add(time.Hour)},
+			sinks:        map[string]Sink{"orders": SinkFunc(func(context.Context, Message) error { return nil })},
+			wantStatus:   StatusPending,
+			wantAttempts: 0,
+			wantDelay:    time.Hour,
+		},
+		{
+			name:         "already sent",
+			message:      Message{Topic: "orders", Status: StatusSent, Attempts: 1, SentAt: &sentAt},
+			fallback:     true,
+			wantStatus:   StatusSent,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			message := tt.message
+			if message.Status == "" {
+				message.Status = StatusPending
+			}
+			if message.AvailableAt.IsZero() {
+				message.AvailableAt = time.Now().Add(-time.Second)
+			}
+			if err := db.Create(&message).Error; err != nil {
+				t.Fatal(err)
+			}
+			config := DispatcherConfig{Sinks: tt.sinks, Retry: retry}
+			if tt.fallback {
+				config.Default = failingSink
+			}
+
+			start := time.Now()
+			sent, err := NewDispatcherFor(db, config).Dispatch(context.Background())
+			if sent != tt.wantSent || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Dispatch() = %d, %v, want %d, %v", sent, err, tt.wantSent, tt.wantErr)
+			}
+			var stored Message
+			if err := db.Take(&stored, message.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus || stored.Attempts != tt.wantAttempts || !strings.Contains(stored.LastError, tt.wantError) {
+				t.Fatalf("message = %+v, want status %s, %d attempts and error %q", stored, tt.wantStatus, tt.wantAttempts, tt.wantError)
+			}
+			if (stored.SentAt != nil) != (tt.wantStatus == StatusSent) {
+				t.Errorf("sent at = %v for status %s", stored.SentAt, stored.Status)
+			}
+			if tt.wantDelay > 0 {
+				if delay := stored.AvailableAt.Sub(start); delay < tt.wantDelay-time.Second || delay > tt.wantDelay+time.Second {
+					t.Errorf("next attempt in %s, want %s", delay, tt.wantDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchBatchSize(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 3; i++ {
+		if err := Publish(db, "orders", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var order []string
+	d := NewDispatcherFor(db, DispatcherConfig{BatchSize: 2, Default: SinkFunc(func(_ context.Context, message Message) error {
+		order = append(order, message.Payload)
+		return nil
+	})})
+	for _, want := range []int{2, 1, 0} {
+		if sent, err := d.Dispatch(context.Background()); sent != want || err != nil {
+			t.Fatalf("Dispatch() = %d, %v, want %d", sent, err, want)
+		}
+	}
+	if strings.Join(order, ",") != "0,1,2" {
+		t.Fatalf("delivery order = %v, want 0,1,2", order)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	db := newTestDB(t)
+	if err := Publish(db, "orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	var message Message
+	if err := db.Take(&message).Error; err != nil {
+		t.Fatal(err)
+	}
+	stale := message
+	d := NewDispatcherFor(db, DispatcherConfig{})
+	if claimed, err := d.claim(db, &message); !claimed || err != nil {
+		t.Fatalf("claim() = %v, %v, want the message claimed", claimed, err)
+	}
+	if message.Attempts != 1 || time.Until(message.AvailableAt) < DefaultLockTimeout-time.Second {
+		t.Fatalf("claimed message = %+v, want one attempt and hidden for the lock timeout", message)
+	}
+	// Another dispatcher read the message before the claim
+	if claimed, err := d.claim(db, &stale); claimed || err != nil {
+		t.Fatalf("second claim() = %v, %v, want the message not claimed", claimed, err)
+	}
+}
+
+func TestDeliveryEndsBeforeTheClaimExpires(t *testing.T) {
+	db := newTestDB(t)
+	if err := Publish(db, "orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	lockTimeout := 10 * time.Second
+	var deadline time.Time
+	d := NewDispatcherFor(db, DispatcherConfig{LockTimeout: lockTimeout, Default: SinkFunc(func(ctx context.Context, _ Message) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	})})
+	if _, err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var claimed Message
+	if err := db.Take(&claimed).Error; err != nil {
+		t.Fatal(err)
+	}
+	// The claim expires after the lock timeout, the delivery a tenth before
+	if want := claimed.AvailableAt.Add(-lockTimeout / 10); deadline.Sub(want).Abs() > time.Millisecond {
+		t.Fatalf("delivery deadline = %s, want %s", deadline, want)
+	}
+}
+
+func TestDispatcherStartStop(t *testing.T) {
+	if err := NewDispatcherFor(nil, DispatcherConfig{}).Start(context.Background()); !errors.Is(err, database.ErrDatabaseNotBooted) {
+		t.Fatalf("Start() without database error = %v, want %v", err, database.ErrDatabaseNotBooted)
+	}
+
+	db := newTestDB(t)
+	delivered := make(chan struct{}, 10)
+	d := NewDispatcherFor(db, DispatcherConfig{Interval: 10 * time.Millisecond, Default: SinkFunc(func(context.Context, Message) error {
+		delivered <- struct{}{}
+		return nil
+	})})
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); !errors.Is(err, ErrDispatcherState) {
+		t.Fatalf("second Start() error = %v, want %v", err, ErrDispatcherState)
+	}
+	if err := Publish(db, "orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dispatcher did not deliver the message")
+	}
+	d.Stop()
+	d.Stop()
+	// A stopped dispatcher can be started again
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() after Stop() error = %v", err)
+	}
+	d.Stop()
+}
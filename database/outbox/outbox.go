@@ -0,0 +1,105 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database"
+	"github.com/nd-tools/capyvel/database/migrations"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// TableName is the table where the pending messages are stored
+const TableName = "capyvel_outbox"
+
+// Statuses of a message
+const (
+	StatusPending = "pending" // Waiting to be delivered, or to be retried
+	StatusSent    = "sent"    // Delivered to its sink
+	StatusFailed  = "failed"  // Every delivery attempt failed
+)
+
+var (
+	ErrTopicRequired   = errors.New("outbox topic is required")             // Triggered when publishing a message without topic
+	ErrEncodingPayload = errors.New("error encoding outbox payload")        // Triggered when the payload cannot be serialized
+	ErrWritingMessage  = errors.New("error writing outbox message")         // Triggered when a message cannot be stored
+	ErrNoSink          = errors.New("no sink registered for the topic")     // Triggered when the dispatcher has no sink for a message
+	ErrReadingMessages = errors.New("error reading outbox messages")        // Triggered when the pending messages cannot be read
+	ErrUpdatingMessage = errors.New("error updating outbox message")        // Triggered when the state of a message cannot be saved
+	ErrSinkFailed      = errors.New("outbox sink failed to deliver")        // Triggered when a sink returns an error
+	ErrDispatcherState = errors.New("outbox dispatcher is already running") // Triggered when starting a running dispatcher
+)
+
+// Migration creates the outbox table; register it with migrations.Register before publishing
+var Migration = migrations.Migration{
+	ID:   "00000000000001_create_" + TableName,
+	Up:   func(tx *gorm.DB) error { return tx.AutoMigrate(&Message{}) },
+	Down: func(tx *gorm.DB) error { return tx.Migrator().DropTable(&Message{}) },
+}
+
+// Message is a row of the outbox table
+type Message struct {
+	ID          uint64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Topic       string     `gorm:"column:topic;size:255" json:"topic"`
+	Payload     string     `gorm:"column:payload" json:"payload"`
+	Status      string     `gorm:"column:status;size:16;index:idx_capyvel_outbox_pending" json:"status"`
+	Attempts    int        `gorm:"column:attempts" json:"attempts"`
+	AvailableAt time.Time  `gorm:"column:available_at;index:idx_capyvel_outbox_pending" json:"availableAt"`
+	LastError   string     `gorm:"column:last_error;size:1024" json:"lastError,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"createdAt"`
+	SentAt      *time.Time `gorm:"column:sent_at" json:"sentAt,omitempty"`
+}
+
+// TableName implements gorm's Tabler
+func (Message) TableName() string {
+	return TableName
+}
+
+// Decode unmarshals the JSON payload of the message into v
+func (message Message) Decode(v any) error {
+	return json.Unmarshal([]byte(message.Payload), v)
+}
+
+// Publish writes a message to the outbox with tx, so that it is committed or rolled back
+// together with the business change made in the same transaction
+func Publish(tx *gorm.DB, topic string, payload any) error {
+	if topic == "" {
+		return ErrTopicRequired
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrEncodingPayload, topic, err)
+	}
+	now := time.Now()
+	message := Message{
+		Topic:       topic,
+		Payload:     string(encoded),
+		Status:      StatusPending,
+		AvailableAt: now,
+		CreatedAt:   now,
+	}
+	if err := tx.Clauses(dbresolver.Write).Create(&message).Error; err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrWritingMessage, topic, err)
+	}
+	return nil
+}
+
+// PublishContext writes a message to the outbox with the request transaction stored by the
+// Transaction middleware, or with the tenant or default connection of the request
+func PublishContext(ctx *gin.Context, topic string, payload any) error {
+	if tx, ok := database.ContextTransaction(ctx); ok {
+		return Publish(tx.WithContext(ctx), topic, payload)
+	}
+	db, err := database.ContextConnection(ctx)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return database.ErrDatabaseNotBooted
+	}
+	return Publish(db.WithContext(ctx), topic, payload)
+}
@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// Opens an in-memory database holding the outbox table
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	if err := Migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPublish(t *testing.T) {
+	tests := []struct {
+		name     string
+		topic    string
+		payload  any
+		rollback bool
+		wantErr  error
+		wantRows int64
+	}{
+		{name: "committed", topic: "orders.created", payload: map[string]int{"id": 1}, wantRows: 1},
+		{name: "rolled back with the transaction", topic: "orders.created", payload: map[string]int{"id": 1}, rollback: true},
+		{name: "without topic", payload: 1, wantErr: ErrTopicRequired},
+		{name: "payload is not JSON", topic: "orders.created", payload: make(chan int), wantErr: ErrEncodingPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			rollback := errors.New("rollback")
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := Publish(tx, tt.topic, tt.payload); err != nil {
+					return err
+				}
+				if tt.rollback {
+					return rollback
+				}
+				return nil
+			})
+			if tt.rollback {
+				if !errors.Is(err, rollback) {
+					t.Fatalf("transaction error = %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Publish() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var messages []Message
+			if err := db.Find(&messages).Error; err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(messages)) != tt.wantRows {
+				t.Fatalf("outbox holds %d messages, want %d", len(messages), tt.wantRows)
+			}
+			if tt.wantRows == 0 {
+				return
+			}
+			var payload map[string]int
+			if err := messages[0].Decode(&payload); err != nil || payload["id"] != 1 {
+				t.Fatalf("payload = %q (%v)", messages[0].Payload, err)
+			}
+			if messages[0].Status != StatusPending || messages[0].Topic != tt.topic || messages[0].AvailableAt.IsZero() {
+				t.Fatalf("message = %+v, want a pending %s message", messages[0], tt.topic)
+			}
+		})
+	}
+}
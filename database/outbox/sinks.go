@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+// DefaultWebhookTimeout bounds the deliveries of the WebhookSinks without their own client,
+// shorter than DefaultLockTimeout so that a hanging endpoint does not outlive the claim
+const DefaultWebhookTimeout = 30 * time.Second
+
+// Client of the WebhookSinks without their own client
+var defaultWebhookClient = &http.Client{Timeout: DefaultWebhookTimeout}
+
+// Sink delivers the messages of the outbox to another system
+type Sink interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, message Message) error
+
+// Send implements Sink
+func (f SinkFunc) Send(ctx context.Context, message Message) error {
+	return f(ctx, message)
+}
+
+// HandlerSink delivers the messages to an in-process handler
+func HandlerSink(handler func(ctx context.Context, message Message) error) Sink {
+	return SinkFunc(handler)
+}
+
+// LogSink prints the messages, which is useful during development
+type LogSink struct{}
+
+// Send implements Sink
+func (LogSink) Send(ctx context.Context, message Message) error {
+	color.Cyanf("[outbox] %s #%d: %s\n", message.Topic, message.ID, message.Payload)
+	return nil
+}
+
+// WebhookSink posts the JSON payload of the messages to an HTTP endpoint.
+// Any status outside of 2xx is a failed delivery.
+type WebhookSink struct {
+	URL     string            // Endpoint receiving the messages
+	Headers map[string]string // Extra headers (e.g. Authorization)
+	Client  *http.Client      // HTTP client, one with DefaultWebhookTimeout when nil
+}
+
+// Send implements Sink
+func (sink WebhookSink) Send(ctx context.Context, message Message) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewBufferString(message.Payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Outbox-Topic", message.Topic)
+	request.Header.Set("X-Outbox-Message-Id", strconv.FormatUint(message.ID, 10))
+	for key, value := range sink.Headers {
+		request.Header.Set(key, value)
+	}
+	client := sink.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook %s responded %s", sink.URL, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted with headers", status: http.StatusAccepted, headers: map[string]string{"Authorization": "Bearer token"}},
+		{name: "redirect", status: http.StatusMovedPermanently, wantErr: true},
+		{name: "client error", status: http.StatusUnprocessableEntity, wantErr: true},
+		{name: "server error", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *http.Request
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				received, body = r, string(data)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			sink := WebhookSink{URL: server.URL, Headers: tt.headers}
+			err := sink.Send(context.Background(), Message{ID: 42, Topic: "orders.created", Payload: `{"id":1}`})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, want error %v", err, tt.wantErr)
+			}
+			if received.Method != http.MethodPost || body != `{"id":1}` {
+				t.Fatalf("request = %s %q, want POST of the payload", received.Method, body)
+			}
+			want := map[string]string{"Content-Type": "application/json", "X-Outbox-Topic": "orders.created", "X-Outbox-Message-Id": "42"}
+			for key, value := range tt.headers {
+				want[key] = value
+			}
+			for key, value := range want {
+				if got := received.Header.Get(key); got != value {
+					t.Errorf("header %s = %q, want %q", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookSinkTimeout(t *testing.T) {
+	if DefaultWebhookTimeout >= DefaultLockTimeout || defaultWebhookClient.Timeout != DefaultWebhookTimeout {
+		t.Fatalf("default webhook timeout %s, want one shorter than the lock timeout %s", defaultWebhookClient.Timeout, DefaultLockTimeout)
+	}
+
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := (WebhookSink{URL: server.URL}).Send(ctx, Message{Payload: "{}"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Resolver policies available for the `policy` key of a connection
+const (
+	PolicyRandom     = "random"
+	PolicyRoundRobin = "round-robin"
+	PolicyWeighted   = "weighted"
+
+	// Policy used when a connection does not declare one
+	DefaultPolicy = PolicyRandom
+)
+
+var (
+	ErrUnknownPolicy   = errors.New("unknown resolver policy")                       // Triggered when a connection declares a policy that is not registered
+	ErrInvalidReplicas = errors.New("replicas must be a list of connection configs") // Triggered when the `replicas` key is malformed
+	ErrInvalidWeights  = errors.New("weights must be a list of positive integers")   // Triggered when the `weights` key is malformed
+
+	// Custom policies registered in code by name
+	customPolicies   = map[string]dbresolver.Policy{}
+	customPoliciesMu sync.RWMutex
+)
+
+// Keys of a connection config that belong to the resolver and are not inherited by its replicas
+var resolverKeys = []string{"replicas", "policy", "weights", "datas"}
+
+// RegisterPolicy registers a custom resolver policy that connections can select with the `policy` key.
+// It must be called before Boot.
+func RegisterPolicy(name string, policy dbresolver.Policy) {
+	customPoliciesMu.Lock()
+	defer customPoliciesMu.Unlock()
+	customPolicies[strings.ToLower(name)] = policy
+}
+
+// WeightedPolicy picks a connection pool at random, proportionally to its weight.
+// Pools without a declared weight get a weight of 1.
+type WeightedPolicy struct {
+	Weights []int
+}
+
+// Resolve implements dbresolver.Policy
+func (p WeightedPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	total := 0
+	for i := range connPools {
+		total += p.weight(i)
+	}
+	pick := rand.Intn(total)
+	for i, connPool := range connPools {
+		pick -= p.weight(i)
+		if pick < 0 {
+			return connPool
+		}
+	}
+	return connPools[len(connPools)-1]
+}
+
+// Returns the weight of the pool at the given position
+func (p WeightedPolicy) weight(i int) int {
+	if i < len(p.Weights) && p.Weights[i] > 0 {
+		return p.Weights[i]
+	}
+	return 1
+}
+
+// Builds the resolver policy declared in the connection config
+func policyFromConfig(connection map[string]interface{}) (dbresolver.Policy, error) {
+	name := strings.ToLower(configString(connection, "policy", DefaultPolicy))
+	switch name {
+	case "", PolicyRandom:
+		return dbresolver.RandomPolicy{}, nil
+	case PolicyRoundRobin:
+		return dbresolver.StrictRoundRobinPolicy(), nil
+	case PolicyWeighted:
+		weights, err := weightsFromConfig(connection)
+		if err != nil {
+			return nil, err
+		}
+		return WeightedPolicy{Weights: weights}, nil
+	}
+	customPoliciesMu.RLock()
+	defer customPoliciesMu.RUnlock()
+	if policy, ok := customPolicies[name]; ok {
+		return policy, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownPolicy, name)
+}
+
+// Reads the `weights` key of a connection config
+func weightsFromConfig(connection map[string]interface{}) ([]int, error) {
+	switch weights := connection["weights"].(type) {
+	case nil:
+		return nil, nil
+	case []int:
+		for _, weight := range weights {
+			if weight <= 0 {
+				return nil, ErrInvalidWeights
+			}
+		}
+		return weights, nil
+	case []interface{}:
+		result := make([]int, 0, len(weights))
+		for _, weight := range weights {
+			value, ok := weight.(int)
+			if !ok || value <= 0 {
+				return nil, ErrInvalidWeights
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+	return nil, ErrInvalidWeights
+}
+
+// Builds the replica dialectors declared in the connection config.
+// Each replica inherits the keys of its parent connection that it does not override.
+func replicasFromConfig(connection map[string]interface{}) ([]gorm.Dialector, error) {
+	var replicas []map[string]interface{}
+	switch values := connection["replicas"].(type) {
+	case nil:
+		return nil, nil
+	case []map[string]interface{}:
+		replicas = values
+	case []interface{}:
+		for _, value := range values {
+			replica, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, ErrInvalidReplicas
+			}
+			replicas = append(replicas, replica)
+		}
+	default:
+		return nil, ErrInvalidReplicas
+	}
+
+	dialectors := make([]gorm.Dialector, 0, len(replicas))
+	for _, replica := range replicas {
+		dialector, err := dialectorFromConfig(mergeReplicaConfig(connection, replica))
+		if err != nil {
+			return nil, err
+		}
+		dialectors = append(dialectors, dialector)
+	}
+	return dialectors, nil
+}
+
+// Merges a replica config over its parent connection config, skipping the resolver keys
+func mergeReplicaConfig(parent, replica map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(parent)+len(replica))
+	for key, value := range parent {
+		merged[key] = value
+	}
+	for _, key := range resolverKeys {
+		delete(merged, key)
+	}
+	for key, value := range replica {
+		merged[key] = value
+	}
+	return merged
+}
+
+// Builds the dbresolver config for a connection. When withSource is false the connection
+// is already the Gorm default pool and only its replicas are registered.
+func resolverConfigFromConfig(connection map[string]interface{}, withSource, trace bool) (dbresolver.Config, error) {
+	config := dbresolver.Config{TraceResolverMode: trace}
+	if withSource {
+		dialector, err := dialectorFromConfig(connection)
+		if err != nil {
+			return config, err
+		}
+		config.Sources = []gorm.Dialector{dialector}
+	}
+	replicas, err := replicasFromConfig(connection)
+	if err != nil {
+		return config, err
+	}
+	config.Replicas = replicas
+	policy, err := policyFromConfig(connection)
+	if err != nil {
+		return config, err
+	}
+	config.Policy = policy
+	return config, nil
+}
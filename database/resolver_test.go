@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Policy always picking the first pool
+type firstPolicy struct{}
+
+func (firstPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	return connPools[0]
+}
+
+func TestPolicyFromConfig(t *testing.T) {
+	RegisterPolicy("First", firstPolicy{})
+	t.Cleanup(func() {
+		customPoliciesMu.Lock()
+		delete(customPolicies, "first")
+		customPoliciesMu.Unlock()
+	})
+	tests := []struct {
+		name       string
+		connection map[string]interface{}
+		want       dbresolver.Policy // nil when only the type is compared
+		wantType   any
+		wantErr    error
+	}{
+		{name: "default policy", connection: map[string]interface{}{}, wantType: dbresolver.RandomPolicy{}},
+		{name: "random", connection: map[string]interface{}{"policy": "Random"}, wantType: dbresolver.RandomPolicy{}},
+		{name: "round robin", connection: map[string]interface{}{"policy": PolicyRoundRobin}, wantType: dbresolver.StrictRoundRobinPolicy()},
+		{name: "weighted", connection: map[string]interface{}{"policy": PolicyWeighted, "weights": []interface{}{3, 1}}, want: WeightedPolicy{Weights: []int{3, 1}}},
+		{name: "weighted without weights", connection: map[string]interface{}{"policy": PolicyWeighted}, want: WeightedPolicy{}},
+		{name: "registered policy", connection: map[string]interface{}{"policy": "FIRST"}, want: firstPolicy{}},
+		{name: "unknown policy", connection: map[string]interface{}{"policy": "nearest"}, wantErr: ErrUnknownPolicy},
+		{name: "invalid weights", connection: map[string]interface{}{"policy": PolicyWeighted, "weights": []int{1, 0}}, wantErr: ErrInvalidWeights},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policyFromConfig(tt.connection)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("policyFromConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("policyFromConfig() = %#v, want %#v", got, tt.want)
+			}
+			if tt.wantType != nil && reflect.TypeOf(got) != reflect.TypeOf(tt.wantType) {
+				t.Fatalf("policyFromConfig() = %T, want %T", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestWeightsFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights interface{}
+		want    []int
+		wantErr bool
+	}{
+		{name: "missing"},
+		{name: "ints", weights: []int{2, 1}, want: []int{2, 1}},
+		{name: "values of a config file", weights: []interface{}{2, 1}, want: []int{2, 1}},
+		{name: "zero weight", weights: []int{2, 0}, wantErr: true},
+		{name: "negative weight", weights: []interface{}{-1}, wantErr: true},
+		{name: "weight of another type", weights: []interface{}{"2"}, wantErr: true},
+		{name: "not a list", weights: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connection := map[string]interface{}{}
+			if tt.weights != nil {
+				connection["weights"] = tt.weights
+			}
+			got, err := weightsFromConfig(connection)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidWeights)) {
+				t.Fatalf("weightsFromConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("weightsFromConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedPolicyResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		pools   int
+		want    []float64 // Expected share of each pool
+	}{
+		{name: "weights", weights: []int{3, 1}, pools: 2, want: []float64{0.75, 0.25}},
+		{name: "missing weights count as 1", weights: []int{2}, pools: 3, want: []float64{0.5, 0.25, 0.25}},
+		{name: "extra weights are ignored", weights: []int{1, 1, 8}, pools: 2, want: []float64{0.5, 0.5}},
+		{name: "no weights", pools: 2, want: []float64{0.5, 0.5}},
+	}
+	const picks = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools := make([]gorm.ConnPool, tt.pools)
+			index := map[gorm.ConnPool]int{}
+			for i := range pools {
+				pools[i] = openTestPool(t)
+				index[pools[i]] = i
+			}
+			counts := make([]int, tt.pools)
+			policy := WeightedPolicy{Weights: tt.weights}
+			for i := 0; i < picks; i++ {
+				counts[index[policy.Resolve(pools)]]++
+			}
+			for i, count := range counts {
+				if share := float64(count) / picks; math.Abs(share-tt.want[i]) > 0.03 {
+					t.Errorf("pool %d picked %.3f of the time, want %.2f", i, share, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMergeReplicaConfig(t *testing.T) {
+	parent := map[string]interface{}{
+		"driver": DriverPostgres, "host": "primary", "username": "app", "password": "secret", "database": "app",
+		"replicas": []interface{}{}, "policy": PolicyWeighted, "weights": []int{1}, "datas": []interface{}{"orders"},
+	}
+	tests := []struct {
+		name    string
+		replica map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "inherited keys",
+			replica: map[string]interface{}{"host": "replica-1"},
+			want:    map[string]interface{}{"driver": DriverPostgres, "host": "replica-1", "username": "app", "password": "secret", "database": "app"},
+		},
+		{
+			name:    "overridden credentials",
+			replica: map[string]interface{}{"host": "replica-2", "username": "reader", "password": "other"},
+			want:    map[string]interface{}{"driver": DriverPostgres, "host": "replica-2", "username": "reader", "password": "other", "database": "app"},
+		},
+		{
+			name:    "resolver keys of the replica are kept",
+			replica: map[string]interface{}{"policy": PolicyRandom},
+			want:    map[string]interface{}{"driver": DriverPostgres, "host": "primary", "username": "app", "password": "secret", "database": "app", "policy": PolicyRandom},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeReplicaConfig(parent, tt.replica); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mergeReplicaConfig() = %v, want %v", got, tt.want)
+			}
+			if _, ok := parent["replicas"]; !ok || parent["host"] != "primary" {
+				t.Fatal("mergeReplicaConfig() modified the parent config")
+			}
+		})
+	}
+}
+
+func TestReplicasFromConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas interface{}
+		wantDSNs []string
+		wantErr  error
+	}{
+		{name: "no replicas"},
+		{
+			name:     "values of a config file",
+			replicas: []interface{}{map[string]interface{}{"database": "replica_1.db"}, map[string]interface{}{}},
+			wantDSNs: []string{"replica_1.db", "primary.db"},
+		},
+		{
+			name:     "typed list",
+			replicas: []map[string]interface{}{{"database": "replica_1.db"}},
+			wantDSNs: []string{"replica_1.db"},
+		},
+		{name: "replica of another type", replicas: []interface{}{"replica_1.db"}, wantErr: ErrInvalidReplicas},
+		{name: "not a list", replicas: "replica_1.db", wantErr: ErrInvalidReplicas},
+		{name: "unsupported driver", replicas: []interface{}{map[string]interface{}{"driver": "oracle"}}, wantErr: ErrUnsupportedDriver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connection := map[string]interface{}{"driver": DriverSqlite, "database": "primary.db", "replicas": tt.replicas}
+			dialectors, err := replicasFromConfig(connection)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("replicasFromConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			var dsns []string
+			for _, dialector := range dialectors {
+				dsns = append(dsns, dialector.(*sqlite.Dialector).DSN)
+			}
+			if !reflect.DeepEqual(dsns, tt.wantDSNs) {
+				t.Fatalf("replica DSNs = %q, want %q", dsns, tt.wantDSNs)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/configuration"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	ErrRetryExhausted     = errors.New("retry attempts exhausted")             // Triggered when every attempt of a retried operation fails
+	ErrInvalidRetryConfig = errors.New("invalid database.retry configuration") // Triggered when a database.retry key has a wrong type or value
+)
+
+// RetryConfig holds the `database.retry` settings used while booting the connections
+type RetryConfig struct {
+	MaxAttempts  int           // Total attempts, including the first one (1 disables retries)
+	InitialDelay time.Duration // Delay before the second attempt
+	MaxDelay     time.Duration // Upper bound of the exponential backoff
+	Jitter       float64       // Random fraction (0..1) added or removed from every delay
+}
+
+// RetryError is returned when every attempt of a retried operation fails
+type RetryError struct {
+	Operation string // Description of the retried operation
+	Attempts  int    // Number of attempts made
+	Err       error  // Error of the last attempt
+}
+
+// Error implements the error interface
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s: %s after %d attempts: %v", ErrRetryExhausted, e.Operation, e.Attempts, e.Err)
+}
+
+// Unwrap exposes the sentinel and the last attempt error to errors.Is and errors.As
+func (e *RetryError) Unwrap() []error {
+	return []error{ErrRetryExhausted, e.Err}
+}
+
+// Default retry settings: a single attempt, as before retries existed
+var defaultRetryConfig = RetryConfig{
+	MaxAttempts:  1,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// Reads the `database.retry` settings. Delays accept a time.Duration or an int in seconds.
+func retryConfigFromConfig(values map[string]interface{}) (RetryConfig, error) {
+	config := defaultRetryConfig
+	if values == nil {
+		return config, nil
+	}
+	var err error
+	if value, exists := values["max_attempts"]; exists {
+		attempts, ok := value.(int)
+		if !ok || attempts < 1 {
+			return config, fmt.Errorf("%w: max_attempts", ErrInvalidRetryConfig)
+		}
+		config.MaxAttempts = attempts
+	}
+	if config.InitialDelay, err = configuration.Duration(values, "initial_delay", config.InitialDelay); err != nil {
+		return config, fmt.Errorf("%w: initial_delay", ErrInvalidRetryConfig)
+	}
+	if config.MaxDelay, err = configuration.Duration(values, "max_delay", config.MaxDelay); err != nil {
+		return config, fmt.Errorf("%w: max_delay", ErrInvalidRetryConfig)
+	}
+	if value, exists := values["jitter"]; exists {
+		var jitter float64
+		switch typed := value.(type) {
+		case float64:
+			jitter = typed
+		case int:
+			jitter = float64(typed)
+		default:
+			return config, fmt.Errorf("%w: jitter", ErrInvalidRetryConfig)
+		}
+		if jitter < 0 || jitter > 1 {
+			return config, fmt.Errorf("%w: jitter", ErrInvalidRetryConfig)
+		}
+		config.Jitter = jitter
+	}
+	return config, nil
+}
+
+// Delay returns the backoff before the given attempt (starting at 1 for the first retry)
+func (config RetryConfig) Delay(retry int) time.Duration {
+	delay := config.InitialDelay
+	// The backoff is unbounded without MaxDelay, short of overflowing time.Duration with the jitter
+	for i := 1; i < retry && (config.MaxDelay <= 0 || delay < config.MaxDelay) && delay <= math.MaxInt64/4; i++ {
+		delay *= 2
+	}
+	if config.MaxDelay > 0 && delay > config.MaxDelay {
+		delay = config.MaxDelay
+	}
+	if config.Jitter > 0 {
+		delta := float64(delay) * config.Jitter
+		delay += time.Duration(delta*2*rand.Float64() - delta)
+	}
+	return delay
+}
+
+// Run executes the operation until it succeeds or the attempts are exhausted, logging every failed attempt
+func (config RetryConfig) Run(operation string, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == config.MaxAttempts {
+			if config.MaxAttempts > 1 {
+				color.Yellowf("%s: attempt %d/%d failed: %v\n", operation, attempt, config.MaxAttempts, err)
+			}
+			break
+		}
+		delay := config.Delay(attempt)
+		color.Yellowf("%s: attempt %d/%d failed: %v (retrying in %s)\n", operation, attempt, config.MaxAttempts, err, delay)
+		time.Sleep(delay)
+	}
+	if config.MaxAttempts == 1 {
+		return err
+	}
+	return &RetryError{Operation: operation, Attempts: config.MaxAttempts, Err: err}
+}
+
+// Opens the dialector and closes it right away, to check that it is reachable
+func pingDialector(dialector gorm.Dialector) error {
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
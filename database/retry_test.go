@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RetryConfig
+		retry  int
+		want   time.Duration
+	}{
+		{name: "first retry", config: RetryConfig{InitialDelay: time.Second, MaxDelay: time.Minute}, retry: 1, want: time.Second},
+		{name: "doubles", config: RetryConfig{InitialDelay: time.Second, MaxDelay: time.Minute}, retry: 4, want: 8 * time.Second},
+		{name: "bounded", config: RetryConfig{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, retry: 4, want: 5 * time.Second},
+		{name: "unbounded", config: RetryConfig{InitialDelay: time.Second}, retry: 4, want: 8 * time.Second},
+		{name: "unbounded does not overflow", config: RetryConfig{InitialDelay: time.Second}, retry: 100, want: time.Second << 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.Delay(tt.retry); got != tt.want {
+				t.Fatalf("Delay(%d) = %s, want %s", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayDoesNotOverflow(t *testing.T) {
+	config := RetryConfig{InitialDelay: time.Second, Jitter: 1}
+	for retry := 1; retry <= 200; retry++ {
+		if delay := config.Delay(retry); delay < 0 {
+			t.Fatalf("Delay(%d) = %s, want a positive delay", retry, delay)
+		}
+	}
+}
+
+func TestRetryConfigFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    RetryConfig
+		wantErr string // Key reported in the error
+	}{
+		{name: "defaults", want: defaultRetryConfig},
+		{name: "empty settings", values: map[string]interface{}{}, want: defaultRetryConfig},
+		{
+			name:   "every setting",
+			values: map[string]interface{}{"max_attempts": 5, "initial_delay": 2, "max_delay": time.Minute, "jitter": 0.5},
+			want:   RetryConfig{MaxAttempts: 5, InitialDelay: 2 * time.Second, MaxDelay: time.Minute, Jitter: 0.5},
+		},
+		{
+			name:   "integer jitter",
+			values: map[string]interface{}{"jitter": 1},
+			want:   RetryConfig{MaxAttempts: 1, InitialDelay: time.Second, MaxDelay: 30 * time.Second, Jitter: 1},
+		},
+		{name: "no attempt", values: map[string]interface{}{"max_attempts": 0}, wantErr: "max_attempts"},
+		{name: "attempts of another type", values: map[string]interface{}{"max_attempts": "3"}, wantErr: "max_attempts"},
+		{name: "invalid initial delay", values: map[string]interface{}{"initial_delay": "1s"}, wantErr: "initial_delay"},
+		{name: "negative max delay", values: map[string]interface{}{"max_delay": -1}, wantErr: "max_delay"},
+		{name: "jitter over 1", values: map[string]interface{}{"jitter": 1.5}, wantErr: "jitter"},
+		{name: "negative jitter", values: map[string]interface{}{"jitter": -1}, wantErr: "jitter"},
+		{name: "jitter of another type", values: map[string]interface{}{"jitter": "0.1"}, wantErr: "jitter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := retryConfigFromConfig(tt.values)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidRetryConfig) || !strings.HasSuffix(err.Error(), ": "+tt.wantErr) {
+					t.Fatalf("retryConfigFromConfig() error = %v, want %v on %s", err, ErrInvalidRetryConfig, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("retryConfigFromConfig() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryRun(t *testing.T) {
+	failure := errors.New("connection refused")
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		failures     int // Attempts failing before the operation succeeds
+		wantAttempts int
+		wantRetryErr bool // Whether a *RetryError is expected
+		wantErr      bool
+	}{
+		{name: "first attempt", maxAttempts: 3, wantAttempts: 1},
+		{name: "succeeds after retries", maxAttempts: 3, failures: 2, wantAttempts: 3},
+		{name: "exhausted", maxAttempts: 3, failures: 5, wantAttempts: 3, wantRetryErr: true, wantErr: true},
+		{name: "single attempt returns the error as is", maxAttempts: 1, failures: 1, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := RetryConfig{MaxAttempts: tt.maxAttempts, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			attempts := 0
+			err := config.Run("opening connection main", func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return failure
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts {
+				t.Fatalf("Run() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			var retryErr *RetryError
+			if errors.As(err, &retryErr) != tt.wantRetryErr {
+				t.Fatalf("Run() error = %#v, want a *RetryError %v", err, tt.wantRetryErr)
+			}
+			if !tt.wantRetryErr {
+				if err != failure {
+					t.Fatalf("Run() error = %v, want the error of the attempt", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRetryExhausted) || !errors.Is(err, failure) || retryErr.Attempts != tt.maxAttempts || retryErr.Operation != "opening connection main" {
+				t.Fatalf("Run() error = %+v, want the exhausted attempts wrapping %v", retryErr, failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gookit/color"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var (
+	ErrSeederNameRequired = errors.New("seeder name is required")      // Triggered when registering a seeder without name
+	ErrDuplicatedSeeder   = errors.New("seeder already registered")    // Triggered when two seeders share the same name
+	ErrSeederNotFound     = errors.New("seeder not found")             // Triggered when seeding a name that is not registered
+	ErrSeederFailed       = errors.New("seeder failed")                // Triggered when a seeder returns an error
+	ErrDatabaseNotBooted  = errors.New("database has not been booted") // Triggered when using the database before Boot
+
+	// Registered seeders in registration order
+	seeders   []Seeder
+	seedersMu sync.RWMutex
+)
+
+// Seeder fills the database with data. Run receives a transaction on the default connection.
+type Seeder struct {
+	Name string
+	Run  func(tx *gorm.DB) error
+}
+
+// RegisterSeeder adds a seeder to the registry; seeders run in registration order
+func RegisterSeeder(name string, run func(tx *gorm.DB) error) error {
+	if name == "" {
+		return ErrSeederNameRequired
+	}
+	seedersMu.Lock()
+	defer seedersMu.Unlock()
+	for _, seeder := range seeders {
+		if seeder.Name == name {
+			return fmt.Errorf("%w: %s", ErrDuplicatedSeeder, name)
+		}
+	}
+	seeders = append(seeders, Seeder{Name: name, Run: run})
+	return nil
+}
+
+// Seed runs the given seeders in order against database.DB, or every registered seeder when no name is given.
+// Each seeder runs in its own transaction.
+func Seed(names ...string) error {
+	if DB.Ctx == nil {
+		return ErrDatabaseNotBooted
+	}
+	selected, err := selectSeeders(names)
+	if err != nil {
+		return err
+	}
+	db := DB.Ctx.Clauses(dbresolver.Write)
+	for _, seeder := range selected {
+		if err := db.Transaction(seeder.Run); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrSeederFailed, seeder.Name, err)
+		}
+		color.Greenf("Seeded: %s\n", seeder.Name)
+	}
+	return nil
+}
+
+// Returns the seeders to run, keeping the order of the names or the registration order
+func selectSeeders(names []string) ([]Seeder, error) {
+	seedersMu.RLock()
+	defer seedersMu.RUnlock()
+	if len(names) == 0 {
+		return append([]Seeder{}, seeders...), nil
+	}
+	selected := make([]Seeder, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, seeder := range seeders {
+			if seeder.Name == name {
+				selected = append(selected, seeder)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrSeederNotFound, name)
+		}
+	}
+	return selected, nil
+}
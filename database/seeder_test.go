@@ -0,0 +1,119 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Empties the seeder registry for the duration of the test
+func resetSeeders(t *testing.T) {
+	t.Helper()
+	seedersMu.Lock()
+	previous := seeders
+	seeders = nil
+	seedersMu.Unlock()
+	t.Cleanup(func() {
+		seedersMu.Lock()
+		seeders = previous
+		seedersMu.Unlock()
+	})
+}
+
+func TestRegisterSeeder(t *testing.T) {
+	noop := func(*gorm.DB) error { return nil }
+	tests := []struct {
+		name    string
+		names   []string
+		wantErr error
+	}{
+		{name: "valid", names: []string{"users", "posts"}},
+		{name: "missing name", names: []string{""}, wantErr: ErrSeederNameRequired},
+		{name: "duplicated name", names: []string{"users", "users"}, wantErr: ErrDuplicatedSeeder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSeeders(t)
+			var err error
+			for _, name := range tt.names {
+				if err = RegisterSeeder(name, noop); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RegisterSeeder() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	type seeded struct {
+		ID   uint
+		Name string
+	}
+	tests := []struct {
+		name     string
+		names    []string
+		wantRun  []string
+		wantRows int64
+		wantErr  error
+	}{
+		{name: "every seeder in registration order", wantRun: []string{"first", "second"}, wantRows: 2},
+		{name: "selected seeders in the given order", names: []string{"second", "first"}, wantRun: []string{"second", "first"}, wantRows: 2},
+		{name: "unknown seeder", names: []string{"first", "missing"}, wantErr: ErrSeederNotFound},
+		{name: "failed seeder is rolled back", names: []string{"first", "failing"}, wantRun: []string{"first", "failing"}, wantRows: 1, wantErr: ErrSeederFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useTestDB(t)
+			if err := db.AutoMigrate(&seeded{}); err != nil {
+				t.Fatal(err)
+			}
+			resetSeeders(t)
+			var run []string
+			insert := func(name string, fail bool) func(tx *gorm.DB) error {
+				return func(tx *gorm.DB) error {
+					run = append(run, name)
+					if err := tx.Create(&seeded{Name: name}).Error; err != nil {
+						return err
+					}
+					if fail {
+						return errors.New("seeder failed")
+					}
+					return nil
+				}
+			}
+			RegisterSeeder("first", insert("first", false))
+			RegisterSeeder("second", insert("second", false))
+			// Registered after the others, so that it only runs when selected
+			if tt.names != nil {
+				RegisterSeeder("failing", insert("failing", true))
+			}
+
+			err := Seed(tt.names...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Seed() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(run, tt.wantRun) {
+				t.Fatalf("ran %v, want %v", run, tt.wantRun)
+			}
+			var rows int64
+			db.Model(&seeded{}).Count(&rows)
+			if rows != tt.wantRows {
+				t.Fatalf("stored %d rows, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestSeedNotBooted(t *testing.T) {
+	previous := DB
+	DB = Database{}
+	t.Cleanup(func() { DB = previous })
+	if err := Seed(); !errors.Is(err, ErrDatabaseNotBooted) {
+		t.Fatalf("Seed() error = %v, want %v", err, ErrDatabaseNotBooted)
+	}
+}
@@ -0,0 +1,365 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/foundation"
+	"gorm.io/gorm"
+)
+
+const (
+	// TenantKey is the gin.Context key where the resolved tenant name is stored
+	TenantKey = "capyvel.tenant"
+	// TenantConnectionKey is the gin.Context key where the tenant connection is stored
+	TenantConnectionKey = "capyvel.tenant.connection"
+
+	// Idle time after which a lazily opened tenant pool is closed
+	DefaultTenantIdleTimeout = 10 * time.Minute
+)
+
+var (
+	ErrTenantNotResolved = errors.New("tenant could not be resolved from the request") // HTTP 400 Bad Request
+	ErrTenantNotFound    = errors.New("tenant connection not found")                   // HTTP 404 Not Found
+	ErrOpeningTenant     = errors.New("error opening tenant connection")               // HTTP 500 Internal Server Error
+	ErrTenantsClosed     = errors.New("tenant connections are closed")                 // HTTP 500 Internal Server Error
+
+	tenants = &tenantManager{pools: map[string]*tenantPool{}}
+)
+
+// TenantResolver maps a request to a tenant, which is the name of a connection
+type TenantResolver func(ctx *gin.Context) (string, error)
+
+// TenantProvider returns the connection config of a tenant that is not declared in the database config
+type TenantProvider func(tenant string) (map[string]interface{}, error)
+
+// Holds the lazily opened tenant pools
+type tenantManager struct {
+	mu       sync.Mutex
+	resolver TenantResolver
+	provider TenantProvider
+	pools    map[string]*tenantPool
+	janitor  *time.Ticker
+	done     chan struct{}
+	closed   bool // Set by CloseTenants until the database is booted again
+}
+
+// A lazily opened tenant pool, with the requests using it and the last time it was released
+type tenantPool struct {
+	db       *gorm.DB
+	err      error
+	ready    chan struct{} // Closed once the pool is opened, or failed to open
+	active   int
+	lastUsed time.Time
+}
+
+// SetTenantResolver registers the hook that maps each request to a tenant connection
+func SetTenantResolver(resolver TenantResolver) {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	tenants.resolver = resolver
+}
+
+// SetTenantProvider registers the callback used for tenants that are neither tenant connections
+// of database.connections nor entries of database.tenants.connections
+func SetTenantProvider(provider TenantProvider) {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	tenants.provider = provider
+}
+
+// TenantFromHeader resolves the tenant from a request header. Like every resolver, it can only
+// select the tenants of database.tenants.connections, of the TenantProvider and the connections
+// of database.connections declared with `tenant: true`.
+func TenantFromHeader(header string) TenantResolver {
+	return func(ctx *gin.Context) (string, error) {
+		return ctx.GetHeader(header), nil
+	}
+}
+
+// TenantFromSubdomain resolves the tenant from the first label of the host (e.g. acme.example.com)
+func TenantFromSubdomain(baseDomain string) TenantResolver {
+	return func(ctx *gin.Context) (string, error) {
+		host := ctx.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		suffix := "." + strings.TrimPrefix(baseDomain, ".")
+		if !strings.HasSuffix(host, suffix) {
+			return "", nil
+		}
+		subdomain := strings.TrimSuffix(host, suffix)
+		if index := strings.LastIndex(subdomain, "."); index >= 0 {
+			subdomain = subdomain[index+1:]
+		}
+		return subdomain, nil
+	}
+}
+
+// TenantFromClaim resolves the tenant from a claim of the bearer JWT of the Authorization header.
+// The token is only decoded: its signature must be verified by the authentication middleware.
+func TenantFromClaim(claim string) TenantResolver {
+	return func(ctx *gin.Context) (string, error) {
+		token := strings.TrimSpace(strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer "))
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return "", nil
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", err
+		}
+		var claims map[string]interface{}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return "", err
+		}
+		if value, ok := claims[claim]; ok && value != nil {
+			return fmt.Sprint(value), nil
+		}
+		return "", nil
+	}
+}
+
+// ResolveTenant returns the tenant of the request, resolving it once per request
+func ResolveTenant(ctx *gin.Context) (string, error) {
+	if tenant := ctx.GetString(TenantKey); tenant != "" {
+		return tenant, nil
+	}
+	tenants.mu.Lock()
+	resolver := tenants.resolver
+	tenants.mu.Unlock()
+	if resolver == nil {
+		return "", nil
+	}
+	tenant, err := resolver(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTenantNotResolved, err)
+	}
+	if tenant != "" {
+		ctx.Set(TenantKey, tenant)
+	}
+	return tenant, nil
+}
+
+// TenantConnection returns the connection of the request tenant, or nil when no tenant resolver
+// is registered or the request has no tenant. The connection is a resolver connection when the
+// tenant is declared in database.connections with `tenant: true`, and a lazily opened pool
+// otherwise, which is not closed before the end of the request.
+func TenantConnection(ctx *gin.Context) (*gorm.DB, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+	if value, exists := ctx.Get(TenantConnectionKey); exists {
+		if db, ok := value.(*gorm.DB); ok {
+			return db, nil
+		}
+	}
+	tenant, err := ResolveTenant(ctx)
+	if err != nil || tenant == "" {
+		return nil, err
+	}
+	db, release, err := tenants.connection(tenant)
+	if err != nil {
+		return nil, err
+	}
+	// The server cancels the context of the request once it is served
+	if ctx.Request != nil {
+		context.AfterFunc(ctx.Request.Context(), release)
+	} else {
+		release()
+	}
+	ctx.Set(TenantConnectionKey, db)
+	return db, nil
+}
+
+// ContextConnection returns the tenant connection of the request, or the default connection
+func ContextConnection(ctx *gin.Context) (*gorm.DB, error) {
+	db, err := TenantConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return DB.Ctx, nil
+	}
+	return db, nil
+}
+
+// CloseTenants closes every lazily opened tenant pool. The pools still opening are closed as
+// soon as they are opened, and no other pool is opened until the database is booted again.
+func CloseTenants() error {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	tenants.closed = true
+	var errs []error
+	for name, pool := range tenants.pools {
+		delete(tenants.pools, name)
+		if pool.db == nil {
+			continue
+		}
+		if sqlDB, err := pool.db.DB(); err == nil {
+			errs = append(errs, sqlDB.Close())
+		}
+	}
+	if tenants.janitor != nil {
+		tenants.janitor.Stop()
+		close(tenants.done)
+		tenants.janitor = nil
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the connection of a tenant, opening its pool when needed, and the function releasing
+// it. Pools are opened outside of the lock, so that an unreachable tenant only delays its own
+// requests; concurrent requests of a tenant wait for the same pool.
+func (m *tenantManager) connection(tenant string) (*gorm.DB, func(), error) {
+	if DB.Ctx == nil {
+		return nil, nil, ErrDatabaseNotBooted
+	}
+	if DB.IsTenant(tenant) {
+		return DB.Connection(tenant), func() {}, nil
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, nil, ErrTenantsClosed
+	}
+	pool, ok := m.pools[tenant]
+	if !ok {
+		pool = &tenantPool{ready: make(chan struct{})}
+		m.pools[tenant] = pool
+		provider := m.provider
+		go func() {
+			db, err := openTenant(tenant, provider)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			// The pool was dropped by CloseTenants while opening
+			if err == nil && m.pools[tenant] != pool {
+				if sqlDB, err := db.DB(); err == nil {
+					sqlDB.Close()
+				}
+				db, err = nil, ErrTenantsClosed
+			}
+			pool.db, pool.err, pool.lastUsed = db, err, time.Now()
+			if err != nil {
+				if m.pools[tenant] == pool {
+					delete(m.pools, tenant)
+				}
+			} else {
+				m.startJanitor()
+			}
+			close(pool.ready)
+		}()
+	}
+	// Counted before the pool is ready, so that the janitor never closes a pool in use
+	pool.active++
+	m.mu.Unlock()
+
+	<-pool.ready
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			pool.active--
+			pool.lastUsed = time.Now()
+		})
+	}
+	if pool.err != nil {
+		release()
+		return nil, nil, pool.err
+	}
+	return pool.db, release, nil
+}
+
+// Opens the pool of a tenant of database.tenants.connections or of the provider
+func openTenant(tenant string, provider TenantProvider) (*gorm.DB, error) {
+	connection, _ := foundation.App.Config.Get("database.tenants.connections", nil).(map[string]interface{})
+	config, ok := connection[tenant].(map[string]interface{})
+	if !ok && provider != nil {
+		var err error
+		if config, err = provider(tenant); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrTenantNotFound, tenant, err)
+		}
+	}
+	if config == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, tenant)
+	}
+
+	dialector, err := dialectorFromConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrOpeningTenant, tenant, err)
+	}
+	gormConfig := &gorm.Config{
+		Logger:         DB.Ctx.Logger,
+		NamingStrategy: DB.Ctx.NamingStrategy,
+	}
+	if queryLogger, ok := DB.Ctx.Logger.(*QueryLogger); ok {
+		gormConfig.Plugins = queryLogger.plugins()
+	}
+	db, err := gorm.Open(dialector, gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrOpeningTenant, tenant, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrFailedToGetSQLDB, tenant, err)
+	}
+	configurePool(sqlDB)
+	return db, nil
+}
+
+// Allows the tenant pools to be opened again after CloseTenants, once the database is booted
+func (m *tenantManager) open() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = false
+}
+
+// Starts the goroutine that closes the idle tenant pools, if it is not running
+func (m *tenantManager) startJanitor() {
+	if m.janitor != nil {
+		return
+	}
+	values, _ := foundation.App.Config.Get("database.tenants", nil).(map[string]interface{})
+	idleTimeout, err := configuration.Duration(values, "idle_timeout", DefaultTenantIdleTimeout)
+	if err != nil || idleTimeout <= 0 {
+		idleTimeout = DefaultTenantIdleTimeout
+	}
+	m.janitor = time.NewTicker(idleTimeout / 2)
+	m.done = make(chan struct{})
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				m.closeIdle(idleTimeout)
+			case <-done:
+				return
+			}
+		}
+	}(m.janitor, m.done)
+}
+
+// Closes the tenant pools that no request used during the idle timeout
+func (m *tenantManager) closeIdle(idleTimeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, pool := range m.pools {
+		if pool.active > 0 || pool.db == nil || time.Since(pool.lastUsed) < idleTimeout {
+			continue
+		}
+		if sqlDB, err := pool.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		delete(m.pools, name)
+	}
+}
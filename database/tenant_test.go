@@ -0,0 +1,317 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replaces the global tenant manager for the duration of the test
+func useTestTenants(t *testing.T) *tenantManager {
+	t.Helper()
+	previous := tenants
+	tenants = &tenantManager{pools: map[string]*tenantPool{}}
+	t.Cleanup(func() {
+		CloseTenants()
+		tenants = previous
+	})
+	return tenants
+}
+
+// Returns the config of an in-memory SQLite tenant
+func sqliteTenant(name string) map[string]interface{} {
+	return map[string]interface{}{"driver": DriverSqlite, "database": "file:" + name + "?mode=memory&cache=shared"}
+}
+
+func TestTenantResolvers(t *testing.T) {
+	claims := func(payload string) string {
+		return "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	tests := []struct {
+		name     string
+		resolver TenantResolver
+		host     string
+		headers  map[string]string
+		want     string
+		wantErr  bool
+	}{
+		{name: "header", resolver: TenantFromHeader("X-Tenant"), headers: map[string]string{"X-Tenant": "acme"}, want: "acme"},
+		{name: "missing header", resolver: TenantFromHeader("X-Tenant")},
+		{name: "subdomain", resolver: TenantFromSubdomain("example.com"), host: "acme.example.com", want: "acme"},
+		{name: "subdomain with port", resolver: TenantFromSubdomain(".example.com"), host: "acme.example.com:8080", want: "acme"},
+		{name: "nearest subdomain", resolver: TenantFromSubdomain("example.com"), host: "api.acme.example.com", want: "acme"},
+		{name: "other domain", resolver: TenantFromSubdomain("example.com"), host: "acme.example.org"},
+		{name: "base domain", resolver: TenantFromSubdomain("example.com"), host: "example.com"},
+		{name: "string claim", resolver: TenantFromClaim("tenant"), headers: map[string]string{"Authorization": claims(`{"tenant":"acme"}`)}, want: "acme"},
+		{name: "numeric claim", resolver: TenantFromClaim("tenant"), headers: map[string]string{"Authorization": claims(`{"tenant":42}`)}, want: "42"},
+		{name: "missing claim", resolver: TenantFromClaim("tenant"), headers: map[string]string{"Authorization": claims(`{"sub":"1"}`)}},
+		{name: "no token", resolver: TenantFromClaim("tenant")},
+		{name: "invalid payload", resolver: TenantFromClaim("tenant"), headers: map[string]string{"Authorization": "Bearer a.!!.c"}, wantErr: true},
+		{name: "payload is not JSON", resolver: TenantFromClaim("tenant"), headers: map[string]string{"Authorization": claims(`tenant`)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.host != "" {
+				ctx.Request.Host = tt.host
+			}
+			for key, value := range tt.headers {
+				ctx.Request.Header.Set(key, value)
+			}
+			got, err := tt.resolver(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolver error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("resolver = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenant   string
+		declared map[string]interface{} // database.tenants.connections
+		provider TenantProvider
+		wantErr  error
+		wantPool bool // Whether a lazily opened pool is expected
+	}{
+		{name: "no tenant", tenant: ""},
+		{name: "connection declared as tenant", tenant: "shared"},
+		{name: "primary connection", tenant: "main", wantErr: ErrTenantNotFound},
+		{name: "connection not declared as tenant", tenant: "reports", wantErr: ErrTenantNotFound},
+		{name: "tenants config", tenant: "acme", declared: map[string]interface{}{"acme": sqliteTenant("config_acme")}, wantPool: true},
+		{
+			name:   "provider",
+			tenant: "globex",
+			provider: func(tenant string) (map[string]interface{}, error) {
+				return sqliteTenant("provider_" + tenant), nil
+			},
+			wantPool: true,
+		},
+		{
+			name:     "provider error",
+			tenant:   "initech",
+			provider: func(string) (map[string]interface{}, error) { return nil, errors.New("unknown tenant") },
+			wantErr:  ErrTenantNotFound,
+		},
+		{
+			name:     "unsupported driver",
+			tenant:   "umbrella",
+			provider: func(string) (map[string]interface{}, error) { return map[string]interface{}{"driver": "oracle"}, nil },
+			wantErr:  ErrOpeningTenant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			DB.connections = map[string]bool{"shared": true, "reports": true}
+			DB.tenants = map[string]bool{"shared": true, "reports": false}
+			setConfig(t, "database", map[string]any{"tenants": map[string]any{"connections": tt.declared}})
+			useTestTenants(t)
+			SetTenantResolver(TenantFromHeader("X-Tenant"))
+			SetTenantProvider(tt.provider)
+
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx.Request.Header.Set("X-Tenant", tt.tenant)
+			db, err := TenantConnection(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TenantConnection() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if db != nil {
+					t.Fatal("TenantConnection() returned a connection with an error")
+				}
+				return
+			}
+			if (db != nil) != (tt.tenant != "") {
+				t.Fatalf("TenantConnection() = %v for tenant %q", db, tt.tenant)
+			}
+			if _, opened := tenants.pools[tt.tenant]; opened != tt.wantPool {
+				t.Fatalf("pool opened = %v, want %v", opened, tt.wantPool)
+			}
+			if tt.wantPool {
+				if err := db.Exec("SELECT 1").Error; err != nil {
+					t.Fatalf("tenant connection does not work: %v", err)
+				}
+			}
+			// The connection is resolved once per request
+			if again, _ := TenantConnection(ctx); again != db {
+				t.Fatal("TenantConnection() resolved the tenant again")
+			}
+		})
+	}
+}
+
+func TestTenantPoolsOpenOutsideOfTheLock(t *testing.T) {
+	useTestDB(t)
+	m := useTestTenants(t)
+	unblock := make(chan struct{})
+	var opened atomic.Int32
+	SetTenantProvider(func(tenant string) (map[string]interface{}, error) {
+		opened.Add(1)
+		if tenant == "slow" {
+			<-unblock
+		}
+		return sqliteTenant("lock_" + tenant), nil
+	})
+
+	slow := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, release, err := m.connection("slow")
+			if err == nil {
+				release()
+			}
+			slow <- err
+		}()
+	}
+
+	fast := make(chan error, 1)
+	go func() {
+		_, release, err := m.connection("fast")
+		if err == nil {
+			release()
+		}
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow tenant blocks the other tenants")
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-slow; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Concurrent requests of a tenant share its pool
+	if got := opened.Load(); got != 2 {
+		t.Fatalf("provider called %d times, want 2", got)
+	}
+}
+
+func TestCloseIdleTenants(t *testing.T) {
+	tests := []struct {
+		name      string
+		release   bool
+		idleFor   time.Duration
+		wantKept  bool
+		wantWorks bool
+	}{
+		{name: "in use", release: false, idleFor: time.Hour, wantKept: true, wantWorks: true},
+		{name: "recently released", release: true, idleFor: 0, wantKept: true, wantWorks: true},
+		{name: "idle", release: true, idleFor: time.Hour, wantKept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			m := useTestTenants(t)
+			SetTenantProvider(func(tenant string) (map[string]interface{}, error) {
+				return sqliteTenant("idle_" + tenant), nil
+			})
+			db, release, err := m.connection("acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.release {
+				release()
+				// Released twice, e.g. by the request and by a deferred call
+				release()
+			}
+			m.mu.Lock()
+			m.pools["acme"].lastUsed = time.Now().Add(-tt.idleFor)
+			m.mu.Unlock()
+
+			m.closeIdle(time.Minute)
+			m.mu.Lock()
+			_, kept := m.pools["acme"]
+			m.mu.Unlock()
+			if kept != tt.wantKept {
+				t.Fatalf("pool kept = %v, want %v", kept, tt.wantKept)
+			}
+			if works := db.Exec("SELECT 1").Error == nil; works != tt.wantWorks {
+				t.Fatalf("connection works = %v, want %v", works, tt.wantWorks)
+			}
+		})
+	}
+}
+
+func TestTenantPoolReopensAfterFailure(t *testing.T) {
+	useTestDB(t)
+	m := useTestTenants(t)
+	var calls atomic.Int32
+	SetTenantProvider(func(tenant string) (map[string]interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("unreachable")
+		}
+		return sqliteTenant("retry_" + tenant), nil
+	})
+	if _, _, err := m.connection("acme"); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("first connection() error = %v, want %v", err, ErrTenantNotFound)
+	}
+	_, release, err := m.connection("acme")
+	if err != nil {
+		t.Fatalf("second connection() error = %v", err)
+	}
+	release()
+}
+
+func TestCloseTenantsWhileOpening(t *testing.T) {
+	useTestDB(t)
+	m := useTestTenants(t)
+	opening := make(chan struct{})
+	unblock := make(chan struct{})
+	SetTenantProvider(func(tenant string) (map[string]interface{}, error) {
+		if tenant == "slow" {
+			close(opening)
+			<-unblock
+		}
+		return sqliteTenant("closing_" + tenant), nil
+	})
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := m.connection("slow")
+		result <- err
+	}()
+	<-opening
+	if err := CloseTenants(); err != nil {
+		t.Fatal(err)
+	}
+	close(unblock)
+	if err := <-result; !errors.Is(err, ErrTenantsClosed) {
+		t.Fatalf("connection() error = %v, want %v", err, ErrTenantsClosed)
+	}
+	m.mu.Lock()
+	pools, janitor := len(m.pools), m.janitor
+	m.mu.Unlock()
+	if pools != 0 || janitor != nil {
+		t.Fatalf("closed manager kept %d pools and janitor %v", pools, janitor)
+	}
+
+	if _, _, err := m.connection("fast"); !errors.Is(err, ErrTenantsClosed) {
+		t.Fatalf("connection() after CloseTenants error = %v, want %v", err, ErrTenantsClosed)
+	}
+	m.open()
+	_, release, err := m.connection("fast")
+	if err != nil {
+		t.Fatalf("connection() after open error = %v", err)
+	}
+	release()
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TransactionKey is the gin.Context key where the request transaction is stored
+const TransactionKey = "capyvel.transaction"
+
+// SetContextTransaction stores the request transaction in the gin.Context
+func SetContextTransaction(ctx *gin.Context, tx *gorm.DB) {
+	ctx.Set(TransactionKey, tx)
+}
+
+// ContextTransaction returns the request transaction stored in the gin.Context, if any
+func ContextTransaction(ctx *gin.Context) (*gorm.DB, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	value, exists := ctx.Get(TransactionKey)
+	if !exists {
+		return nil, false
+	}
+	tx, ok := value.(*gorm.DB)
+	return tx, ok && tx != nil
+}
+
+// Nested runs fn inside a savepoint of the request transaction,
+// or inside a new transaction on the default connection when the request has none
+func Nested(ctx *gin.Context, fn func(tx *gorm.DB) error) error {
+	if tx, ok := ContextTransaction(ctx); ok {
+		return tx.Transaction(fn)
+	}
+	if DB.Ctx == nil {
+		return ErrDatabaseNotBooted
+	}
+	return DB.Ctx.Transaction(fn)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestNested(t *testing.T) {
+	type row struct {
+		ID   uint
+		Name string
+	}
+	failed := errors.New("failed")
+	tests := []struct {
+		name     string
+		request  bool  // Whether the request has a transaction
+		fnErr    error // Error returned by the nested function
+		wantRows int64
+	}{
+		{name: "new transaction committed", wantRows: 2},
+		{name: "new transaction rolled back", fnErr: failed, wantRows: 1},
+		{name: "savepoint released", request: true, wantRows: 2},
+		{name: "savepoint rolled back", request: true, fnErr: failed, wantRows: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useTestDB(t)
+			if err := db.AutoMigrate(&row{}); err != nil {
+				t.Fatal(err)
+			}
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+			// A first row written outside of the nested function
+			writer := db
+			if tt.request {
+				writer = db.Begin()
+				SetContextTransaction(ctx, writer)
+			}
+			if err := writer.Create(&row{Name: "outer"}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			err := Nested(ctx, func(tx *gorm.DB) error {
+				if err := tx.Create(&row{Name: "nested"}).Error; err != nil {
+					return err
+				}
+				return tt.fnErr
+			})
+			if !errors.Is(err, tt.fnErr) {
+				t.Fatalf("Nested() error = %v, want %v", err, tt.fnErr)
+			}
+			if tt.request {
+				if err := writer.Commit().Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			var rows int64
+			db.Model(&row{}).Count(&rows)
+			if rows != tt.wantRows {
+				t.Fatalf("stored %d rows, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+}
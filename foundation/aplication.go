@@ -8,14 +8,21 @@ var (
 	App Application
 )
 
+// Loads the env file without stopping the process, the Boot functions report its error
 func init() {
 	app := &Application{}
-	app.Config = configuration.NewConfiguration(".env")
+	app.Config, app.err = configuration.NewConfigurationE(".env")
 	App = *app
 }
 
 type Application struct {
 	Config *configuration.Configuration
+	err    error // Error loading the env file
+}
+
+// Err returns the error of loading the env file, or nil when it was loaded
+func (app *Application) Err() error {
+	return app.err
 }
 
 func NewApplication() Application {
@@ -23,16 +23,25 @@ var (
 	ErrInvalidFileExtension = errors.New("file has an invalid extension")     // HTTP 400 Bad Request
 )
 
-// NewBind initializes a new Bind instance with auto fields configuration.
+// NewBind initializes a new Bind instance with auto fields configuration, exiting the process on error.
 func NewBind() *Bind {
+	bind, err := NewBindE()
+	if err != nil {
+		color.Redln(err)
+		os.Exit(1)
+	}
+	return bind
+}
+
+// NewBindE initializes a new Bind instance with auto fields configuration, returning any error to the caller.
+func NewBindE() (*Bind, error) {
 	autoFields, ok := foundation.App.Config.Get("bind.autofields", nil).(map[string]AutoFields)
 	if !ok {
-		color.Redln(ErrAutoFieldsConfig)
-		os.Exit(1)
+		return nil, ErrAutoFieldsConfig
 	}
 	return &Bind{
 		autoFields: autoFields,
-	}
+	}, nil
 }
 
 // Bind is the main structure for data binding.
@@ -9,11 +9,13 @@ import (
 	"image/png"
 	"io"
 	"mime/multipart"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/configuration"
 	providerContract "github.com/nd-tools/capyvel/contracts/providers"
 	"golang.org/x/image/draw"
 )
@@ -28,11 +30,16 @@ type File struct {
 type FileConfig struct {
 	ID                 string          // Unique identifier for the file handler.
 	Path               string          // Path where the files are stored.
-	BaseUrl            string          // Base URL for accessing the files.
+	BaseUrl            string          // Base URL for accessing the files, or their origin when Route is set.
+	Route              string          // Name of the route serving the files, with the ID as its :id parameter.
+	URLResolver        URLResolver     // Generates the path of Route, e.g. router.RouterManager.URL.
 	Folder             string          // Folder where the file is stored.
 	DefaultCompression CompressionFile // Function to handle file compression.
 }
 
+// URLResolver generates the path of a named route from its parameters and query.
+type URLResolver func(name string, params map[string]any, query url.Values) (string, error)
+
 // CompressionFile defines the signature for a function that compresses a file.
 type CompressionFile func(fileReader io.Reader) (io.Reader, string, error)
 
@@ -60,40 +67,49 @@ const (
 
 // NewFile creates a new file handler instance with the provided file provider and configuration.
 // It validates that the configuration fields are properly set (all fields except DefaultCompression are required),
-// and tests the file provider for errors.
+// and tests the file provider for errors, exiting the process on failure.
 func NewFile(fp providerContract.File, config FileConfig) *File {
-	if fp == nil {
-		color.Redf(ErrFileProviderNotDeclared)
+	file, err := NewFileE(fp, config)
+	if err != nil {
+		color.Redln(err)
 		os.Exit(1)
 	}
+	return file
+}
+
+// NewFileE behaves like NewFile but returns the error to the caller.
+// Every configuration problem is reported at once in a *configuration.Errors.
+func NewFileE(fp providerContract.File, config FileConfig) (*File, error) {
+	problems := configuration.NewErrors("file")
+	if fp == nil {
+		problems.Add(errors.New(ErrFileProviderNotDeclared))
+	}
 	config.BaseUrl = strings.ReplaceAll(config.BaseUrl, " ", "")
 	if config.BaseUrl == "" {
-		color.Redf(ErrBaseUrlRequired)
-		os.Exit(1)
+		problems.Add(errors.New(ErrBaseUrlRequired))
 	}
 	config.ID = strings.ReplaceAll(config.ID, " ", "")
 	if config.ID == "" {
-		color.Redf(ErrIDRequired)
-		os.Exit(1)
+		problems.Add(errors.New(ErrIDRequired))
 	}
 	config.Folder = strings.ReplaceAll(config.Folder, " ", "")
 	if config.Folder == "" {
-		color.Redf(ErrFolderRequired)
-		os.Exit(1)
+		problems.Add(errors.New(ErrFolderRequired))
 	}
 	config.Path = strings.ReplaceAll(config.Path, " ", "")
 	if config.Path == "" {
-		color.Redf(ErrPathRequired)
-		os.Exit(1)
+		problems.Add(errors.New(ErrPathRequired))
+	}
+	if err := problems.Err(); err != nil {
+		return nil, err
 	}
 	if err := fp.Test(); err != nil {
-		color.Redf(ErrFileProviderTestFailed, config.ID, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("%w on %s: %v", ErrFileTestFailed, config.ID, err)
 	}
 	return &File{
 		fp:     fp,
 		Config: &config,
-	}
+	}, nil
 }
 
 // ValidateParams checks if the folder and ID match the configuration parameters.
@@ -102,8 +118,16 @@ func (f *File) ValidateParams(id, folder string) bool {
 }
 
 // GenerateUrl generates the URL to access the file with the specified name.
+// When Route and URLResolver are set and the route is registered, its path is generated by the
+// resolver and prefixed with BaseUrl.
 func (f *File) GenerateUrl(fileName string) string {
-	return fmt.Sprintf("%s/%s?folder=%s&fileName=%s", f.Config.BaseUrl, f.Config.ID, f.Config.Folder, fileName)
+	query := url.Values{"folder": {f.Config.Folder}, "fileName": {fileName}}
+	if f.Config.Route != "" && f.Config.URLResolver != nil {
+		if path, err := f.Config.URLResolver(f.Config.Route, map[string]any{"id": f.Config.ID}, query); err == nil {
+			return strings.TrimRight(f.Config.BaseUrl, "/") + path
+		}
+	}
+	return fmt.Sprintf("%s/%s?%s", f.Config.BaseUrl, url.PathEscape(f.Config.ID), query.Encode())
 }
 
 // SaveFile saves the provided file to the configured path, applying compression if necessary.
@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGenerateUrl(t *testing.T) {
+	resolver := func(name string, params map[string]any, query url.Values) (string, error) {
+		if name != "files.show" {
+			return "", errors.New("route not defined")
+		}
+		return fmt.Sprintf("/api/files/%v?%s", params["id"], query.Encode()), nil
+	}
+	tests := []struct {
+		name     string
+		config   FileConfig
+		fileName string
+		want     string
+	}{
+		{
+			name:     "base url",
+			config:   FileConfig{ID: "avatars", BaseUrl: "https://cdn.example.com/files", Folder: "users"},
+			fileName: "a b.png",
+			want:     "https://cdn.example.com/files/avatars?fileName=a+b.png&folder=users",
+		},
+		{
+			name:     "escaped id",
+			config:   FileConfig{ID: "a/b", BaseUrl: "/files", Folder: "users"},
+			fileName: "a.png",
+			want:     "/files/a%2Fb?fileName=a.png&folder=users",
+		},
+		{
+			name:     "named route",
+			config:   FileConfig{ID: "avatars", BaseUrl: "https://api.example.com/", Route: "files.show", URLResolver: resolver, Folder: "users"},
+			fileName: "a.png",
+			want:     "https://api.example.com/api/files/avatars?fileName=a.png&folder=users",
+		},
+		{
+			name:     "undefined route",
+			config:   FileConfig{ID: "avatars", BaseUrl: "/files", Route: "files.missing", URLResolver: resolver, Folder: "users"},
+			fileName: "a.png",
+			want:     "/files/avatars?fileName=a.png&folder=users",
+		},
+		{
+			name:     "route without resolver",
+			config:   FileConfig{ID: "avatars", BaseUrl: "/files", Route: "files.show", Folder: "users"},
+			fileName: "a.png",
+			want:     "/files/avatars?fileName=a.png&folder=users",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &File{Config: &tt.config}
+			if got := f.GenerateUrl(tt.fileName); got != tt.want {
+				t.Fatalf("GenerateUrl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
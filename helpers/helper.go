@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/nd-tools/capyvel/foundation"
 )
 
 // Handler is a global variable for the Helper instance
@@ -22,6 +24,26 @@ func Boot() {
 	}
 }
 
+// BootE initializes the Handler instance with default values, returning any error to the caller
+func BootE() error {
+	if err := foundation.App.Err(); err != nil {
+		return err
+	}
+	orm, err := NewOrmE()
+	if err != nil {
+		return err
+	}
+	bind, err := NewBindE()
+	if err != nil {
+		return err
+	}
+	Handler = &Helper{
+		Orm:  *orm,
+		Bind: *bind,
+	}
+	return nil
+}
+
 // Helper is a struct that aggregates various helper functionalities
 type Helper struct {
 	Orm  Orm
@@ -1,18 +1,24 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nd-tools/capyvel/database"
+	"github.com/nd-tools/capyvel/database/audit"
+	"github.com/nd-tools/capyvel/database/outbox"
 	"github.com/nd-tools/capyvel/helpers/structaudit"
 	"github.com/nd-tools/capyvel/responses"
+	"github.com/nd-tools/capyvel/router"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
 )
 
 // NewOrm initializes a new Orm instance
@@ -23,6 +29,24 @@ func NewOrm() *Orm {
 	}
 }
 
+// NewOrmE initializes a new Orm instance, returning any error to the caller
+func NewOrmE() (*Orm, error) {
+	bind, err := NewBindE()
+	if err != nil {
+		return nil, err
+	}
+	return &Orm{
+		db:   database.DB.Ctx,
+		bind: *bind,
+	}, nil
+}
+
+// Returned to roll back the transaction of a write that failed with a response error
+var errRollback = errors.New("write failed")
+
+// Allowed values of the key parameter when DisableValidationKey skips the validation against the column
+var unvalidatedKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
 // Orm is the main struct for ORM operations
 
 type Orm struct {
@@ -30,6 +54,32 @@ type Orm struct {
 	bind Bind
 }
 
+// connection returns the db declared in the config, the request transaction stored in the
+// gin.Context by the Transaction middleware, the connection of the request tenant or the
+// default connection, in that order
+func (orm *Orm) connection(ctx *gin.Context, db *gorm.DB) (*gorm.DB, *responses.Error) {
+	if db != nil {
+		return db, nil
+	}
+	if tx, ok := database.ContextTransaction(ctx); ok {
+		return tx, nil
+	}
+	tenant, err := database.TenantConnection(ctx)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, database.ErrTenantNotResolved) {
+			code = http.StatusBadRequest
+		} else if errors.Is(err, database.ErrTenantNotFound) {
+			code = http.StatusNotFound
+		}
+		return nil, ErrorResponse(ErrResolvingTenantConnection, err, responses.TypeDB, code)
+	}
+	if tenant != nil {
+		return tenant, nil
+	}
+	return orm.db, nil
+}
+
 // FilterFunc defines a function type for filtering
 
 type FilterFunc func(ctx *gin.Context, db *gorm.DB) (*gorm.DB, error)
@@ -39,15 +89,18 @@ type FilterFunc func(ctx *gin.Context, db *gorm.DB) (*gorm.DB, error)
 
 // ListConfig represents the configuration for listing records.
 type ListConfig struct {
-	Db                *gorm.DB
-	Limit             int
-	DefaultOrderBy    string
-	DefaultOrderDesc  bool
-	ScanObj           bool
-	DisablePagination bool
-	SearchFields      []structaudit.FieldInfo
-	OrderFields       []structaudit.FieldInfo
-	FilterFunctions   []FilterFunc
+	Db                 *gorm.DB
+	Limit              int
+	DefaultOrderBy     string
+	DefaultOrderDesc   bool
+	ScanObj            bool
+	DisablePagination  bool
+	SearchFields       []structaudit.FieldInfo
+	OrderFields        []structaudit.FieldInfo
+	FilterFunctions    []FilterFunc
+	WithTrashed        bool // Include soft-deleted records
+	OnlyTrashed        bool // List only soft-deleted records
+	DisableParentScope bool // List the records of every parent on nested resource routes
 }
 
 // AddConfig represents the configuration for adding records.
@@ -58,6 +111,7 @@ type AddConfig struct {
 	WithAttach  bool
 	DisableBind bool
 	BatchesSize int
+	Event       string // Outbox topic published with the created records, in the same transaction
 }
 
 // UpdateConfig represents the configuration for updating records.
@@ -70,7 +124,9 @@ type UpdateConfig struct {
 	BatchesSize          int
 	WithAttach           bool
 	DisableBind          bool
-	DisableValidationKey bool // no safe
+	DisableValidationKey bool   // no safe
+	VersionColumn        string // Field used for optimistic locking, disabled when empty
+	VersionMode          string // VersionCounter (default) or VersionRowVersion
 }
 
 // DeleteConfig represents the configuration for deleting records.
@@ -82,6 +138,30 @@ type DeleteConfig struct {
 	DisableValidationKey bool // no safe
 }
 
+// RestoreConfig represents the configuration for restoring soft-deleted records.
+type RestoreConfig struct {
+	Db                   *gorm.DB
+	ColumnKey            string
+	KeyParam             string
+	DisableValidationKey bool // no safe
+}
+
+// ForceDeleteConfig represents the configuration for permanently deleting records.
+type ForceDeleteConfig struct {
+	Db                   *gorm.DB
+	ColumnKey            string
+	KeyParam             string
+	DisableValidationKey bool // no safe
+}
+
+// HistoryConfig represents the configuration for reading the audit trail of a record.
+type HistoryConfig struct {
+	Db                   *gorm.DB
+	ColumnKey            string
+	KeyParam             string
+	DisableValidationKey bool // no safe
+}
+
 // GetConfig represents the configuration for retrieving a single record.
 type GetConfig struct {
 	Db                   *gorm.DB
@@ -89,6 +169,7 @@ type GetConfig struct {
 	KeyParam             string
 	DisableRelations     bool
 	DisableValidationKey bool // no safe
+	DisableParentScope   bool // Find the record whatever its parent on nested resource routes
 }
 
 // OrmParams represents common query parameters for various operations.
@@ -103,6 +184,9 @@ type OrmParams struct {
 const (
 	// Default Key {path}/:id
 	DefaultKeyParam = "id"
+	// Optimistic locking modes of UpdateConfig.VersionMode
+	VersionCounter    = "counter"    // Integer column incremented on every update
+	VersionRowVersion = "rowversion" // SQL Server rowversion column maintained by the database
 	// Errors
 	ErrReadingDeclaredModel      = "error reading declared model"
 	ErrCreatingObjectsInDB       = "error creating objects in the database"
@@ -119,6 +203,15 @@ const (
 	ErrCountingTotalRows         = "error counting total rows"
 	ErrScanningRecords           = "error scanning records"
 	ErrScanningModelRecords      = "error scanning model records"
+	ErrResolvingTenantConnection = "error resolving tenant connection"
+	ErrReadingVersionColumn      = "error reading version column"
+	ErrVersionConflict           = "the record was modified by another request"
+	ErrModelWithoutSoftDelete    = "error model has no soft delete column"
+	ErrRestoringObject           = "error restoring soft-deleted object"
+	ErrRecordingAudit            = "error recording audit entry"
+	ErrReadingHistory            = "error reading record history"
+	ErrPublishingEvent           = "error publishing event to the outbox"
+	ErrCommittingTransaction     = "error committing transaction"
 )
 
 // ErrorResponse is a reusable structure for consistent error handling
@@ -135,10 +228,12 @@ func ErrorResponse(message string, err error, errType string, code int) *respons
 
 // Add creates a new record in the database
 func (orm *Orm) Add(ctx *gin.Context, obj any, config AddConfig) (*responses.Api, *responses.Error) {
-	db := config.Db
-	if db == nil {
-		db = orm.db
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
 	}
+	// Route the operation to the source connections of the resolver
+	db := conn.Clauses(dbresolver.Write)
 	if !config.WithAttach {
 		db = db.Omit(clause.Associations)
 	} else {
@@ -154,69 +249,66 @@ func (orm *Orm) Add(ctx *gin.Context, obj any, config AddConfig) (*responses.Api
 			return nil, ErrorResponse(ErrReadingDeclaredModel, err, responses.TypeBind, http.StatusBadRequest)
 		}
 	}
-	if structaudit.GetObjectKind(obj) == reflect.Slice {
-		batches := 20
-		if config.BatchesSize > 0 {
-			batches = config.BatchesSize
+	create := func(db, conn *gorm.DB) *responses.Error {
+		if structaudit.GetObjectKind(obj) == reflect.Slice {
+			batches := 20
+			if config.BatchesSize > 0 {
+				batches = config.BatchesSize
+			}
+			if err := db.WithContext(ctx).CreateInBatches(obj, batches).Error; err != nil {
+				return ErrorResponse(ErrCreatingObjectsInDB, err, responses.TypeDB, http.StatusInternalServerError)
+			}
+		} else {
+			if err := db.WithContext(ctx).Create(obj).Error; err != nil {
+				return ErrorResponse(ErrCreatingObjectInDB, err, responses.TypeDB, http.StatusInternalServerError)
+			}
 		}
-		if err := db.WithContext(ctx).CreateInBatches(obj, batches).Error; err != nil {
-			return nil, ErrorResponse(ErrCreatingObjectsInDB, err, responses.TypeDB, http.StatusInternalServerError)
+		if err := audit.Record(ctx, conn, audit.ActionCreate, nil, obj); err != nil {
+			return ErrorResponse(ErrRecordingAudit, err, responses.TypeDB, http.StatusInternalServerError)
 		}
-	} else {
-		if err := db.WithContext(ctx).Create(obj).Error; err != nil {
-			return nil, ErrorResponse(ErrCreatingObjectInDB, err, responses.TypeDB, http.StatusInternalServerError)
+		return nil
+	}
+	// Write the audit entries and the event to the outbox in the same transaction as the created records
+	errRes = orm.transaction(ctx, db, conn, config.Event != "" || audit.Audited(conn, obj), func(db, conn *gorm.DB) *responses.Error {
+		if errRes := create(db, conn); errRes != nil {
+			return errRes
+		}
+		if config.Event != "" {
+			if err := outbox.Publish(conn, config.Event, obj); err != nil {
+				return ErrorResponse(ErrPublishingEvent, err, responses.TypeDB, http.StatusInternalServerError)
+			}
 		}
+		return nil
+	})
+	if errRes != nil {
+		return nil, errRes
 	}
 	return &responses.Api{Data: obj}, nil
 }
 
 // Get retrieves a record from the database
 func (orm *Orm) Get(ctx *gin.Context, obj any, config GetConfig) (*responses.Api, *responses.Error) {
-	db := config.Db
-	if db == nil {
-		db = orm.db
-	}
-	objType, err := structaudit.NormalizePointerType(obj)
-	if err != nil {
-		return nil, ErrorResponse(ErrNormalizingReceivedObject, err, responses.TypeUnknown, http.StatusInternalServerError)
-	}
-
-	var fieldInfo *structaudit.FieldInfo
-	if config.ColumnKey != "" {
-		f, err := structaudit.FindFieldInfoByName(objType, config.ColumnKey)
-		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
-		}
-		fieldInfo = f
-	} else {
-		f, err := structaudit.FindFieldInfoByTag(objType, "gorm", "primaryKey")
-		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
+	db, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// Route the operation to the replica connections of the resolver
+	db = db.Clauses(dbresolver.Read)
+	if !config.DisableParentScope {
+		db = parentScope(ctx, db)
+	}
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	if err := db.WithContext(ctx).First(obj, keyName+" = ?", value).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) && nestedRequest(ctx) {
+			return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusNotFound)
 		}
-		fieldInfo = f
-	}
-	keyParam := DefaultKeyParam
-	if config.KeyParam != "" {
-		keyParam = config.KeyParam
-	}
-	var value interface{}
-	if !config.DisableValidationKey {
-		if err := structaudit.ValidateFieldData(fieldInfo, ctx.Param(keyParam)); err != nil {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
-		}
-		value = fieldInfo.Value
-	} else {
-		paramValue := ctx.Param(keyParam)
-		validPattern := regexp.MustCompile(`^[a-zA-Z0-9]+$`)
-		if !validPattern.MatchString(paramValue) {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
-		}
-		value = paramValue
-	}
-	if err := db.WithContext(ctx).First(obj, fieldInfo.Name+" = ?", value).Error; err != nil {
 		return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusInternalServerError)
 	}
 
+	objType, _ := structaudit.NormalizePointerType(obj)
 	relations, _ := structaudit.ExtractFieldsByTag(objType, "gorm", "foreignKey")
 	relationsMany, _ := structaudit.ExtractFieldsByTag(objType, "gorm", "many2many")
 	relations = append(relations, relationsMany...)
@@ -226,10 +318,12 @@ func (orm *Orm) Get(ctx *gin.Context, obj any, config GetConfig) (*responses.Api
 
 // Update modifies an existing record in the database
 func (orm *Orm) Update(ctx *gin.Context, obj any, config UpdateConfig) (*responses.Api, *responses.Error) {
-	db := config.Db
-	if db == nil {
-		db = orm.db
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
 	}
+	// Route the operation to the source connections of the resolver
+	db := conn.Clauses(dbresolver.Write)
 	if config.BatchesSize > 0 {
 		db.CreateBatchSize = config.BatchesSize
 	} else {
@@ -240,106 +334,382 @@ func (orm *Orm) Update(ctx *gin.Context, obj any, config UpdateConfig) (*respons
 	} else {
 		db = db.Session(&gorm.Session{FullSaveAssociations: true})
 	}
-	keyParam := DefaultKeyParam
-	if config.KeyParam != "" {
-		keyParam = config.KeyParam
+	db = parentScope(ctx, db)
+	if !config.DisableBind {
+		if err := orm.bind.Json(ctx, ConfigJson{Obj: obj, ObjFormat: config.ObjFormat, Mode: config.BindMode}); err != nil {
+			return nil, ErrorResponse(ErrReadingDeclaredModel, err, responses.TypeBind, http.StatusBadRequest)
+		}
 	}
-	objType, err := structaudit.NormalizePointerType(obj)
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	objType, _ := structaudit.NormalizePointerType(obj)
+	errRes = orm.transaction(ctx, db, conn, audit.Audited(conn, obj), func(db, conn *gorm.DB) *responses.Error {
+		before, errRes := orm.snapshot(ctx, db, objType, keyName, value)
+		if errRes != nil {
+			return errRes
+		}
+		if config.VersionColumn != "" {
+			if _, errRes := orm.updateVersioned(ctx, db, obj, objType, keyName, value, config); errRes != nil {
+				return errRes
+			}
+		} else {
+			result := db.WithContext(ctx).Model(obj).Where(keyName+" = ?", value).UpdateColumns(obj)
+			if result.Error != nil {
+				return ErrorResponse(ErrUpdatingObjectInDB, result.Error, responses.TypeDB, http.StatusInternalServerError)
+			}
+			// No row is affected when the record is missing, or unchanged on some drivers
+			if result.RowsAffected == 0 && nestedRequest(ctx) {
+				if errRes := orm.exists(ctx, db, objType, keyName, value); errRes != nil {
+					return errRes
+				}
+			}
+		}
+		return orm.audit(ctx, conn, db, audit.ActionUpdate, before, objType, keyName, value)
+	})
+	if errRes != nil {
+		return nil, errRes
+	}
+	return &responses.Api{Data: obj}, nil
+}
+
+// updateVersioned updates the record only when its version column still holds the version sent
+// by the client, responding 409 Conflict with the current server version otherwise
+func (orm *Orm) updateVersioned(ctx *gin.Context, db *gorm.DB, obj any, objType reflect.Type, keyName string, keyValue interface{}, config UpdateConfig) (*responses.Api, *responses.Error) {
+	versionField, err := structaudit.FindFieldInfoByName(objType, config.VersionColumn)
 	if err != nil {
-		return nil, ErrorResponse(ErrNormalizingReceivedObject, err, responses.TypeUnknown, http.StatusInternalServerError)
+		return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
 	}
-	var fieldInfo *structaudit.FieldInfo
-	if config.ColumnKey != "" {
-		f, err := structaudit.FindFieldInfoByName(objType, config.ColumnKey)
-		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
+	version := reflect.ValueOf(obj).Elem().FieldByName(versionField.Name)
+	previous := reflect.New(version.Type()).Elem()
+	previous.Set(version)
+	current := previous.Interface()
+
+	switch config.VersionMode {
+	case VersionRowVersion:
+		// The database writes the rowversion, so it is left out of the updated columns
+		version.Set(reflect.Zero(version.Type()))
+	case "", VersionCounter:
+		switch version.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			version.SetInt(version.Int() + 1)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			version.SetUint(version.Uint() + 1)
+		default:
+			err := fmt.Errorf("version column %s must be an integer, got %s", versionField.Name, version.Type())
+			return nil, ErrorResponse(ErrReadingVersionColumn, err, responses.TypeUnknown, http.StatusInternalServerError)
 		}
-		fieldInfo = f
-	} else {
-		f, err := structaudit.FindFieldInfoByTag(objType, "gorm", "primaryKey")
-		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
+	default:
+		err := fmt.Errorf("unknown version mode %q", config.VersionMode)
+		return nil, ErrorResponse(ErrReadingVersionColumn, err, responses.TypeUnknown, http.StatusInternalServerError)
+	}
+
+	result := db.WithContext(ctx).Model(obj).Where(keyName+" = ?", keyValue).Where(versionField.Name+" = ?", current).UpdateColumns(obj)
+	if result.Error != nil {
+		version.Set(previous)
+		return nil, ErrorResponse(ErrUpdatingObjectInDB, result.Error, responses.TypeDB, http.StatusInternalServerError)
+	}
+
+	// Read the version stored in the database: the new rowversion, or the one that won the conflict
+	if result.RowsAffected > 0 && config.VersionMode != VersionRowVersion {
+		return &responses.Api{Data: obj}, nil
+	}
+	latest := reflect.New(objType).Interface()
+	if err := db.WithContext(ctx).Model(latest).Select(versionField.Name).Where(keyName+" = ?", keyValue).Take(latest).Error; err != nil {
+		version.Set(previous)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusNotFound)
 		}
-		fieldInfo = f
+		return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusInternalServerError)
 	}
-	if !config.DisableBind {
-		if err := orm.bind.Json(ctx, ConfigJson{Obj: obj, ObjFormat: config.ObjFormat, Mode: config.BindMode}); err != nil {
-			return nil, ErrorResponse(ErrReadingDeclaredModel, err, responses.TypeBind, http.StatusBadRequest)
+	serverVersion := reflect.ValueOf(latest).Elem().FieldByName(versionField.Name)
+	if result.RowsAffected > 0 {
+		version.Set(serverVersion)
+		return &responses.Api{Data: obj}, nil
+	}
+	version.Set(previous)
+	conflict := ErrorResponse(ErrVersionConflict, nil, responses.TypeDB, http.StatusConflict)
+	conflict.ErrorDetail.Details = ErrVersionConflict
+	conflict.Meta = map[string]interface{}{"version": serverVersion.Interface()}
+	return nil, conflict
+}
+
+// Delete removes a record from the database
+func (orm *Orm) Delete(ctx *gin.Context, obj any, config DeleteConfig) (*responses.Api, *responses.Error) {
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// Route the operation to the source connections of the resolver
+	db := parentScope(ctx, conn.Clauses(dbresolver.Write))
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	objType, _ := structaudit.NormalizePointerType(obj)
+	errRes = orm.transaction(ctx, db, conn, audit.Audited(conn, obj), func(db, conn *gorm.DB) *responses.Error {
+		before, errRes := orm.snapshot(ctx, db, objType, keyName, value)
+		if errRes != nil {
+			return errRes
 		}
+		if config.SoftDelete {
+			result := db.WithContext(ctx).Model(obj).Where(keyName+" = ?", value).Delete(obj)
+			if result.Error != nil {
+				return ErrorResponse(ErrSoftDeletingObject, result.Error, responses.TypeDB, http.StatusInternalServerError)
+			}
+			if result.RowsAffected == 0 && nestedRequest(ctx) {
+				return ErrorResponse(ErrSoftDeletingObject, gorm.ErrRecordNotFound, responses.TypeDB, http.StatusNotFound)
+			}
+			return orm.audit(ctx, conn, db, audit.ActionDelete, before, objType, keyName, value)
+		}
+		result := db.WithContext(ctx).Unscoped().Where(keyName+" = ?", value).Delete(obj)
+		if result.Error != nil {
+			return ErrorResponse(ErrHardDeletingObject, result.Error, responses.TypeDB, http.StatusInternalServerError)
+		}
+		if result.RowsAffected == 0 && nestedRequest(ctx) {
+			return ErrorResponse(ErrHardDeletingObject, gorm.ErrRecordNotFound, responses.TypeDB, http.StatusNotFound)
+		}
+		return orm.audit(ctx, conn, nil, audit.ActionDelete, before, objType, keyName, value)
+	})
+	if errRes != nil {
+		return nil, errRes
 	}
-	var value interface{}
-	if !config.DisableValidationKey {
-		if err := structaudit.ValidateFieldData(fieldInfo, ctx.Param(keyParam)); err != nil {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
+	return &responses.Api{Data: obj}, nil
+}
+
+// Restore clears the soft delete column of a record and returns the restored record
+func (orm *Orm) Restore(ctx *gin.Context, obj any, config RestoreConfig) (*responses.Api, *responses.Error) {
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// Route the operation to the source connections of the resolver
+	db := parentScope(ctx, conn.Clauses(dbresolver.Write))
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	deletedAt, err := deletedAtColumn(db, obj)
+	if err != nil {
+		return nil, ErrorResponse(ErrModelWithoutSoftDelete, err, responses.TypeUnknown, http.StatusInternalServerError)
+	}
+	objType, _ := structaudit.NormalizePointerType(obj)
+	errRes = orm.transaction(ctx, db, conn, audit.Audited(conn, obj), func(db, conn *gorm.DB) *responses.Error {
+		before, errRes := orm.snapshot(ctx, db, objType, keyName, value)
+		if errRes != nil {
+			return errRes
 		}
-		value = fieldInfo.Value
-	} else {
-		paramValue := ctx.Param(keyParam)
-		validPattern := regexp.MustCompile(`^[a-zA-Z0-9]+$`)
-		if !validPattern.MatchString(paramValue) {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
+		result := db.WithContext(ctx).Unscoped().Model(obj).Where(keyName+" = ?", value).Where(clause.Neq{Column: clause.Column{Name: deletedAt}, Value: nil}).UpdateColumn(deletedAt, nil)
+		if result.Error != nil {
+			return ErrorResponse(ErrRestoringObject, result.Error, responses.TypeDB, http.StatusInternalServerError)
+		}
+		if result.RowsAffected == 0 {
+			return ErrorResponse(ErrRestoringObject, gorm.ErrRecordNotFound, responses.TypeDB, http.StatusNotFound)
 		}
-		value = paramValue
+		if err := db.WithContext(ctx).Take(obj, keyName+" = ?", value).Error; err != nil {
+			return ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusInternalServerError)
+		}
+		if before != nil {
+			if err := audit.Record(ctx, conn, audit.ActionRestore, before, obj); err != nil {
+				return ErrorResponse(ErrRecordingAudit, err, responses.TypeDB, http.StatusInternalServerError)
+			}
+		}
+		return nil
+	})
+	if errRes != nil {
+		return nil, errRes
 	}
-	if err := db.WithContext(ctx).Model(obj).Where(fieldInfo.Name+" = ?", value).UpdateColumns(obj).Error; err != nil {
-		return nil, ErrorResponse(ErrUpdatingObjectInDB, err, responses.TypeDB, http.StatusInternalServerError)
+	return &responses.Api{Data: obj}, nil
+}
+
+// ForceDelete permanently removes a record from the database, even when it is soft-deleted
+func (orm *Orm) ForceDelete(ctx *gin.Context, obj any, config ForceDeleteConfig) (*responses.Api, *responses.Error) {
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// Route the operation to the source connections of the resolver
+	db := parentScope(ctx, conn.Clauses(dbresolver.Write))
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	objType, _ := structaudit.NormalizePointerType(obj)
+	errRes = orm.transaction(ctx, db, conn, audit.Audited(conn, obj), func(db, conn *gorm.DB) *responses.Error {
+		before, errRes := orm.snapshot(ctx, db, objType, keyName, value)
+		if errRes != nil {
+			return errRes
+		}
+		result := db.WithContext(ctx).Unscoped().Where(keyName+" = ?", value).Delete(obj)
+		if result.Error != nil {
+			return ErrorResponse(ErrHardDeletingObject, result.Error, responses.TypeDB, http.StatusInternalServerError)
+		}
+		if result.RowsAffected == 0 {
+			return ErrorResponse(ErrHardDeletingObject, gorm.ErrRecordNotFound, responses.TypeDB, http.StatusNotFound)
+		}
+		return orm.audit(ctx, conn, nil, audit.ActionForceDelete, before, objType, keyName, value)
+	})
+	if errRes != nil {
+		return nil, errRes
 	}
 	return &responses.Api{Data: obj}, nil
 }
 
-// Delete removes a record from the database
-func (orm *Orm) Delete(ctx *gin.Context, obj any, config DeleteConfig) (*responses.Api, *responses.Error) {
-	db := config.Db
-	if db == nil {
-		db = orm.db
+// History returns the audit trail of a record, oldest change first
+func (orm *Orm) History(ctx *gin.Context, obj any, config HistoryConfig) (*responses.Api, *responses.Error) {
+	conn, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	keyName, value, errRes := orm.keyValue(ctx, obj, config.ColumnKey, config.KeyParam, config.DisableValidationKey)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// The audit table is keyed by primary key, so other key columns are resolved through the
+	// record, which must also belong to the parent on nested routes
+	if config.ColumnKey != "" || nestedRequest(ctx) {
+		if err := parentScope(ctx, conn.Clauses(dbresolver.Read)).WithContext(ctx).Unscoped().Take(obj, keyName+" = ?", value).Error; err != nil {
+			return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusNotFound)
+		}
+	}
+	table, key, err := audit.Key(conn, obj)
+	if err != nil {
+		return nil, ErrorResponse(ErrReadingHistory, err, responses.TypeUnknown, http.StatusInternalServerError)
+	}
+	if config.ColumnKey == "" {
+		key = fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface())
+	}
+	entries, err := audit.History(ctx, conn.Clauses(dbresolver.Read), table, key)
+	if err != nil {
+		return nil, ErrorResponse(ErrReadingHistory, err, responses.TypeDB, http.StatusInternalServerError)
+	}
+	return &responses.Api{Data: entries, TotalRows: int64(len(entries))}, nil
+}
+
+// snapshot loads the stored state of a record before a write, when the audit trail records its
+// table. It returns nil when the table is not audited or the record does not exist.
+func (orm *Orm) snapshot(ctx *gin.Context, db *gorm.DB, objType reflect.Type, keyName string, keyValue interface{}) (any, *responses.Error) {
+	row := reflect.New(objType).Interface()
+	if !audit.Audited(db, row) {
+		return nil, nil
+	}
+	if err := db.WithContext(ctx).Unscoped().Take(row, keyName+" = ?", keyValue).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusInternalServerError)
 	}
+	return row, nil
+}
+
+// transaction runs a write along with its audit entries and events atomically: in the current
+// transaction of db when there is one, e.g. the request transaction, in a new transaction when
+// atomic, or directly otherwise. fn receives the db of the write and the connection of the
+// audit entries and events.
+func (orm *Orm) transaction(ctx *gin.Context, db, conn *gorm.DB, atomic bool, fn func(db, conn *gorm.DB) *responses.Error) *responses.Error {
+	if committer, ok := db.Statement.ConnPool.(gorm.TxCommitter); !atomic || (ok && committer != nil) {
+		return fn(db, conn)
+	}
+	var errRes *responses.Error
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The audit entries and events are written without the clauses of the write
+		if errRes = fn(tx, tx.Session(&gorm.Session{NewDB: true})); errRes != nil {
+			return errRollback
+		}
+		return nil
+	})
+	if errRes != nil {
+		return errRes
+	}
+	if err != nil {
+		return ErrorResponse(ErrCommittingTransaction, err, responses.TypeDB, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// exists responds 404 Not Found when no record of the query holds the key
+func (orm *Orm) exists(ctx *gin.Context, db *gorm.DB, objType reflect.Type, keyName string, keyValue interface{}) *responses.Error {
+	row := reflect.New(objType).Interface()
+	if err := db.WithContext(ctx).Select(keyName).Take(row, keyName+" = ?", keyValue).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusNotFound)
+		}
+		return ErrorResponse(ErrFetchingObject, err, responses.TypeDB, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// audit records the change of a record between its snapshot and its stored state after the
+// write, read from db; a nil db records a deletion
+func (orm *Orm) audit(ctx *gin.Context, conn, db *gorm.DB, action string, before any, objType reflect.Type, keyName string, keyValue interface{}) *responses.Error {
+	if before == nil {
+		return nil
+	}
+	var after any
+	if db != nil {
+		row, errRes := orm.snapshot(ctx, db, objType, keyName, keyValue)
+		if errRes != nil {
+			return errRes
+		}
+		after = row
+	}
+	if err := audit.Record(ctx, conn, action, before, after); err != nil {
+		return ErrorResponse(ErrRecordingAudit, err, responses.TypeDB, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// keyValue returns the key column of the model (ColumnKey or the primary key) and the
+// value of the key parameter of the request, validated against the type of the column
+func (orm *Orm) keyValue(ctx *gin.Context, obj any, columnKey, keyParam string, disableValidationKey bool) (string, interface{}, *responses.Error) {
 	objType, err := structaudit.NormalizePointerType(obj)
 	if err != nil {
-		return nil, ErrorResponse(ErrNormalizingReceivedObject, err, responses.TypeUnknown, http.StatusInternalServerError)
+		return "", nil, ErrorResponse(ErrNormalizingReceivedObject, err, responses.TypeUnknown, http.StatusInternalServerError)
 	}
 
 	var fieldInfo *structaudit.FieldInfo
-	if config.ColumnKey != "" {
-		f, err := structaudit.FindFieldInfoByName(objType, config.ColumnKey)
+	if columnKey != "" {
+		f, err := structaudit.FindFieldInfoByName(objType, columnKey)
 		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
+			return "", nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
 		}
 		fieldInfo = f
 	} else {
 		f, err := structaudit.FindFieldInfoByTag(objType, "gorm", "primaryKey")
 		if err != nil {
-			return nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
+			return "", nil, ErrorResponse(ErrObtainingObjectInfo, err, responses.TypeUnknown, http.StatusInternalServerError)
 		}
 		fieldInfo = f
 	}
-	keyParam := DefaultKeyParam
-	if config.KeyParam != "" {
-		keyParam = config.KeyParam
-	}
-	var value interface{}
-	if !config.DisableValidationKey {
+	keyParam = routeKeyParam(ctx, keyParam)
+	if !disableValidationKey {
 		if err := structaudit.ValidateFieldData(fieldInfo, ctx.Param(keyParam)); err != nil {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
-		}
-		value = fieldInfo.Value
-	} else {
-		paramValue := ctx.Param(keyParam)
-		validPattern := regexp.MustCompile(`^[a-zA-Z0-9]+$`)
-		if !validPattern.MatchString(paramValue) {
-			return nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
+			return "", nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
 		}
-		value = paramValue
+		return fieldInfo.Name, fieldInfo.Value, nil
 	}
-	if config.SoftDelete {
-		if err := db.WithContext(ctx).Model(obj).Where(fieldInfo.Name+" = ?", value).Delete(obj).Error; err != nil {
-			return nil, ErrorResponse(ErrSoftDeletingObject, err, responses.TypeDB, http.StatusInternalServerError)
-		}
-	} else {
-		if err := db.WithContext(ctx).Unscoped().Where(fieldInfo.Name+" = ?", value).Delete(obj).Error; err != nil {
-			return nil, ErrorResponse(ErrHardDeletingObject, err, responses.TypeDB, http.StatusInternalServerError)
+	paramValue := ctx.Param(keyParam)
+	if !unvalidatedKeyPattern.MatchString(paramValue) {
+		err := fmt.Errorf("invalid value %q for parameter %s", paramValue, keyParam)
+		return "", nil, ErrorResponse(ErrValidatingIDParam, err, responses.TypeBind, http.StatusBadRequest)
+	}
+	return fieldInfo.Name, paramValue, nil
+}
+
+// deletedAtColumn returns the column of the gorm.DeletedAt field of the model
+func deletedAtColumn(db *gorm.DB, obj any) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil {
+		return "", err
+	}
+	deletedAtType := reflect.TypeOf(gorm.DeletedAt{})
+	for _, field := range stmt.Schema.Fields {
+		if field.FieldType == deletedAtType || field.FieldType == reflect.PointerTo(deletedAtType) {
+			return field.DBName, nil
 		}
 	}
-	return &responses.Api{Data: obj}, nil
+	return "", fmt.Errorf("no gorm.DeletedAt field found for type %s", stmt.Schema.Name)
 }
 
 // List retrieves multiple records from the database
@@ -350,9 +720,14 @@ func (orm *Orm) List(ctx *gin.Context, obj any, config ListConfig) (*responses.A
 		return nil, ErrorResponse(ErrParamsQuery, err, responses.TypeBind, http.StatusBadRequest)
 	}
 
-	db := config.Db
-	if db == nil {
-		db = orm.db
+	db, errRes := orm.connection(ctx, config.Db)
+	if errRes != nil {
+		return nil, errRes
+	}
+	// Route the operation to the replica connections of the resolver
+	db = db.Clauses(dbresolver.Read)
+	if !config.DisableParentScope {
+		db = parentScope(ctx, db)
 	}
 	for _, filterFunction := range config.FilterFunctions {
 		db, err = filterFunction(ctx, db)
@@ -361,6 +736,17 @@ func (orm *Orm) List(ctx *gin.Context, obj any, config ListConfig) (*responses.A
 		}
 	}
 
+	if config.WithTrashed || config.OnlyTrashed {
+		db = db.Unscoped()
+	}
+	if config.OnlyTrashed {
+		deletedAt, err := deletedAtColumn(db, obj)
+		if err != nil {
+			return nil, ErrorResponse(ErrModelWithoutSoftDelete, err, responses.TypeUnknown, http.StatusInternalServerError)
+		}
+		db = db.Where(clause.Neq{Column: clause.Column{Name: deletedAt}, Value: nil})
+	}
+
 	if config.SearchFields != nil {
 		db, err = ScopeSearch(db, config.SearchFields, param.Search)
 		if err != nil {
@@ -428,10 +814,9 @@ func (orm *Orm) List(ctx *gin.Context, obj any, config ListConfig) (*responses.A
 			return nil, ErrorResponse(ErrScanningModelRecords, err, responses.TypeDB, http.StatusInternalServerError)
 		}
 	}
-	baseURL := strings.TrimRight(ctx.Request.URL.Path, "/")
-	base := fmt.Sprintf("%s?page=%d&pageSize=%d", baseURL, param.Page, param.PageSize)
-	prev := fmt.Sprintf("%s?page=%d&pageSize=%d", baseURL, param.Page-1, param.PageSize)
-	next := fmt.Sprintf("%s?page=%d&pageSize=%d", baseURL, param.Page+1, param.PageSize)
+	base := pageLink(ctx, param.Page, param.PageSize)
+	prev := pageLink(ctx, param.Page-1, param.PageSize)
+	next := pageLink(ctx, param.Page+1, param.PageSize)
 	meta := map[string]interface{}{
 		"page":     param.Page,
 		"pageSize": param.PageSize,
@@ -443,3 +828,47 @@ func (orm *Orm) List(ctx *gin.Context, obj any, config ListConfig) (*responses.A
 	}
 	return &responses.Api{Data: obj, Meta: meta, Links: links, TotalRows: totalRows}, nil
 }
+
+// Builds the link to a page of the current listing, keeping the other query parameters.
+// Named routes are resolved with the router, other routes reuse the request path.
+func pageLink(ctx *gin.Context, page, pageSize int) string {
+	query := ctx.Request.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("pageSize", strconv.Itoa(pageSize))
+	if name := router.RouterManager.RouteName(ctx); name != "" {
+		if link, err := router.RouterManager.URL(name, router.ContextParams(ctx), query); err == nil {
+			return link
+		}
+	}
+	return strings.TrimRight(ctx.Request.URL.Path, "/") + "?" + query.Encode()
+}
+
+// Returns the path parameter of the key: the configured one, the one of the matched
+// resource route, or DefaultKeyParam
+func routeKeyParam(ctx *gin.Context, keyParam string) string {
+	if keyParam != "" {
+		return keyParam
+	}
+	if keyParam = router.ContextKeyParam(ctx); keyParam != "" {
+		return keyParam
+	}
+	return DefaultKeyParam
+}
+
+// Reports whether the request is on a nested resource route, where a record that is missing or
+// belongs to another parent is reported with 404 Not Found
+func nestedRequest(ctx *gin.Context) bool {
+	_, nested := router.ContextParent(ctx)
+	return nested
+}
+
+// Scopes the query of a nested resource by the foreign key of its parent, see router.RouteOptions.Parent.
+// Every query on the key of a record of a nested route goes through it, so that the records of
+// other parents are not found.
+func parentScope(ctx *gin.Context, db *gorm.DB) *gorm.DB {
+	parent, ok := router.ContextParent(ctx)
+	if !ok || parent.Column == "" {
+		return db
+	}
+	return db.Where(clause.Eq{Column: clause.Column{Name: parent.Column}, Value: parent.Value})
+}
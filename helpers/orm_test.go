@@ -0,0 +1,615 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database/audit"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"github.com/nd-tools/capyvel/responses"
+	"github.com/nd-tools/capyvel/router"
+	"gorm.io/gorm"
+)
+
+type item struct {
+	ID        string `gorm:"primaryKey"`
+	OrderID   string
+	Name      string
+	Version   int
+	DeletedAt gorm.DeletedAt
+}
+
+// Model whose version column is not an integer
+type label struct {
+	ID      string `gorm:"primaryKey"`
+	Name    string
+	Version string
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// Returns an Orm on an in-memory database holding the items
+func newTestOrm(t *testing.T, items ...item) (*Orm, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t, &item{}, &label{})
+	if len(items) > 0 {
+		if err := db.Create(&items).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Orm{db: db}, db
+}
+
+// Returns the context of a request on the record with the key
+func newTestContext(method, target, id string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(method, target, nil)
+	ctx.Params = gin.Params{{Key: DefaultKeyParam, Value: id}}
+	return ctx
+}
+
+func TestUpdateVersioned(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		version     int
+		mode        string
+		wantCode    int // 0 when the update succeeds
+		wantVersion int // Version of the record after the request
+		wantMeta    int // Server version reported with a conflict
+	}{
+		{name: "current version", id: "1", version: 3, wantVersion: 4},
+		{name: "explicit counter mode", id: "1", version: 3, mode: VersionCounter, wantVersion: 4},
+		{name: "stale version", id: "1", version: 2, wantCode: http.StatusConflict, wantVersion: 3, wantMeta: 3},
+		{name: "newer version", id: "1", version: 4, wantCode: http.StatusConflict, wantVersion: 3, wantMeta: 3},
+		{name: "missing record", id: "2", version: 3, wantCode: http.StatusNotFound, wantVersion: 3},
+		{name: "rowversion reads the stored version", id: "1", version: 3, mode: VersionRowVersion, wantVersion: 3},
+		{name: "unknown mode", id: "1", version: 3, mode: "timestamp", wantCode: http.StatusInternalServerError, wantVersion: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTestOrm(t, item{ID: "1", Name: "old", Version: 3})
+			obj := &item{Name: "new", Version: tt.version}
+			_, errRes := orm.Update(newTestContext(http.MethodPut, "/items/"+tt.id, tt.id), obj, UpdateConfig{DisableBind: true, VersionColumn: "Version", VersionMode: tt.mode})
+
+			var stored item
+			if err := db.Take(&stored, "id = ?", "1").Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.Version != tt.wantVersion {
+				t.Errorf("stored version = %d, want %d", stored.Version, tt.wantVersion)
+			}
+			if tt.wantCode == 0 {
+				if errRes != nil {
+					t.Fatalf("Update() error = %+v", errRes)
+				}
+				if stored.Name != "new" || obj.Version != tt.wantVersion {
+					t.Fatalf("stored %+v, returned version %d, want the new name and version %d", stored, obj.Version, tt.wantVersion)
+				}
+				return
+			}
+			if errRes == nil || errRes.Code != tt.wantCode {
+				t.Fatalf("Update() error = %+v, want status %d", errRes, tt.wantCode)
+			}
+			if stored.Name != "old" || obj.Version != tt.version {
+				t.Errorf("stored %+v, returned version %d, want the record and the sent version unchanged", stored, obj.Version)
+			}
+			if meta, _ := errRes.Meta.(map[string]interface{}); tt.wantCode == http.StatusConflict && meta["version"] != tt.wantMeta {
+				t.Errorf("conflict meta = %v, want version %d", errRes.Meta, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestUpdateVersionedNotInteger(t *testing.T) {
+	orm, db := newTestOrm(t)
+	if err := db.Create(&label{ID: "1", Name: "old", Version: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, errRes := orm.Update(newTestContext(http.MethodPut, "/labels/1", "1"), &label{Name: "new", Version: "a"}, UpdateConfig{DisableBind: true, VersionColumn: "Version"})
+	if errRes == nil || errRes.Code != http.StatusInternalServerError || errRes.ErrorDetail.Message != ErrReadingVersionColumn {
+		t.Fatalf("Update() error = %+v, want %q", errRes, ErrReadingVersionColumn)
+	}
+}
+
+// Returns an Orm holding the live item 1 and the soft-deleted item 2
+func newTrashedTestOrm(t *testing.T) (*Orm, *gorm.DB) {
+	t.Helper()
+	orm, db := newTestOrm(t, item{ID: "1", Name: "live"}, item{ID: "2", Name: "trashed"})
+	if err := db.Delete(&item{ID: "2"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return orm, db
+}
+
+// Write of a record by key, ignoring its response
+type write func(orm *Orm, ctx *gin.Context, obj any) *responses.Error
+
+func softDelete(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Delete(ctx, obj, DeleteConfig{SoftDelete: true})
+	return errRes
+}
+
+func restore(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Restore(ctx, obj, RestoreConfig{})
+	return errRes
+}
+
+func forceDelete(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.ForceDelete(ctx, obj, ForceDeleteConfig{})
+	return errRes
+}
+
+func TestSoftDeleteRestoreAndForceDelete(t *testing.T) {
+	const (
+		live    = "live"
+		trashed = "trashed"
+		gone    = "gone"
+	)
+	tests := []struct {
+		name      string
+		id        string
+		write     write
+		wantCode  int    // 0 when the write succeeds
+		wantState string // State of the record after the request
+	}{
+		{
+			name:      "soft delete",
+			id:        "1",
+			write:     softDelete,
+			wantState: trashed,
+		},
+		{
+			name:      "soft delete a trashed record",
+			id:        "2",
+			write:     softDelete,
+			wantState: trashed,
+		},
+		{
+			name:      "restore",
+			id:        "2",
+			write:     restore,
+			wantState: live,
+		},
+		{
+			name:      "restore a live record",
+			id:        "1",
+			write:     restore,
+			wantCode:  http.StatusNotFound,
+			wantState: live,
+		},
+		{
+			name:      "restore a missing record",
+			id:        "3",
+			write:     restore,
+			wantCode:  http.StatusNotFound,
+			wantState: gone,
+		},
+		{
+			name:      "force delete a live record",
+			id:        "1",
+			write:     forceDelete,
+			wantState: gone,
+		},
+		{
+			name:      "force delete a trashed record",
+			id:        "2",
+			write:     forceDelete,
+			wantState: gone,
+		},
+		{
+			name:      "force delete a missing record",
+			id:        "3",
+			write:     forceDelete,
+			wantCode:  http.StatusNotFound,
+			wantState: gone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTrashedTestOrm(t)
+			obj := &item{}
+			errRes := tt.write(orm, newTestContext(http.MethodPost, "/items/"+tt.id, tt.id), obj)
+			if tt.wantCode == 0 && errRes != nil {
+				t.Fatalf("write error = %+v", errRes)
+			}
+			if tt.wantCode != 0 && (errRes == nil || errRes.Code != tt.wantCode) {
+				t.Fatalf("write error = %+v, want status %d", errRes, tt.wantCode)
+			}
+
+			var stored item
+			state := gone
+			if err := db.Unscoped().Take(&stored, "id = ?", tt.id).Error; err == nil {
+				state = live
+				if stored.DeletedAt.Valid {
+					state = trashed
+				}
+			}
+			if state != tt.wantState {
+				t.Fatalf("record is %s, want %s", state, tt.wantState)
+			}
+			if tt.name == "restore" && obj.Name != "trashed" {
+				t.Fatalf("Restore() returned %+v, want the restored record", obj)
+			}
+		})
+	}
+}
+
+func TestRestoreWithoutSoftDelete(t *testing.T) {
+	orm, _ := newTestOrm(t)
+	_, errRes := orm.Restore(newTestContext(http.MethodPost, "/labels/1/restore", "1"), &label{}, RestoreConfig{})
+	if errRes == nil || errRes.ErrorDetail.Message != ErrModelWithoutSoftDelete {
+		t.Fatalf("Restore() error = %+v, want %q", errRes, ErrModelWithoutSoftDelete)
+	}
+}
+
+func TestListTrashed(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ListConfig
+		obj       any
+		wantNames []string
+		wantErr   string
+	}{
+		{name: "live records", obj: &[]item{}, wantNames: []string{"live"}},
+		{name: "with trashed", config: ListConfig{WithTrashed: true}, obj: &[]item{}, wantNames: []string{"live", "trashed"}},
+		{name: "only trashed", config: ListConfig{OnlyTrashed: true}, obj: &[]item{}, wantNames: []string{"trashed"}},
+		{name: "only trashed wins over with trashed", config: ListConfig{WithTrashed: true, OnlyTrashed: true}, obj: &[]item{}, wantNames: []string{"trashed"}},
+		{name: "only trashed without soft delete", config: ListConfig{OnlyTrashed: true}, obj: &[]label{}, wantErr: ErrModelWithoutSoftDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, _ := newTrashedTestOrm(t)
+			tt.config.DefaultOrderBy = "id"
+			res, errRes := orm.List(newTestContext(http.MethodGet, "/items", ""), tt.obj, tt.config)
+			if tt.wantErr != "" {
+				if errRes == nil || errRes.ErrorDetail.Message != tt.wantErr {
+					t.Fatalf("List() error = %+v, want %q", errRes, tt.wantErr)
+				}
+				return
+			}
+			if errRes != nil {
+				t.Fatalf("List() error = %+v", errRes)
+			}
+			var names []string
+			for _, record := range *tt.obj.(*[]item) {
+				names = append(names, record.Name)
+			}
+			if !slices.Equal(names, tt.wantNames) || res.TotalRows != int64(len(tt.wantNames)) {
+				t.Fatalf("List() = %v (%d rows), want %v", names, res.TotalRows, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		columnKey string
+		keyParam  string
+		params    gin.Params
+		disable   bool
+		wantKey   string
+		wantValue any
+		wantCode  int
+	}{
+		{name: "primary key", params: gin.Params{{Key: "id", Value: "7"}}, wantKey: "ID", wantValue: "7"},
+		{name: "column key", columnKey: "name", params: gin.Params{{Key: "id", Value: "box"}}, wantKey: "Name", wantValue: "box"},
+		{name: "key parameter", keyParam: "itemId", params: gin.Params{{Key: "itemId", Value: "7"}}, wantKey: "ID", wantValue: "7"},
+		{name: "unknown column", columnKey: "missing", params: gin.Params{{Key: "id", Value: "7"}}, wantCode: http.StatusInternalServerError},
+		{name: "unvalidated key", disable: true, params: gin.Params{{Key: "id", Value: "7"}}, wantKey: "ID", wantValue: "7"},
+		{name: "unvalidated key with symbols", disable: true, params: gin.Params{{Key: "id", Value: "7' OR '1'='1"}}, wantCode: http.StatusBadRequest},
+		{name: "unvalidated empty key", disable: true, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestContext(http.MethodGet, "/items", "")
+			ctx.Params = tt.params
+			keyName, value, errRes := (&Orm{}).keyValue(ctx, &item{}, tt.columnKey, tt.keyParam, tt.disable)
+			if tt.wantCode != 0 {
+				if errRes == nil || errRes.Code != tt.wantCode {
+					t.Fatalf("keyValue() error = %+v, want status %d", errRes, tt.wantCode)
+				}
+				return
+			}
+			if errRes != nil {
+				t.Fatalf("keyValue() error = %+v", errRes)
+			}
+			if value := reflect.Indirect(reflect.ValueOf(value)).Interface(); keyName != tt.wantKey || value != tt.wantValue {
+				t.Fatalf("keyValue() = %s %v, want %s %v", keyName, value, tt.wantKey, tt.wantValue)
+			}
+		})
+	}
+}
+
+// Enables the audit trail on db for the duration of the test
+func useTestAudit(t *testing.T, db *gorm.DB, config audit.Config) {
+	t.Helper()
+	if err := audit.Migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	audit.Enable(config)
+	t.Cleanup(audit.Disable)
+}
+
+func add(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Add(ctx, obj, AddConfig{DisableBind: true})
+	return errRes
+}
+
+func update(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Update(ctx, obj, UpdateConfig{DisableBind: true})
+	return errRes
+}
+
+func hardDelete(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Delete(ctx, obj, DeleteConfig{})
+	return errRes
+}
+
+func TestAuditedWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		obj         *item
+		write       write
+		wantAction  string
+		wantChanges map[string]audit.Change
+	}{
+		{name: "add", id: "3", obj: &item{ID: "3", Name: "new"}, write: add, wantAction: audit.ActionCreate, wantChanges: map[string]audit.Change{"name": {New: "new"}}},
+		{name: "update", id: "1", obj: &item{Name: "renamed"}, write: update, wantAction: audit.ActionUpdate, wantChanges: map[string]audit.Change{"name": {Old: "live", New: "renamed"}}},
+		{name: "delete", id: "1", obj: &item{}, write: hardDelete, wantAction: audit.ActionDelete, wantChanges: map[string]audit.Change{"name": {Old: "live"}}},
+		{name: "restore", id: "2", obj: &item{}, write: restore, wantAction: audit.ActionRestore},
+		{name: "force delete", id: "2", obj: &item{}, write: forceDelete, wantAction: audit.ActionForceDelete, wantChanges: map[string]audit.Change{"name": {Old: "trashed"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTrashedTestOrm(t)
+			useTestAudit(t, db, audit.Config{Ignore: []string{"id", "order_id", "version"}})
+			if errRes := tt.write(orm, newTestContext(http.MethodPost, "/items/"+tt.id, tt.id), tt.obj); errRes != nil {
+				t.Fatalf("write error = %+v", errRes)
+			}
+
+			res, errRes := orm.History(newTestContext(http.MethodGet, "/items/"+tt.id+"/history", tt.id), &item{}, HistoryConfig{})
+			if errRes != nil {
+				t.Fatalf("History() error = %+v", errRes)
+			}
+			entries := res.Data.([]audit.Entry)
+			if len(entries) != 1 || entries[0].Action != tt.wantAction {
+				t.Fatalf("History() = %+v, want one %s entry", entries, tt.wantAction)
+			}
+			changes, err := entries[0].Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantChanges != nil {
+				if name := changes["name"]; name != tt.wantChanges["name"] {
+					t.Fatalf("name change = %+v, want %+v", name, tt.wantChanges["name"])
+				}
+			} else if _, ok := changes["deleted_at"]; !ok || len(changes) != 1 {
+				t.Fatalf("changes = %+v, want only deleted_at", changes)
+			}
+		})
+	}
+}
+
+func TestAuditFailureRollsBackTheWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		obj   *item
+		write write
+	}{
+		{name: "add", id: "3", obj: &item{ID: "3", Name: "new"}, write: add},
+		{name: "update", id: "1", obj: &item{Name: "renamed"}, write: update},
+		{name: "soft delete", id: "1", obj: &item{}, write: softDelete},
+		{name: "force delete", id: "2", obj: &item{}, write: forceDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTrashedTestOrm(t)
+			// The audit table is missing, so the audit entry cannot be written
+			audit.Enable(audit.Config{})
+			t.Cleanup(audit.Disable)
+
+			errRes := tt.write(orm, newTestContext(http.MethodPost, "/items/"+tt.id, tt.id), tt.obj)
+			if errRes == nil || errRes.ErrorDetail.Message != ErrRecordingAudit {
+				t.Fatalf("write error = %+v, want %q", errRes, ErrRecordingAudit)
+			}
+			var names []string
+			if err := db.Unscoped().Model(&item{}).Order("id").Pluck("name", &names).Error; err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"live", "trashed"}; !slices.Equal(names, want) {
+				t.Fatalf("records = %v, want %v", names, want)
+			}
+			var live int64
+			if err := db.Model(&item{}).Where("id = ?", "1").Count(&live).Error; err != nil || live != 1 {
+				t.Fatalf("record 1 is no longer live: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnauditedTablesAreNotSnapshotted(t *testing.T) {
+	tests := []struct {
+		name        string
+		tables      []string
+		wantQueries int // SELECT queries run by the update
+		wantEntries int64
+	}{
+		{name: "audited table", wantQueries: 2, wantEntries: 1},
+		{name: "other tables audited", tables: []string{"labels"}, wantQueries: 0, wantEntries: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTestOrm(t, item{ID: "1", Name: "old"})
+			useTestAudit(t, db, audit.Config{Tables: tt.tables})
+			queries := 0
+			if err := db.Callback().Query().Before("gorm:query").Register("test:count", func(*gorm.DB) { queries++ }); err != nil {
+				t.Fatal(err)
+			}
+			if errRes := update(orm, newTestContext(http.MethodPut, "/items/1", "1"), &item{Name: "new"}); errRes != nil {
+				t.Fatalf("Update() error = %+v", errRes)
+			}
+			if queries != tt.wantQueries {
+				t.Errorf("Update() ran %d queries, want %d", queries, tt.wantQueries)
+			}
+			var entries int64
+			if err := db.Model(&audit.Entry{}).Count(&entries).Error; err != nil || entries != tt.wantEntries {
+				t.Fatalf("audit entries = %d (%v), want %d", entries, err, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestPageLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		page   int
+		want   string
+	}{
+		{name: "first page", target: "/api/items", page: 0, want: "/api/items?page=0&pageSize=10"},
+		{name: "keeps the query", target: "/api/items/?search=a%26b&page=4", page: 1, want: "/api/items?page=1&pageSize=10&search=a%26b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageLink(newTestContext(http.MethodGet, tt.target, ""), tt.page, 10); got != tt.want {
+				t.Fatalf("pageLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func get(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Get(ctx, obj, GetConfig{})
+	return errRes
+}
+
+func getAnyParent(orm *Orm, ctx *gin.Context, obj any) *responses.Error {
+	_, errRes := orm.Get(ctx, obj, GetConfig{DisableParentScope: true})
+	return errRes
+}
+
+func TestParentScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		write    write
+		wantCode int // 0 when the request succeeds
+	}{
+		{name: "get", id: "1", write: get},
+		{name: "get the record of another parent", id: "2", write: get, wantCode: http.StatusNotFound},
+		{name: "get without the parent scope", id: "2", write: getAnyParent},
+		{name: "update", id: "1", write: update},
+		{name: "update the record of another parent", id: "2", write: update, wantCode: http.StatusNotFound},
+		{name: "soft delete the record of another parent", id: "2", write: softDelete, wantCode: http.StatusNotFound},
+		{name: "hard delete the record of another parent", id: "2", write: hardDelete, wantCode: http.StatusNotFound},
+		{name: "restore the record of another parent", id: "3", write: restore, wantCode: http.StatusNotFound},
+		{name: "force delete the record of another parent", id: "3", write: forceDelete, wantCode: http.StatusNotFound},
+		{name: "force delete", id: "1", write: forceDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, db := newTestOrm(t,
+				item{ID: "1", OrderID: "a", Name: "mine"},
+				item{ID: "2", OrderID: "b", Name: "other"},
+				item{ID: "3", OrderID: "b", Name: "trashed"},
+			)
+			if err := db.Delete(&item{ID: "3"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			ctx := newTestContext(http.MethodPut, "/orders/a/items/"+tt.id, tt.id)
+			ctx.Set(router.ParentKey, router.Parent{Param: "orderId", Column: "order_id", Value: "a"})
+
+			errRes := tt.write(orm, ctx, &item{Name: "changed"})
+			if tt.wantCode == 0 && errRes != nil {
+				t.Fatalf("error = %+v", errRes)
+			}
+			if tt.wantCode != 0 && (errRes == nil || errRes.Code != tt.wantCode) {
+				t.Fatalf("error = %+v, want status %d", errRes, tt.wantCode)
+			}
+			// The records of the other parent are untouched
+			var others []item
+			if err := db.Unscoped().Order("id").Find(&others, "order_id = ?", "b").Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(others) != 2 || others[0].Name != "other" || others[0].DeletedAt.Valid || !others[1].DeletedAt.Valid {
+				t.Fatalf("records of the other parent = %+v", others)
+			}
+		})
+	}
+}
+
+func TestMissingRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    write
+		nested   bool
+		wantCode int // 0 when the request succeeds
+	}{
+		{name: "get", write: get, wantCode: http.StatusInternalServerError},
+		{name: "update", write: update},
+		{name: "soft delete", write: softDelete},
+		{name: "hard delete", write: hardDelete},
+		{name: "nested get", write: get, nested: true, wantCode: http.StatusNotFound},
+		{name: "nested update", write: update, nested: true, wantCode: http.StatusNotFound},
+		{name: "nested soft delete", write: softDelete, nested: true, wantCode: http.StatusNotFound},
+		{name: "nested hard delete", write: hardDelete, nested: true, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, _ := newTestOrm(t, item{ID: "1", OrderID: "a", Name: "mine"})
+			ctx := newTestContext(http.MethodPut, "/items/9", "9")
+			if tt.nested {
+				ctx.Set(router.ParentKey, router.Parent{Param: "orderId", Column: "order_id", Value: "a"})
+			}
+			errRes := tt.write(orm, ctx, &item{Name: "changed"})
+			if tt.wantCode == 0 && errRes != nil {
+				t.Fatalf("error = %+v", errRes)
+			}
+			if tt.wantCode != 0 && (errRes == nil || errRes.Code != tt.wantCode) {
+				t.Fatalf("error = %+v, want status %d", errRes, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestListParentScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ListConfig
+		wantNames []string
+	}{
+		{name: "records of the parent", wantNames: []string{"mine"}},
+		{name: "without the parent scope", config: ListConfig{DisableParentScope: true}, wantNames: []string{"mine", "other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm, _ := newTestOrm(t, item{ID: "1", OrderID: "a", Name: "mine"}, item{ID: "2", OrderID: "b", Name: "other"})
+			ctx := newTestContext(http.MethodGet, "/orders/a/items", "")
+			ctx.Set(router.ParentKey, router.Parent{Param: "orderId", Column: "order_id", Value: "a"})
+			tt.config.DefaultOrderBy = "id"
+			var items []item
+			if _, errRes := orm.List(ctx, &items, tt.config); errRes != nil {
+				t.Fatalf("List() error = %+v", errRes)
+			}
+			var names []string
+			for _, record := range items {
+				names = append(names, record.Name)
+			}
+			if !slices.Equal(names, tt.wantNames) {
+				t.Fatalf("List() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/helpers/uuid"
+)
+
+const (
+	// Header is the header carrying the request ID
+	Header = "X-Request-ID"
+	// Key is the context key of the request ID
+	Key = "capyvel.request.id"
+)
+
+// Key of the request ID in the context of the request
+type contextKey struct{}
+
+// New generates a request ID
+func New() string {
+	return uuid.New().String()
+}
+
+// Set stores the request ID in the gin.Context and in the context of its request, so that it
+// reaches the code that only receives ctx.Request.Context()
+func Set(ctx *gin.Context, id string) {
+	ctx.Set(Key, id)
+	ctx.Request = ctx.Request.WithContext(WithContext(ctx.Request.Context(), id))
+}
+
+// WithContext returns a copy of the context carrying the request ID, e.g. for the background
+// work started by a request
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of a gin.Context or of a context derived from its request,
+// or "" when there is none
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	// gin.Context exposes the values stored with Set through Value
+	id, _ := ctx.Value(Key).(string)
+	return id
+}
@@ -0,0 +1,65 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func TestFromContext(t *testing.T) {
+	newGinContext := func() *gin.Context {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		return ctx
+	}
+	tests := []struct {
+		name string
+		ctx  func() context.Context
+		want string
+	}{
+		{name: "nil context", ctx: func() context.Context { return nil }},
+		{name: "without ID", ctx: context.Background},
+		{name: "context carrying the ID", ctx: func() context.Context { return WithContext(context.Background(), "req-1") }, want: "req-1"},
+		{name: "gin context", ctx: func() context.Context {
+			ctx := newGinContext()
+			Set(ctx, "req-2")
+			return ctx
+		}, want: "req-2"},
+		{name: "context of the request", ctx: func() context.Context {
+			ctx := newGinContext()
+			Set(ctx, "req-3")
+			return ctx.Request.Context()
+		}, want: "req-3"},
+		{name: "context derived from the request", ctx: func() context.Context {
+			ctx := newGinContext()
+			Set(ctx, "req-4")
+			derived, cancel := context.WithCancel(ctx.Request.Context())
+			t.Cleanup(cancel)
+			return derived
+		}, want: "req-4"},
+		{name: "gin context without ID", ctx: func() context.Context { return newGinContext() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromContext(tt.ctx()); got != tt.want {
+				t.Fatalf("FromContext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	first, second := New(), New()
+	if first == "" || first == second {
+		t.Fatalf("New() = %q and %q, want distinct IDs", first, second)
+	}
+}
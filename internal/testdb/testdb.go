@@ -0,0 +1,33 @@
+// Package testdb opens the in-memory SQLite databases of the tests
+package testdb
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open returns an in-memory database holding the tables of the models, closed at the end of
+// the test. It is limited to one connection, as every connection to file::memory: opens its
+// own database.
+func Open(t testing.TB, models ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if len(models) > 0 {
+		if err := db.AutoMigrate(models...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,160 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database/audit"
+	"github.com/nd-tools/capyvel/responses"
+)
+
+// Rate limiting algorithms of RateLimit.Algorithm
+const (
+	SlidingWindow = "sliding_window" // Weighted count of the current and previous windows
+	TokenBucket   = "token_bucket"   // Bucket of Limit tokens refilled over Window, allowing bursts
+)
+
+var (
+	ErrRateLimited      = errors.New("too many requests")             // HTTP 429 Too Many Requests
+	ErrRateLimitConfig  = errors.New("invalid rate limit settings")   // HTTP 500 Internal Server Error
+	ErrRateLimitStorage = errors.New("error reading the rate limits") // Recorded in ctx.Errors, the request is let through
+
+	// Store of the rate limits without their own store
+	defaultRateLimitStore = NewMemoryRateLimitStore()
+)
+
+// RateLimitKey identifies the client a limit is counted for
+type RateLimitKey func(ctx *gin.Context) string
+
+// RateLimit rejects the requests of a client over Limit requests per Window with a 429
+// responses.Error, sending the X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset
+// and Retry-After headers. When the store fails the request is let through and the error
+// is recorded in ctx.Errors. Without a Name the counters are kept per route, so a limit shared
+// by the routes of a group needs one.
+type RateLimit struct {
+	Name      string         // Distinguishes limits sharing a store, derived from the route and the settings when empty
+	Limit     int            // Requests allowed per Window
+	Window    time.Duration  // Period of the limit
+	Algorithm string         // SlidingWindow or TokenBucket, SlidingWindow when empty
+	Key       RateLimitKey   // Client of the request, RateLimitByIP when nil
+	Store     RateLimitStore // Storage of the counters, a process-wide MemoryRateLimitStore when nil
+}
+
+// RateLimitRule is the limit applied by a store to a key
+type RateLimitRule struct {
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+}
+
+// RateLimitResult is the outcome of a request against its limit
+type RateLimitResult struct {
+	Allowed    bool          // Whether the request is under the limit
+	Limit      int           // Requests allowed per window
+	Remaining  int           // Requests left before the limit is reached
+	Reset      time.Duration // Time until the limit is fully available again
+	RetryAfter time.Duration // Time until the next request is allowed, when rejected
+}
+
+// RateLimitStore keeps the state of the limits; it must be safe for concurrent use
+type RateLimitStore interface {
+	// Take counts a request of the key against the rule
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+// RateLimitByIP limits each client IP, see gin.Engine.TrustedPlatform and SetTrustedProxies
+func RateLimitByIP(ctx *gin.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// RateLimitByUser limits each actor stored with audit.SetActor, or each IP for anonymous requests
+func RateLimitByUser(ctx *gin.Context) string {
+	if actor := audit.ContextActor(ctx); actor.ID != "" {
+		return "user:" + actor.ID
+	}
+	return RateLimitByIP(ctx)
+}
+
+// RateLimitByRoute limits each route, whatever its client
+func RateLimitByRoute(ctx *gin.Context) string {
+	return "route:" + ctx.Request.Method + " " + ctx.FullPath()
+}
+
+// RateLimitKeys combines several keys, e.g. RateLimitKeys(RateLimitByRoute, RateLimitByUser)
+// limits each user on each route
+func RateLimitKeys(keys ...RateLimitKey) RateLimitKey {
+	return func(ctx *gin.Context) string {
+		combined := ""
+		for i, key := range keys {
+			if i > 0 {
+				combined += "|"
+			}
+			combined += key(ctx)
+		}
+		return combined
+	}
+}
+
+// Middleware implements middlewareContract.Middleware
+func (l RateLimit) Middleware(ctx *gin.Context) {
+	rule := RateLimitRule{Algorithm: l.Algorithm, Limit: l.Limit, Window: l.Window}
+	if rule.Algorithm == "" {
+		rule.Algorithm = SlidingWindow
+	}
+	if rule.Limit <= 0 || rule.Window <= 0 || (rule.Algorithm != SlidingWindow && rule.Algorithm != TokenBucket) {
+		abortWithError(ctx, ErrRateLimitConfig, fmt.Errorf("limit %d per %s with %q", rule.Limit, rule.Window, rule.Algorithm))
+		return
+	}
+	key, store, name := l.Key, l.Store, l.Name
+	if key == nil {
+		key = RateLimitByIP
+	}
+	if store == nil {
+		store = defaultRateLimitStore
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s %s/%s/%d/%s", ctx.Request.Method, ctx.FullPath(), rule.Algorithm, rule.Limit, rule.Window)
+	}
+
+	result, err := store.Take(ctx, name+":"+key(ctx), rule)
+	if err != nil {
+		ctx.Error(fmt.Errorf("%w: %v", ErrRateLimitStorage, err))
+		ctx.Next()
+		return
+	}
+
+	header := ctx.Writer.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(seconds(result.Reset), 10))
+	if result.Allowed {
+		ctx.Next()
+		return
+	}
+
+	retryAfter := seconds(result.RetryAfter)
+	header.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	var api responses.Api
+	api.Error(ctx, responses.Error{
+		ErrorDetail: responses.ErrorDetail{
+			Message: ErrRateLimited.Error(),
+			Details: fmt.Sprintf("Rate limit of %d requests per %s exceeded, retry in %d seconds", rule.Limit, rule.Window, retryAfter),
+		},
+		Code: http.StatusTooManyRequests,
+		Meta: map[string]interface{}{"retryAfter": retryAfter},
+	})
+}
+
+// Rounds a duration up to whole seconds, as expected by the headers
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
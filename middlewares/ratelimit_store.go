@@ -0,0 +1,250 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nd-tools/capyvel/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	// RateLimitTableName is the table of the DatabaseRateLimitStore
+	RateLimitTableName = "capyvel_rate_limits"
+
+	// Interval between two removals of the expired limits
+	rateLimitSweepInterval = time.Minute
+	// Attempts of a DatabaseRateLimitStore when other replicas update the same key
+	rateLimitMaxAttempts = 5
+)
+
+var (
+	ErrRateLimitContention = errors.New("rate limit updated concurrently too many times") // Triggered when a key keeps being updated by other replicas
+	ErrRateLimitTable      = errors.New("error preparing the rate limit table")           // Triggered when the rate limit table cannot be created
+)
+
+// State of a key, shared by the algorithms
+type rateLimitState struct {
+	Value    float64   // Tokens left, or requests of the current window
+	Previous float64   // Requests of the previous window
+	Start    time.Time // Last refill, or start of the current window
+}
+
+// Applies the rule to the state of a key, counting the request when it is allowed
+func (rule RateLimitRule) take(state *rateLimitState, now time.Time) RateLimitResult {
+	limit := float64(rule.Limit)
+	window := rule.Window.Seconds()
+	result := RateLimitResult{Limit: rule.Limit}
+
+	if rule.Algorithm == TokenBucket {
+		rate := limit / window // Tokens per second
+		if state.Start.IsZero() {
+			state.Value = limit
+		} else {
+			state.Value = math.Min(limit, state.Value+now.Sub(state.Start).Seconds()*rate)
+		}
+		state.Start = now
+		if state.Value >= 1 {
+			state.Value--
+			result.Allowed = true
+		} else {
+			result.RetryAfter = secondsDuration((1 - state.Value) / rate)
+		}
+		result.Remaining = int(state.Value)
+		result.Reset = secondsDuration((limit - state.Value) / rate)
+		return result
+	}
+
+	// Sliding window: the requests of the previous window are weighted by its overlap with the last Window
+	elapsed := now.Sub(state.Start).Seconds()
+	switch {
+	case state.Start.IsZero() || elapsed >= 2*window:
+		*state = rateLimitState{Start: now}
+	case elapsed >= window:
+		*state = rateLimitState{Previous: state.Value, Start: state.Start.Add(rule.Window)}
+	}
+	elapsed = now.Sub(state.Start).Seconds()
+	count := state.Previous*(1-elapsed/window) + state.Value
+	if count+1 <= limit {
+		state.Value++
+		count++
+		result.Allowed = true
+	} else if state.Value+1 > limit {
+		// Wait for the current window to become the previous one and fade enough
+		result.RetryAfter = secondsDuration(window - elapsed + window*(1-(limit-1)/state.Value))
+	} else {
+		result.RetryAfter = secondsDuration(window*(1-(limit-1-state.Value)/state.Previous) - elapsed)
+	}
+	result.Remaining = int(math.Max(0, limit-count))
+	result.Reset = secondsDuration(window - elapsed)
+	if state.Value > 0 {
+		result.Reset += rule.Window
+	}
+	return result
+}
+
+// Converts seconds to a duration
+func secondsDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Time after which the state of a key is back to its initial value
+func (rule RateLimitRule) expiration(now time.Time) time.Time {
+	return now.Add(2 * rule.Window)
+}
+
+// MemoryRateLimitStore keeps the limits in the memory of the process
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	states    map[string]*memoryRateLimit
+	lastSweep time.Time
+}
+
+// State of a key of the MemoryRateLimitStore
+type memoryRateLimit struct {
+	state     rateLimitState
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{states: map[string]*memoryRateLimit{}}
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		for k, entry := range s.states {
+			if now.After(entry.expiresAt) {
+				delete(s.states, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	entry, ok := s.states[key]
+	if !ok {
+		entry = &memoryRateLimit{}
+		s.states[key] = entry
+	}
+	result := rule.take(&entry.state, now)
+	entry.expiresAt = rule.expiration(now)
+	return result, nil
+}
+
+// RateLimitRecord is a row of the rate limit table
+type RateLimitRecord struct {
+	Name        string    `gorm:"column:name;primaryKey;size:255"`
+	Value       float64   `gorm:"column:value"`
+	Previous    float64   `gorm:"column:previous"`
+	WindowStart time.Time `gorm:"column:window_start"`
+	Version     int64     `gorm:"column:version"`
+	ExpiresAt   time.Time `gorm:"column:expires_at;index"`
+}
+
+// TableName implements gorm's Tabler
+func (RateLimitRecord) TableName() string {
+	return RateLimitTableName
+}
+
+// DatabaseRateLimitStore keeps the limits in the rate limit table so that every replica of
+// the application shares them. Rows are updated with a compare-and-swap on their version.
+type DatabaseRateLimitStore struct {
+	Connection string // Named connection of database.Boot, the default connection when empty
+
+	mu        sync.Mutex
+	migrated  bool
+	lastSweep time.Time
+}
+
+// NewDatabaseRateLimitStore creates a store on the named connection, the default one when empty
+func NewDatabaseRateLimitStore(connection string) *DatabaseRateLimitStore {
+	return &DatabaseRateLimitStore{Connection: connection}
+}
+
+// Take implements RateLimitStore
+func (s *DatabaseRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	if database.DB.Ctx == nil {
+		return RateLimitResult{}, database.ErrDatabaseNotBooted
+	}
+	db := database.DB.Connection(s.Connection).Clauses(dbresolver.Write).WithContext(ctx)
+	if err := s.prepare(db); err != nil {
+		return RateLimitResult{}, err
+	}
+	nameColumn := clause.Eq{Column: clause.Column{Name: "name"}, Value: key}
+	for attempt := 0; attempt < rateLimitMaxAttempts; attempt++ {
+		var record RateLimitRecord
+		find := db.Where(nameColumn).Limit(1).Find(&record)
+		if find.Error != nil {
+			return RateLimitResult{}, find.Error
+		}
+		now := time.Now()
+		var state rateLimitState
+		if find.RowsAffected == 1 && now.Before(record.ExpiresAt) {
+			state = rateLimitState{Value: record.Value, Previous: record.Previous, Start: record.WindowStart}
+		}
+		result := rule.take(&state, now)
+		// Rejections are recomputed from the stored state, only the counted requests are saved
+		if !result.Allowed {
+			return result, nil
+		}
+
+		var write *gorm.DB
+		if find.RowsAffected == 0 {
+			write = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RateLimitRecord{
+				Name:        key,
+				Value:       state.Value,
+				Previous:    state.Previous,
+				WindowStart: state.Start,
+				Version:     1,
+				ExpiresAt:   rule.expiration(now),
+			})
+		} else {
+			write = db.Model(&RateLimitRecord{}).Where(nameColumn).
+				Where(clause.Eq{Column: clause.Column{Name: "version"}, Value: record.Version}).
+				Updates(map[string]interface{}{
+					"value":        state.Value,
+					"previous":     state.Previous,
+					"window_start": state.Start,
+					"version":      record.Version + 1,
+					"expires_at":   rule.expiration(now),
+				})
+		}
+		if write.Error != nil {
+			return RateLimitResult{}, write.Error
+		}
+		if write.RowsAffected == 1 {
+			return result, nil
+		}
+	}
+	return RateLimitResult{}, fmt.Errorf("%w: %s", ErrRateLimitContention, key)
+}
+
+// Creates the rate limit table the first time and removes the expired limits periodically
+func (s *DatabaseRateLimitStore) prepare(db *gorm.DB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.migrated {
+		if err := db.AutoMigrate(&RateLimitRecord{}); err != nil {
+			return fmt.Errorf("%w: %v", ErrRateLimitTable, err)
+		}
+		s.migrated = true
+	}
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		s.lastSweep = now
+		return db.Where(clause.Lt{Column: clause.Column{Name: "expires_at"}, Value: now}).Delete(&RateLimitRecord{}).Error
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database"
+)
+
+func TestRateLimitRuleTake(t *testing.T) {
+	type step struct {
+		at            time.Duration // Time of the request since the first one
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+		wantReset     time.Duration
+	}
+	tests := []struct {
+		name  string
+		rule  RateLimitRule
+		steps []step
+	}{
+		{
+			name: "sliding window",
+			rule: RateLimitRule{Algorithm: SlidingWindow, Limit: 2, Window: 10 * time.Second},
+			steps: []step{
+				{at: 0, wantAllowed: true, wantRemaining: 1, wantReset: 20 * time.Second},
+				{at: time.Second, wantAllowed: true, wantRemaining: 0, wantReset: 19 * time.Second},
+				// Both requests are in the current window, which must become the previous one and fade by half
+				{at: 2 * time.Second, wantRetry: 13 * time.Second, wantReset: 18 * time.Second},
+				{at: 15 * time.Second, wantAllowed: true, wantRemaining: 0, wantReset: 15 * time.Second},
+				// 2 requests weighted by 0.4 and 1 request of the current window, the previous window must fade
+				{at: 16 * time.Second, wantRetry: 4 * time.Second, wantReset: 14 * time.Second},
+				{at: 20 * time.Second, wantAllowed: true, wantRemaining: 0, wantReset: 20 * time.Second},
+				// Two idle windows reset the limit
+				{at: 45 * time.Second, wantAllowed: true, wantRemaining: 1, wantReset: 20 * time.Second},
+			},
+		},
+		{
+			name: "token bucket",
+			rule: RateLimitRule{Algorithm: TokenBucket, Limit: 2, Window: 10 * time.Second},
+			steps: []step{
+				{at: 0, wantAllowed: true, wantRemaining: 1, wantReset: 5 * time.Second},
+				{at: 0, wantAllowed: true, wantRemaining: 0, wantReset: 10 * time.Second},
+				{at: 0, wantRetry: 5 * time.Second, wantReset: 10 * time.Second},
+				{at: 5 * time.Second, wantAllowed: true, wantRemaining: 0, wantReset: 10 * time.Second},
+				// Half a token refilled
+				{at: 7500 * time.Millisecond, wantRetry: 2500 * time.Millisecond, wantReset: 7500 * time.Millisecond},
+				// The bucket never holds more than Limit tokens
+				{at: 100 * time.Second, wantAllowed: true, wantRemaining: 1, wantReset: 5 * time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			var state rateLimitState
+			for i, step := range tt.steps {
+				got := tt.rule.take(&state, start.Add(step.at))
+				got.RetryAfter = got.RetryAfter.Round(time.Millisecond)
+				got.Reset = got.Reset.Round(time.Millisecond)
+				want := RateLimitResult{Allowed: step.wantAllowed, Limit: tt.rule.Limit, Remaining: step.wantRemaining, RetryAfter: step.wantRetry, Reset: step.wantReset}
+				if got != want {
+					t.Fatalf("request %d at %s = %+v, want %+v", i, step.at, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Store failing every request
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, RateLimitRule) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	type request struct {
+		path           string
+		wantStatus     int
+		wantRemaining  string
+		wantRetryAfter string
+	}
+	tests := []struct {
+		name     string
+		limit    RateLimit
+		requests []request
+	}{
+		{
+			name:  "limit",
+			limit: RateLimit{Limit: 2, Window: time.Minute},
+			requests: []request{
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "1"},
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/a", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetryAfter: "90"},
+			},
+		},
+		{
+			name:  "routes are limited separately without a name",
+			limit: RateLimit{Limit: 1, Window: time.Minute},
+			requests: []request{
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/b", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/a", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetryAfter: "120"},
+			},
+		},
+		{
+			name:  "named limit shared by the routes",
+			limit: RateLimit{Name: "api", Limit: 1, Window: time.Minute},
+			requests: []request{
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/b", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetryAfter: "120"},
+			},
+		},
+		{
+			name:  "limit by route",
+			limit: RateLimit{Name: "api", Limit: 1, Window: time.Minute, Key: RateLimitByRoute},
+			requests: []request{
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/b", wantStatus: http.StatusOK, wantRemaining: "0"},
+			},
+		},
+		{
+			name:  "token bucket",
+			limit: RateLimit{Limit: 1, Window: time.Minute, Algorithm: TokenBucket},
+			requests: []request{
+				{path: "/a", wantStatus: http.StatusOK, wantRemaining: "0"},
+				{path: "/a", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetryAfter: "60"},
+			},
+		},
+		{
+			name:     "store failure lets the request through",
+			limit:    RateLimit{Limit: 1, Window: time.Minute, Store: failingRateLimitStore{}},
+			requests: []request{{path: "/a", wantStatus: http.StatusOK}},
+		},
+		{
+			name:     "invalid limit",
+			limit:    RateLimit{Window: time.Minute},
+			requests: []request{{path: "/a", wantStatus: http.StatusInternalServerError}},
+		},
+		{
+			name:     "unknown algorithm",
+			limit:    RateLimit{Limit: 1, Window: time.Minute, Algorithm: "fixed_window"},
+			requests: []request{{path: "/a", wantStatus: http.StatusInternalServerError}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limit.Store == nil {
+				tt.limit.Store = NewMemoryRateLimitStore()
+			}
+			engine := gin.New()
+			engine.Use(tt.limit.Middleware)
+			engine.GET("/a", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+			engine.GET("/b", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+			for i, request := range tt.requests {
+				recorder := httptest.NewRecorder()
+				engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, request.path, nil))
+				header := recorder.Header()
+				if recorder.Code != request.wantStatus || header.Get("X-RateLimit-Remaining") != request.wantRemaining || header.Get("Retry-After") != request.wantRetryAfter {
+					t.Fatalf("request %d to %s = %d with headers %v, want %d, remaining %q and retry after %q", i, request.path, recorder.Code, header, request.wantStatus, request.wantRemaining, request.wantRetryAfter)
+				}
+				if wantLimit := strconv.Itoa(tt.limit.Limit); request.wantRemaining != "" && header.Get("X-RateLimit-Limit") != wantLimit {
+					t.Fatalf("X-RateLimit-Limit = %q, want %s", header.Get("X-RateLimit-Limit"), wantLimit)
+				}
+			}
+		})
+	}
+}
+
+func TestDatabaseRateLimitStore(t *testing.T) {
+	rule := RateLimitRule{Algorithm: SlidingWindow, Limit: 1, Window: time.Minute}
+	store := NewDatabaseRateLimitStore("")
+	if _, err := store.Take(context.Background(), "ip:1", rule); !errors.Is(err, database.ErrDatabaseNotBooted) {
+		t.Fatalf("Take() before booting the database error = %v, want %v", err, database.ErrDatabaseNotBooted)
+	}
+
+	db := useTestDB(t)
+	tests := []struct {
+		key         string
+		wantAllowed bool
+	}{
+		{key: "ip:1", wantAllowed: true},
+		{key: "ip:1", wantAllowed: false},
+		{key: "ip:2", wantAllowed: true},
+	}
+	for i, tt := range tests {
+		result, err := store.Take(context.Background(), tt.key, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != tt.wantAllowed {
+			t.Fatalf("request %d of %s allowed = %v, want %v", i, tt.key, result.Allowed, tt.wantAllowed)
+		}
+	}
+	// Rejected requests are not stored
+	var record RateLimitRecord
+	if err := db.Take(&record, "name = ?", "ip:1").Error; err != nil || record.Value != 1 || record.Version != 1 {
+		t.Fatalf("stored limit = %+v, %v, want one counted request", record, err)
+	}
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/helpers/requestid"
+)
+
+// Request IDs accepted from the clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID accepts the request ID sent by the client or generates one with helpers/uuid,
+// stores it with requestid.Set and echoes it in the response. The ID is written in the access
+// log, in the query log and in the responses.Error body, see requestid.FromContext.
+type RequestID struct {
+	Header          string        // Header carrying the ID, requestid.Header when empty
+	Generate        func() string // Generates the missing IDs, requestid.New when nil
+	DisableIncoming bool          // Always generate the ID, ignoring the one sent by the client
+}
+
+// Middleware implements middlewareContract.Middleware
+func (r RequestID) Middleware(ctx *gin.Context) {
+	header := r.Header
+	if header == "" {
+		header = requestid.Header
+	}
+	id := ctx.GetHeader(header)
+	if r.DisableIncoming || !validRequestID.MatchString(id) {
+		if r.Generate != nil {
+			id = r.Generate()
+		} else {
+			id = requestid.New()
+		}
+	}
+	requestid.Set(ctx, id)
+	ctx.Header(header, id)
+	ctx.Next()
+}
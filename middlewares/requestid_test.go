@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/helpers/requestid"
+	"github.com/nd-tools/capyvel/responses"
+)
+
+func TestRequestID(t *testing.T) {
+	generate := func() string { return "generated" }
+	tests := []struct {
+		name       string
+		middleware RequestID
+		header     string // Header sent by the client, requestid.Header when empty
+		incoming   string
+		want       string // "" when a uuid is expected
+	}{
+		{name: "incoming ID", incoming: "req-1.a:b_C", want: "req-1.a:b_C"},
+		{name: "missing ID", middleware: RequestID{Generate: generate}, want: "generated"},
+		{name: "generated with the default generator"},
+		{name: "invalid characters", middleware: RequestID{Generate: generate}, incoming: "req 1\r\nX-Injected: 1", want: "generated"},
+		{name: "too long", middleware: RequestID{Generate: generate}, incoming: strings.Repeat("a", 129), want: "generated"},
+		{name: "longest ID", incoming: strings.Repeat("a", 128), want: strings.Repeat("a", 128)},
+		{name: "incoming IDs disabled", middleware: RequestID{Generate: generate, DisableIncoming: true}, incoming: "req-1", want: "generated"},
+		{name: "custom header", middleware: RequestID{Header: "X-Correlation-ID"}, header: "X-Correlation-ID", incoming: "req-2", want: "req-2"},
+		{name: "other header ignored", middleware: RequestID{Header: "X-Correlation-ID", Generate: generate}, header: requestid.Header, incoming: "req-2", want: "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = tt.middleware.Header
+			}
+			if header == "" {
+				header = requestid.Header
+			}
+			responseHeader := tt.middleware.Header
+			if responseHeader == "" {
+				responseHeader = requestid.Header
+			}
+
+			var fromGin, fromRequest string
+			engine := gin.New()
+			engine.Use(tt.middleware.Middleware)
+			engine.GET("/", func(ctx *gin.Context) {
+				fromGin = requestid.FromContext(ctx)
+				fromRequest = requestid.FromContext(ctx.Request.Context())
+				var api responses.Api
+				api.Error(ctx, responses.Error{Code: http.StatusConflict})
+			})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				request.Header[http.CanonicalHeaderKey(header)] = []string{tt.incoming}
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			id := recorder.Header().Get(responseHeader)
+			if (tt.want != "" && id != tt.want) || (tt.want == "" && len(id) != 36) {
+				t.Fatalf("%s = %q, want %q", responseHeader, id, tt.want)
+			}
+			if fromGin != id || fromRequest != id {
+				t.Fatalf("request ID of the contexts = %q and %q, want %q", fromGin, fromRequest, id)
+			}
+			var body struct {
+				RequestID string `json:"requestId"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body.RequestID != id {
+				t.Fatalf("error body = %s, want the request ID %q", recorder.Body.String(), id)
+			}
+		})
+	}
+}
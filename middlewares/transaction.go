@@ -0,0 +1,199 @@
+package middlewares
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database"
+	"github.com/nd-tools/capyvel/responses"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var (
+	ErrBeginTransaction  = errors.New("error starting the request transaction")   // HTTP 500 Internal Server Error
+	ErrCommitTransaction = errors.New("error committing the request transaction") // HTTP 500 Internal Server Error
+
+	// Counter used to name the savepoints of nested transactions
+	savePointSequence int64
+)
+
+// Transaction opens a database transaction for each request and stores it in the gin.Context,
+// where helpers.Orm picks it up when its config.Db is nil. The transaction is committed when the
+// response status is 2xx and rolled back on Api.Error, on any other status or on panic.
+//
+// The response is buffered until the transaction ends so that a failed commit can still be
+// reported to the client; set DisableBuffering for streaming handlers.
+type Transaction struct {
+	Connection       string         // Named connection of database.Boot, the tenant or default connection when empty
+	Options          *sql.TxOptions // Isolation level and read-only options
+	SavePoint        bool           // Open a savepoint when the request already has a transaction
+	DisableBuffering bool           // Write the response directly, committing after it was sent
+}
+
+// Middleware implements middlewareContract.Middleware
+func (t Transaction) Middleware(ctx *gin.Context) {
+	parent, nested := database.ContextTransaction(ctx)
+	if nested && !t.SavePoint {
+		ctx.Next()
+		return
+	}
+
+	var tx *gorm.DB
+	var savePoint string
+	if nested {
+		savePoint = fmt.Sprintf("capyvel_sp_%d", atomic.AddInt64(&savePointSequence, 1))
+		tx = parent
+		if err := tx.SavePoint(savePoint).Error; err != nil {
+			abortWithError(ctx, ErrBeginTransaction, err)
+			return
+		}
+	} else {
+		db := database.DB.Connection(t.Connection)
+		if t.Connection == "" {
+			// Open the transaction on the connection of the request tenant, if any
+			tenant, err := database.TenantConnection(ctx)
+			if err != nil {
+				abortWithError(ctx, ErrBeginTransaction, err)
+				return
+			}
+			if tenant != nil {
+				db = tenant
+			}
+		}
+		tx = db.Clauses(dbresolver.Write).Begin(t.Options)
+		if tx.Error != nil {
+			abortWithError(ctx, ErrBeginTransaction, tx.Error)
+			return
+		}
+		database.SetContextTransaction(ctx, tx)
+	}
+
+	rollback := func() {
+		if nested {
+			tx.RollbackTo(savePoint)
+		} else {
+			tx.Rollback()
+		}
+	}
+
+	var writer *bufferedWriter
+	original := ctx.Writer
+	if !t.DisableBuffering {
+		writer = &bufferedWriter{ResponseWriter: original, status: http.StatusOK}
+		ctx.Writer = writer
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ctx.Writer = original
+			rollback()
+			panic(r)
+		}
+	}()
+
+	ctx.Next()
+
+	ctx.Writer = original
+	status := ctx.Writer.Status()
+	if writer != nil {
+		status = writer.status
+	}
+
+	if ctx.IsAborted() || len(ctx.Errors) > 0 || status < http.StatusOK || status >= http.StatusMultipleChoices {
+		rollback()
+	} else if !nested {
+		if err := tx.Commit().Error; err != nil {
+			if writer != nil {
+				abortWithError(ctx, ErrCommitTransaction, err)
+				return
+			}
+			ctx.Error(fmt.Errorf("%w: %v", ErrCommitTransaction, err))
+		}
+	}
+
+	if writer != nil {
+		writer.flush()
+	}
+}
+
+// Sends an error response using the responses.Error envelope and aborts the request
+func abortWithError(ctx *gin.Context, message error, err error) {
+	var api responses.Api
+	api.Error(ctx, responses.Error{
+		ErrorDetail: responses.ErrorDetail{
+			Message: message.Error(),
+			Error:   err,
+			Type:    responses.TypeDB,
+		},
+		Code: http.StatusInternalServerError,
+	})
+}
+
+// bufferedWriter holds the status and body of the response until the transaction ends
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	status  int
+	written bool
+}
+
+// WriteHeader stores the status code
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow marks the headers as written
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+// Write stores the body
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+// WriteString stores the body
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+// Status returns the stored status code
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+// Size returns the size of the stored body, or -1 when nothing was written
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+// Written reports whether the headers or the body were written
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+// Flush is a no-op until the transaction ends
+func (w *bufferedWriter) Flush() {}
+
+// flush sends the stored status and body to the original writer
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
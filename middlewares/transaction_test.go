@@ -0,0 +1,165 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database"
+	"github.com/nd-tools/capyvel/internal/testdb"
+	"gorm.io/gorm"
+)
+
+type entry struct {
+	ID   uint
+	Name string
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// Boots database.DB on an in-memory database for the duration of the test
+func useTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t, &entry{})
+	previous := database.DB
+	database.DB = database.Database{Ctx: db}
+	t.Cleanup(func() { database.DB = previous })
+	return db
+}
+
+// Handler inserting an entry with the request transaction
+func insertEntry(name string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tx, ok := database.ContextTransaction(ctx)
+		if !ok {
+			ctx.AbortWithStatus(http.StatusTeapot)
+			return
+		}
+		if err := tx.Create(&entry{Name: name}).Error; err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		middlewares []gin.HandlerFunc
+		handler     gin.HandlerFunc
+		wantStatus  int
+		wantEntries []string
+	}{
+		{
+			name:        "2xx commits",
+			middlewares: []gin.HandlerFunc{Transaction{}.Middleware, insertEntry("a")},
+			handler:     func(ctx *gin.Context) { ctx.String(http.StatusCreated, "ok") },
+			wantStatus:  http.StatusCreated,
+			wantEntries: []string{"a"},
+		},
+		{
+			name:        "4xx rolls back",
+			middlewares: []gin.HandlerFunc{Transaction{}.Middleware, insertEntry("a")},
+			handler:     func(ctx *gin.Context) { ctx.String(http.StatusBadRequest, "invalid") },
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "context error rolls back",
+			middlewares: []gin.HandlerFunc{Transaction{}.Middleware, insertEntry("a")},
+			handler: func(ctx *gin.Context) {
+				ctx.Error(errors.New("failed"))
+				ctx.String(http.StatusOK, "ok")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "abort rolls back",
+			middlewares: []gin.HandlerFunc{Transaction{}.Middleware, insertEntry("a")},
+			handler:     func(ctx *gin.Context) { ctx.AbortWithStatus(http.StatusOK) },
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "panic rolls back",
+			middlewares: []gin.HandlerFunc{gin.CustomRecovery(func(ctx *gin.Context, _ any) { ctx.AbortWithStatus(http.StatusInternalServerError) }), Transaction{}.Middleware, insertEntry("a")},
+			handler:     func(ctx *gin.Context) { panic("boom") },
+			wantStatus:  http.StatusInternalServerError,
+		},
+		{
+			name:        "unbuffered 2xx commits",
+			middlewares: []gin.HandlerFunc{Transaction{DisableBuffering: true}.Middleware, insertEntry("a")},
+			handler:     func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") },
+			wantStatus:  http.StatusOK,
+			wantEntries: []string{"a"},
+		},
+		{
+			name:        "nested without savepoint shares the transaction",
+			middlewares: []gin.HandlerFunc{Transaction{}.Middleware, insertEntry("a"), Transaction{}.Middleware, insertEntry("b")},
+			handler:     func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") },
+			wantStatus:  http.StatusOK,
+			wantEntries: []string{"a", "b"},
+		},
+		{
+			name: "failed savepoint keeps the outer writes",
+			middlewares: []gin.HandlerFunc{
+				Transaction{}.Middleware,
+				insertEntry("a"),
+				func(ctx *gin.Context) {
+					// The inner chain fails and the outer middleware recovers from it
+					Transaction{SavePoint: true}.Middleware(ctx)
+					ctx.Errors = nil
+				},
+			},
+			handler: func(ctx *gin.Context) {
+				tx, _ := database.ContextTransaction(ctx)
+				tx.Create(&entry{Name: "b"})
+				ctx.Error(errors.New("failed"))
+			},
+			wantStatus:  http.StatusOK,
+			wantEntries: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useTestDB(t)
+			engine := gin.New()
+			engine.GET("/", append(tt.middlewares, tt.handler)...)
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			var names []string
+			if err := db.Model(&entry{}).Order("id").Pluck("name", &names).Error; err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(names, tt.wantEntries) {
+				t.Fatalf("entries = %v, want %v", names, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestTransactionBuffersUntilCommit(t *testing.T) {
+	useTestDB(t)
+	engine := gin.New()
+	var writtenBeforeCommit bool
+	engine.GET("/", Transaction{}.Middleware, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "body")
+		writtenBeforeCommit = ctx.Writer.Written() && ctx.Writer.Size() == len("body")
+	})
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !writtenBeforeCommit {
+		t.Fatal("the handler did not see its buffered write")
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "body" {
+		t.Fatalf("response = %d %q, want 200 \"body\"", recorder.Code, recorder.Body.String())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/helpers/requestid"
 )
 
 // Api represents a standard response structure for the API.
@@ -37,6 +38,9 @@ func (api *Api) Error(ctx *gin.Context, e Error) {
 	// Populate the error response details
 	e.Status = e.Code
 	e.Success = false
+	if e.RequestID == "" {
+		e.RequestID = requestid.FromContext(ctx)
+	}
 
 	// Send the error response as JSON and abort the request
 	ctx.JSON(e.Code, e)
@@ -23,10 +23,12 @@ type ErrorDetail struct {
 
 // Struct representing a complete error response
 type Error struct {
-	ErrorDetail ErrorDetail `json:"error"`   // Detailed error information
-	Status      int         `json:"status"`  // HTTP status code
-	Code        int         `json:"-"`       // Internal HTTP code (not serialized in JSON)
-	Success     bool        `json:"success"` // Indicates whether the request was successful
+	ErrorDetail ErrorDetail `json:"error"`               // Detailed error information
+	Status      int         `json:"status"`              // HTTP status code
+	Code        int         `json:"-"`                   // Internal HTTP code (not serialized in JSON)
+	Success     bool        `json:"success"`             // Indicates whether the request was successful
+	Meta        any         `json:"meta,omitempty"`      // Additional metadata (e.g. the current version on a conflict)
+	RequestID   string      `json:"requestId,omitempty"` // ID of the request, to correlate the error with the server logs
 }
 
 // Method to load or translate the error details if not already defined
@@ -0,0 +1,306 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database/audit"
+	"github.com/nd-tools/capyvel/helpers/requestid"
+)
+
+// Formats of the access log lines
+const (
+	AccessLogJSON   = "json"   // One JSON object per line
+	AccessLogLogfmt = "logfmt" // key=value pairs
+)
+
+// Default http.access_log settings
+const (
+	DefaultAccessLogMaxBodyBytes = 4096
+	redactedValue                = "[REDACTED]"
+)
+
+var (
+	// Headers never written in clear text
+	DefaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+	// Body and query fields never written in clear text
+	DefaultRedactFields = []string{"password", "token", "access_token", "refresh_token", "secret", "client_secret"}
+)
+
+// AccessLogConfig holds the settings of the access log
+type AccessLogConfig struct {
+	Format        string    // AccessLogJSON or AccessLogLogfmt, AccessLogJSON when empty
+	Output        io.Writer // Destination of the lines, os.Stdout when nil
+	SampleRate    float64   // Share of the 1xx-3xx requests logged, up to 1, 1 when zero; errors are always logged
+	Headers       []string  // Request headers written in each line
+	RedactHeaders []string  // Headers written as [REDACTED], DefaultRedactHeaders when nil
+	LogBody       bool      // Write the JSON and form request bodies
+	MaxBodyBytes  int       // Bytes of the body read for the log, DefaultAccessLogMaxBodyBytes when zero
+	RedactFields  []string  // Body and query fields written as [REDACTED], DefaultRedactFields when nil
+	SkipPaths     []string  // Paths never logged, e.g. health checks
+}
+
+// AccessLog returns a middleware writing one structured line per request with its method,
+// route template, status, latency, bytes, client IP, user ID (see audit.SetActor) and request ID
+func AccessLog(config AccessLogConfig) gin.HandlerFunc {
+	output := config.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	var handler slog.Handler
+	if config.Format == AccessLogLogfmt {
+		handler = slog.NewTextHandler(output, nil)
+	} else {
+		handler = slog.NewJSONHandler(output, nil)
+	}
+	logger := slog.New(handler)
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = DefaultRedactHeaders
+	}
+	if config.RedactFields == nil {
+		config.RedactFields = DefaultRedactFields
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = DefaultAccessLogMaxBodyBytes
+	}
+	if config.SampleRate <= 0 {
+		config.SampleRate = 1
+	}
+
+	return func(ctx *gin.Context) {
+		if slices.Contains(config.SkipPaths, ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+		start := time.Now()
+		var body []byte
+		if config.LogBody && ctx.Request.Body != nil {
+			body = peekBody(ctx.Request, config.MaxBodyBytes)
+		}
+
+		// Log the panics that are not recovered by a later middleware before propagating them
+		defer func() {
+			if r := recover(); r != nil {
+				config.log(ctx, logger, start, http.StatusInternalServerError, body, fmt.Sprint(r))
+				panic(r)
+			}
+		}()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		if status < http.StatusBadRequest && config.SampleRate < 1 && rand.Float64() >= config.SampleRate {
+			return
+		}
+		config.log(ctx, logger, start, status, body, ctx.Errors.String())
+	}
+}
+
+// Writes the line of a request
+func (config AccessLogConfig) log(ctx *gin.Context, logger *slog.Logger, start time.Time, status int, body []byte, errs string) {
+	size := ctx.Writer.Size()
+	if size < 0 {
+		size = 0
+	}
+	attrs := []slog.Attr{
+		slog.String("method", ctx.Request.Method),
+		slog.String("route", ctx.FullPath()),
+		slog.String("path", ctx.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", size),
+		slog.String("client_ip", ctx.ClientIP()),
+		slog.String("user_id", audit.ContextActor(ctx).ID),
+		slog.String("request_id", requestid.FromContext(ctx)),
+	}
+	if ctx.Request.URL.RawQuery != "" {
+		attrs = append(attrs, slog.String("query", config.redactQuery(ctx.Request.URL.Query())))
+	}
+	if len(config.Headers) > 0 {
+		headers := make([]any, 0, len(config.Headers))
+		for _, name := range config.Headers {
+			value := ctx.GetHeader(name)
+			if value != "" && containsFold(config.RedactHeaders, name) {
+				value = redactedValue
+			}
+			headers = append(headers, slog.String(name, value))
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+	if len(body) > 0 {
+		if redacted, ok := config.redactBody(ctx.ContentType(), body); ok {
+			attrs = append(attrs, slog.String("body", redacted))
+		}
+	}
+	if errs != "" {
+		attrs = append(attrs, slog.String("errors", strings.TrimSpace(errs)))
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(ctx, level, "access", attrs...)
+}
+
+// Reads the first bytes of the body, leaving the whole body to the handlers
+func peekBody(request *http.Request, max int) []byte {
+	peeked, _ := io.ReadAll(io.LimitReader(request.Body, int64(max)))
+	request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), request.Body), request.Body}
+	return peeked
+}
+
+// Returns the query with the redacted fields masked
+func (config AccessLogConfig) redactQuery(query url.Values) string {
+	for key := range query {
+		if containsFold(config.RedactFields, key) {
+			query[key] = []string{redactedValue}
+		}
+	}
+	return query.Encode()
+}
+
+// Returns a JSON or form body with the redacted fields masked; other bodies are not logged
+func (config AccessLogConfig) redactBody(contentType string, body []byte) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			// Truncated or invalid JSON cannot be redacted field by field
+			return fmt.Sprintf("[%d bytes of unparsable JSON]", len(body)), true
+		}
+		redacted, _ := json.Marshal(config.redactJSON(value))
+		return string(redacted), true
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Sprintf("[%d bytes of unparsable form]", len(body)), true
+		}
+		return config.redactQuery(form), true
+	}
+	return "", false
+}
+
+// Masks the redacted fields of a decoded JSON value at any depth
+func (config AccessLogConfig) redactJSON(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, field := range typed {
+			if containsFold(config.RedactFields, key) {
+				typed[key] = redactedValue
+			} else {
+				typed[key] = config.redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range typed {
+			typed[i] = config.redactJSON(item)
+		}
+	}
+	return value
+}
+
+// Reports whether the list holds the value, ignoring case
+func containsFold(list []string, value string) bool {
+	return slices.ContainsFunc(list, func(item string) bool { return strings.EqualFold(item, value) })
+}
+
+// Reads the http.access_log settings. The access log is enabled unless http.access_log.enable
+// is false, in which case the config is nil. The path of the log file, if any, is returned
+// instead of being opened, so that no file is left open when another setting is invalid.
+func accessLogFromConfig(values map[string]interface{}) (*AccessLogConfig, string, error) {
+	invalid := func(key string) error {
+		return fmt.Errorf(ErrInvalidHTTPConfig, "access_log."+key)
+	}
+	if enable, ok := values["enable"]; ok {
+		if enabled, ok := enable.(bool); !ok {
+			return nil, "", invalid("enable")
+		} else if !enabled {
+			return nil, "", nil
+		}
+	}
+
+	config := AccessLogConfig{SampleRate: 1}
+	if format, ok := values["format"]; ok {
+		if config.Format, ok = format.(string); !ok || (config.Format != AccessLogJSON && config.Format != AccessLogLogfmt) {
+			return nil, "", invalid("format")
+		}
+	}
+	switch rate := values["sample_rate"].(type) {
+	case nil:
+	case float64:
+		config.SampleRate = rate
+	case int:
+		config.SampleRate = float64(rate)
+	default:
+		return nil, "", invalid("sample_rate")
+	}
+	// A zero rate would log every request, which is surely not what the setting meant
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		return nil, "", invalid("sample_rate")
+	}
+	lists := map[string]*[]string{
+		"headers":        &config.Headers,
+		"redact_headers": &config.RedactHeaders,
+		"redact_fields":  &config.RedactFields,
+		"skip_paths":     &config.SkipPaths,
+	}
+	for key, target := range lists {
+		if value, ok := values[key]; ok {
+			if *target, ok = value.([]string); !ok {
+				return nil, "", invalid(key)
+			}
+		}
+	}
+	if logBody, ok := values["log_body"]; ok {
+		if config.LogBody, ok = logBody.(bool); !ok {
+			return nil, "", invalid("log_body")
+		}
+	}
+	if maxBodyBytes, ok := values["max_body_bytes"]; ok {
+		if config.MaxBodyBytes, ok = maxBodyBytes.(int); !ok || config.MaxBodyBytes <= 0 {
+			return nil, "", invalid("max_body_bytes")
+		}
+	}
+
+	output, ok := values["output"].(string)
+	if _, exists := values["output"]; exists && !ok {
+		return nil, "", invalid("output")
+	}
+	switch output {
+	case "", "stdout":
+		config.Output = os.Stdout
+	case "stderr":
+		config.Output = os.Stderr
+	default:
+		return &config, output, nil
+	}
+	return &config, "", nil
+}
+
+// Opens the access log file, appending to it
+func openAccessLog(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", fmt.Errorf(ErrInvalidHTTPConfig, "access_log.output"), err)
+	}
+	return file, nil
+}
@@ -0,0 +1,270 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/database/audit"
+	"github.com/nd-tools/capyvel/helpers/requestid"
+)
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      AccessLogConfig
+		method      string
+		target      string
+		contentType string
+		body        string
+		headers     map[string]string
+		status      int                    // Status of the handler, which panics when zero
+		want        map[string]interface{} // Fields of the line, nil when no line is expected
+	}{
+		{
+			name:   "request fields",
+			target: "/api/users/7",
+			status: http.StatusCreated,
+			want: map[string]interface{}{
+				"level": "INFO", "msg": "access", "method": "GET", "route": "/api/users/:id", "path": "/api/users/7",
+				"status": float64(http.StatusCreated), "bytes": float64(2), "user_id": "9", "request_id": "req-1", "client_ip": "192.0.2.1",
+			},
+		},
+		{
+			name:    "redacted headers",
+			config:  AccessLogConfig{Headers: []string{"authorization", "Accept", "X-Missing"}},
+			target:  "/api/users/7",
+			headers: map[string]string{"Authorization": "Bearer secret", "Accept": "application/json"},
+			status:  http.StatusOK,
+			want:    map[string]interface{}{"headers": map[string]interface{}{"authorization": redactedValue, "Accept": "application/json", "X-Missing": ""}},
+		},
+		{
+			name:    "custom redacted headers",
+			config:  AccessLogConfig{Headers: []string{"Authorization", "X-Tenant"}, RedactHeaders: []string{"X-Tenant"}},
+			target:  "/api/users/7",
+			headers: map[string]string{"Authorization": "Bearer secret", "X-Tenant": "acme"},
+			status:  http.StatusOK,
+			want:    map[string]interface{}{"headers": map[string]interface{}{"Authorization": "Bearer secret", "X-Tenant": redactedValue}},
+		},
+		{
+			name:   "redacted query",
+			target: "/api/users/7?Token=abc&q=ana",
+			status: http.StatusOK,
+			want:   map[string]interface{}{"query": "Token=%5BREDACTED%5D&q=ana"},
+		},
+		{
+			name:        "redacted JSON body",
+			config:      AccessLogConfig{LogBody: true},
+			method:      http.MethodPost,
+			target:      "/api/users/7",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"ana","user":{"password":"a"},"sessions":[{"refresh_token":"b"}]}`,
+			status:      http.StatusOK,
+			want:        map[string]interface{}{"body": `{"name":"ana","sessions":[{"refresh_token":"[REDACTED]"}],"user":{"password":"[REDACTED]"}}`},
+		},
+		{
+			name:        "redacted form body",
+			config:      AccessLogConfig{LogBody: true, RedactFields: []string{"pin"}},
+			method:      http.MethodPost,
+			target:      "/api/users/7",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=ana&pin=1234&password=a",
+			status:      http.StatusOK,
+			want:        map[string]interface{}{"body": "name=ana&password=a&pin=%5BREDACTED%5D"},
+		},
+		{
+			name:        "truncated JSON body",
+			config:      AccessLogConfig{LogBody: true, MaxBodyBytes: 10},
+			method:      http.MethodPost,
+			target:      "/api/users/7",
+			contentType: "application/json",
+			body:        `{"name":"ana","password":"a"}`,
+			status:      http.StatusOK,
+			want:        map[string]interface{}{"body": "[10 bytes of unparsable JSON]"},
+		},
+		{
+			name:        "other bodies are not logged",
+			config:      AccessLogConfig{LogBody: true},
+			method:      http.MethodPost,
+			target:      "/api/users/7",
+			contentType: "text/plain",
+			body:        "password",
+			status:      http.StatusOK,
+			want:        map[string]interface{}{"body": nil},
+		},
+		{
+			name:   "skipped path",
+			config: AccessLogConfig{SkipPaths: []string{"/api/users/7"}},
+			target: "/api/users/7",
+			status: http.StatusOK,
+		},
+		{
+			name:   "sampled out",
+			config: AccessLogConfig{SampleRate: 1e-12},
+			target: "/api/users/7",
+			status: http.StatusOK,
+		},
+		{
+			name:   "client errors are always logged",
+			config: AccessLogConfig{SampleRate: 1e-12},
+			target: "/api/users/7",
+			status: http.StatusNotFound,
+			want:   map[string]interface{}{"level": "WARN", "status": float64(http.StatusNotFound)},
+		},
+		{
+			name:   "zero sample rate logs every request",
+			target: "/api/users/7",
+			status: http.StatusOK,
+			want:   map[string]interface{}{"status": float64(http.StatusOK)},
+		},
+		{
+			name:   "panic",
+			target: "/api/users/7",
+			want:   map[string]interface{}{"level": "ERROR", "status": float64(http.StatusInternalServerError), "errors": "boom"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			tt.config.Output = &output
+			engine := gin.New()
+			engine.Use(gin.RecoveryWithWriter(io.Discard), func(ctx *gin.Context) {
+				requestid.Set(ctx, "req-1")
+				audit.SetActor(ctx, audit.Actor{ID: "9"})
+			}, AccessLog(tt.config))
+			var handled string
+			engine.Any("/api/users/:id", func(ctx *gin.Context) {
+				if tt.status == 0 {
+					panic("boom")
+				}
+				read, _ := io.ReadAll(ctx.Request.Body)
+				handled = string(read)
+				ctx.String(tt.status, "ok")
+			})
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			request.RemoteAddr = "192.0.2.1:1234"
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+			engine.ServeHTTP(httptest.NewRecorder(), request)
+
+			if tt.status != 0 && handled != tt.body {
+				t.Fatalf("handler read the body %q, want %q", handled, tt.body)
+			}
+			if tt.want == nil {
+				if output.Len() != 0 {
+					t.Fatalf("logged %s, want no line", output.String())
+				}
+				return
+			}
+			var line map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &line); err != nil {
+				t.Fatalf("line %q is not JSON: %v", output.String(), err)
+			}
+			for key, want := range tt.want {
+				if got := line[key]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogLogfmt(t *testing.T) {
+	var output bytes.Buffer
+	engine := gin.New()
+	engine.Use(AccessLog(AccessLogConfig{Format: AccessLogLogfmt, Output: &output}))
+	engine.GET("/health", func(ctx *gin.Context) { ctx.Status(http.StatusNoContent) })
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	line := output.String()
+	for _, want := range []string{"level=INFO", "msg=access", "method=GET", "route=/health", "status=204"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %q lacks %s", line, want)
+		}
+	}
+}
+
+func TestAccessLogFromConfig(t *testing.T) {
+	invalid := func(key string) string {
+		return fmt.Sprintf(ErrInvalidHTTPConfig, "access_log."+key)
+	}
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		want     *AccessLogConfig
+		wantPath string
+		wantErr  string
+	}{
+		{name: "defaults", values: map[string]interface{}{}, want: &AccessLogConfig{SampleRate: 1, Output: os.Stdout}},
+		{name: "disabled", values: map[string]interface{}{"enable": false}},
+		{
+			name: "every setting",
+			values: map[string]interface{}{
+				"enable": true, "format": AccessLogLogfmt, "sample_rate": 0.25, "headers": []string{"Accept"},
+				"redact_headers": []string{"X-Tenant"}, "redact_fields": []string{"pin"}, "skip_paths": []string{"/health"},
+				"log_body": true, "max_body_bytes": 512, "output": "stderr",
+			},
+			want: &AccessLogConfig{
+				Format: AccessLogLogfmt, SampleRate: 0.25, Headers: []string{"Accept"}, RedactHeaders: []string{"X-Tenant"},
+				RedactFields: []string{"pin"}, SkipPaths: []string{"/health"}, LogBody: true, MaxBodyBytes: 512, Output: os.Stderr,
+			},
+		},
+		{name: "integer sample rate", values: map[string]interface{}{"sample_rate": 1}, want: &AccessLogConfig{SampleRate: 1, Output: os.Stdout}},
+		{name: "log file", values: map[string]interface{}{"output": "/var/log/access.log"}, want: &AccessLogConfig{SampleRate: 1}, wantPath: "/var/log/access.log"},
+		{name: "invalid enable", values: map[string]interface{}{"enable": "yes"}, wantErr: invalid("enable")},
+		{name: "invalid format", values: map[string]interface{}{"format": "text"}, wantErr: invalid("format")},
+		{name: "zero sample rate", values: map[string]interface{}{"sample_rate": 0}, wantErr: invalid("sample_rate")},
+		{name: "sample rate over 1", values: map[string]interface{}{"sample_rate": 1.5}, wantErr: invalid("sample_rate")},
+		{name: "sample rate not a number", values: map[string]interface{}{"sample_rate": "0.5"}, wantErr: invalid("sample_rate")},
+		{name: "invalid list", values: map[string]interface{}{"skip_paths": "/health"}, wantErr: invalid("skip_paths")},
+		{name: "invalid log body", values: map[string]interface{}{"log_body": 1}, wantErr: invalid("log_body")},
+		{name: "invalid max body bytes", values: map[string]interface{}{"max_body_bytes": 0}, wantErr: invalid("max_body_bytes")},
+		{name: "invalid output", values: map[string]interface{}{"output": 1}, wantErr: invalid("output")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, path, err := accessLogFromConfig(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr || got != nil {
+					t.Fatalf("accessLogFromConfig() = %+v, %v, want the error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || path != tt.wantPath {
+				t.Fatalf("accessLogFromConfig() = %+v, %q, want %+v, %q", got, path, tt.want, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	file, err := openAccessLog(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if _, err := openAccessLog(filepath.Join(dir, "missing", "access.log")); err == nil || !strings.HasPrefix(err.Error(), fmt.Sprintf(ErrInvalidHTTPConfig, "access_log.output")) {
+		t.Fatalf("openAccessLog() in a missing directory error = %v", err)
+	}
+}
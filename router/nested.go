@@ -0,0 +1,114 @@
+package router
+
+import (
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/color"
+	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
+)
+
+const (
+	// DefaultKeyParam is the path parameter of the key of a resource
+	DefaultKeyParam = "id"
+
+	// ParentKey is the context key of the parent of a nested resource
+	ParentKey = "capyvel.parent"
+	// KeyParamKey is the context key of the path parameter of the resource key
+	KeyParamKey = "capyvel.key.param"
+)
+
+// Parent is the parent of a nested resource in the current request, e.g. the order of
+// /orders/:orderId/items/:id
+type Parent struct {
+	Param  string // Path parameter of the parent key, e.g. "orderId"
+	Column string // Foreign key column of the parent in the child table, e.g. "order_id"
+	Value  string // Key of the parent in the request
+}
+
+// ContextParent returns the parent of the nested resource that matched the request
+func ContextParent(ctx *gin.Context) (Parent, bool) {
+	value, exists := ctx.Get(ParentKey)
+	if !exists {
+		return Parent{}, false
+	}
+	parent, ok := value.(Parent)
+	return parent, ok
+}
+
+// ContextKeyParam returns the path parameter of the resource key of the request, or "" when
+// the resource uses DefaultKeyParam
+func ContextKeyParam(ctx *gin.Context) string {
+	return ctx.GetString(KeyParamKey)
+}
+
+// Returns the group of the routes of option, nested under the key of its parents, along with
+// the middlewares of the parents, outermost first
+func (router *Router) group(option RouteOptions) (*gin.RouterGroup, []middlewareContract.Middleware) {
+	if option.GroupName == "" {
+		color.Redln(ErrGroupNameRequired)
+		os.Exit(1)
+	}
+	if option.Parent == nil {
+		r := RouterManager.defaultRoute
+		if option.BasePath != "" {
+			r = router.engine.Group(option.BasePath)
+		}
+		if option.Version != "" {
+			if _, ok := router.versions[option.Version]; !ok {
+				color.Redf(ErrVersionNotRegistered, option.Version)
+				os.Exit(1)
+			}
+			if !slices.Contains(router.versionBases, r.BasePath()) {
+				router.versionBases = append(router.versionBases, r.BasePath())
+			}
+			r = r.Group(option.Version)
+		}
+		r = r.Group(option.GroupName)
+		if option.KeyParam != "" {
+			r.Use(nestedScope(nil, option.KeyParam))
+		}
+		return r, nil
+	}
+
+	key := keyParam(option)
+	for parent := option.Parent; parent != nil; parent = parent.Parent {
+		if keyParam(*parent) == key {
+			color.Redf(ErrDuplicateKeyParam, key, option.GroupName)
+			os.Exit(1)
+		}
+	}
+	r, inherited := router.group(*option.Parent)
+	param := keyParam(*option.Parent)
+	r = r.Group("/:" + param + "/" + option.GroupName)
+	r.Use(nestedScope(&Parent{Param: param, Column: option.ParentColumn}, key))
+	return r, append(inherited, option.Parent.Middlewares...)
+}
+
+// Stores the parent and the key parameter of a nested resource in the context
+func nestedScope(parent *Parent, key string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if parent != nil {
+			ctx.Set(ParentKey, Parent{Param: parent.Param, Column: parent.Column, Value: ctx.Param(parent.Param)})
+		}
+		ctx.Set(KeyParamKey, key)
+		ctx.Next()
+	}
+}
+
+// Returns the path parameter of the resource key
+func keyParam(option RouteOptions) string {
+	if option.KeyParam == "" {
+		return DefaultKeyParam
+	}
+	return option.KeyParam
+}
+
+// Returns the options of the root resource of a nested resource
+func rootOptions(option RouteOptions) RouteOptions {
+	for option.Parent != nil {
+		option = *option.Parent
+	}
+	return option
+}
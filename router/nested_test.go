@@ -0,0 +1,111 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
+	routerContract "github.com/nd-tools/capyvel/contracts/router"
+)
+
+// Controller whose Show responds with the parent and the key parameter of the request
+type scopeController struct {
+	resourceController
+}
+
+func (scopeController) Show(ctx *gin.Context) {
+	parent, ok := ContextParent(ctx)
+	ctx.String(http.StatusOK, fmt.Sprintf("parent=%v %s,%s,%s key=%s", ok, parent.Param, parent.Column, parent.Value, ContextKeyParam(ctx)))
+}
+
+func TestNestedResource(t *testing.T) {
+	orders := &RouteOptions{GroupName: "orders", KeyParam: "orderId"}
+	items := &RouteOptions{GroupName: "items", KeyParam: "itemId", Parent: orders, ParentColumn: "order_id"}
+	show := &routerContract.Resource{Show: true}
+	tests := []struct {
+		name     string
+		option   RouteOptions
+		wantPath string
+		target   string
+		wantBody string
+	}{
+		{
+			name:     "root resource",
+			option:   RouteOptions{GroupName: "orders"},
+			wantPath: "/api/orders/:id",
+			target:   "/api/orders/7",
+			wantBody: "parent=false ,, key=",
+		},
+		{
+			name:     "root resource with a key parameter",
+			option:   RouteOptions{GroupName: "orders", KeyParam: "orderId"},
+			wantPath: "/api/orders/:orderId",
+			target:   "/api/orders/7",
+			wantBody: "parent=false ,, key=orderId",
+		},
+		{
+			name:     "nested resource",
+			option:   RouteOptions{GroupName: "items", Parent: orders, ParentColumn: "order_id"},
+			wantPath: "/api/orders/:orderId/items/:id",
+			target:   "/api/orders/7/items/9",
+			wantBody: "parent=true orderId,order_id,7 key=id",
+		},
+		{
+			name:     "parent with the default key parameter",
+			option:   RouteOptions{GroupName: "items", KeyParam: "itemId", Parent: &RouteOptions{GroupName: "orders"}, ParentColumn: "order_id"},
+			wantPath: "/api/orders/:id/items/:itemId",
+			target:   "/api/orders/7/items/9",
+			wantBody: "parent=true id,order_id,7 key=itemId",
+		},
+		{
+			name:     "nested twice",
+			option:   RouteOptions{GroupName: "notes", Parent: items, ParentColumn: "item_id"},
+			wantPath: "/api/orders/:orderId/items/:itemId/notes/:id",
+			target:   "/api/orders/7/items/9/notes/3",
+			wantBody: "parent=true itemId,item_id,9 key=id",
+		},
+		{
+			name:     "nested without a parent column",
+			option:   RouteOptions{GroupName: "items", Parent: orders},
+			wantPath: "/api/orders/:orderId/items/:id",
+			target:   "/api/orders/7/items/9",
+			wantBody: "parent=true orderId,,7 key=id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			tt.option.Resource = show
+			router.RegisterResource(tt.option, scopeController{})
+			if got := routeKeys(router.Routes()); !slices.Equal(got, []string{"GET " + tt.wantPath}) {
+				t.Fatalf("routes = %q, want GET %s", got, tt.wantPath)
+			}
+			recorder := serve(router, http.MethodGet, tt.target)
+			if recorder.Code != http.StatusOK || recorder.Body.String() != tt.wantBody {
+				t.Fatalf("GET %s = %d %q, want %q", tt.target, recorder.Code, recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNestedResourceMiddlewares(t *testing.T) {
+	router := newTestRouter(t)
+	router.RegisterDefaultsMiddlewares([]middlewareContract.Middleware{authMiddleware{}})
+	orders := &RouteOptions{GroupName: "orders", KeyParam: "orderId", Middlewares: []middlewareContract.Middleware{auditMiddleware{}}}
+	router.RegisterResource(RouteOptions{
+		GroupName:   "items",
+		Parent:      orders,
+		Middlewares: []middlewareContract.Middleware{cacheMiddleware{}},
+		Resource:    &routerContract.Resource{Show: true},
+	}, resourceController{})
+
+	// The middlewares of the parents run before the ones of the resource
+	want := []string{"router.authMiddleware", "router.auditMiddleware", "router.cacheMiddleware"}
+	routes := router.Routes()
+	if len(routes) != 1 || !slices.Equal(routes[0].Middlewares, want) {
+		t.Fatalf("routes = %+v, want the middlewares %q", routes, want)
+	}
+}
@@ -1,18 +1,26 @@
 package router
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/configuration"
 	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
 	routerContract "github.com/nd-tools/capyvel/contracts/router"
 	"github.com/nd-tools/capyvel/foundation"
+	"github.com/nd-tools/capyvel/middlewares"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -20,10 +28,12 @@ import (
 
 const (
 	DefaultGroupPath                   = "/api"
+	MethodAny                          = "ANY" // Registers the route for every HTTP method
 	ErrInvalidTimezone                 = "Invalid app.timezone configuration: %v"
 	ErrPrefixRequired                  = "Prefix name is required %s"
 	ErrGroupNameRequired               = "Group name is required"
 	ErrIncorrectHTTPMethod             = "Invalid HTTP method: %s"
+	ErrControllerMissingMethod         = "Controller %T does not implement %s for the %s route\n"
 	ErrPortMisconfigured               = "HTTP port is misconfigured"
 	ErrTLSConfigError                  = "Error in TLS configuration"
 	ErrTLSCertPathNotFound             = "TLS certificate path not found"
@@ -32,15 +42,30 @@ const (
 	ErrInvalidTimezoneConfig           = "Invalid timezone configuration: %v"
 	ErrMissingOrInvalidAppEnv          = "App environment configuration is invalid or missing"
 	ErrMissingOrInvalidDebugConfig     = "Debug configuration is invalid or missing"
+	ErrMissingOrInvalidAppURL          = "app.url configuration is invalid"
 	ErrMissingOrInvalidCORSMethods     = "CORS allowed methods configuration is invalid or missing"
 	ErrMissingOrInvalidCORSOrigins     = "CORS allowed origins configuration is invalid or missing"
 	ErrMissingOrInvalidCORSHeaders     = "CORS allowed headers configuration is invalid or missing"
 	ErrMissingOrInvalidCORSCredentials = "CORS supports credentials configuration is invalid or missing"
+	ErrInvalidHTTPConfig               = "Invalid http.%s configuration"
+	ErrServerFailed                    = "HTTP server failed: %w"
+	ErrServerShutdown                  = "HTTP server did not shut down gracefully: %w"
+	ErrShutdownHook                    = "Shutdown hook %s failed: %w"
+	ErrDuplicateRouteName              = "Route name %s is already used by %s\n"
+	ErrRouteNotDefined                 = "Route %s is not defined"
+	ErrRouteParamMissing               = "Route %s requires the %s parameter"
+	ErrInvalidVersion                  = "Invalid API version name %q\n"
+	ErrInvalidVersionFallback          = "Fallback chain of API version %s reaches %s, which is not registered or loops\n"
+	ErrVersionNotRegistered            = "API version %s is not registered\n"
+	ErrDuplicateKeyParam               = "Key parameter %s of %s is already used by a parent resource\n"
 )
 
 // RouterManager is the global router manager instance.
 var (
 	RouterManager Router
+
+	// HTTP methods accepted by gin: a token of uppercase letters
+	validHTTPMethod = regexp.MustCompile(`^[A-Z]+$`)
 )
 
 // Router manages the Gin engine, default group, and middleware stack.
@@ -48,59 +73,89 @@ type Router struct {
 	engine       *gin.Engine                     // The Gin engine instance
 	defaultRoute *gin.RouterGroup                // Default API route group
 	middlewares  []middlewareContract.Middleware // List of registered middlewares
+	hooks        []shutdownHook                  // Hooks run after the server is drained
+	routes       []RouteInfo                     // Registered routes, see Routes
+	names        map[string]int                  // Index in routes of each named route
+	baseURL      string                          // app.url, origin of the absolute URLs
+	versions     map[string]Version              // Registered API versions by name
+	versioning   VersioningConfig                // How requests select their version
+	versionBases []string                        // Group paths holding versioned routes, e.g. /api
+	global       []string                        // Names of the middlewares used by the engine
+	debug        bool                            // Whether app.debug is enabled
+	accessLog    *os.File                        // File of the access log, closed on shutdown or by the next boot
 }
 
 // RouteOptions defines configuration options for registering routes.
 type RouteOptions struct {
 	BasePath                  string                          // Base path for the route group
 	GroupName                 string                          // Name of the route group
+	Name                      string                          // Prefix of the route names, e.g. "users" names "users.index", "users.show"...
 	DontUseDefaultMiddlewares bool                            // Whether to skip default middlewares
 	Middlewares               []middlewareContract.Middleware // Middlewares specific to this route
 	Resource                  *routerContract.Resource        // Resource configuration for CRUD endpoints
+	KeyParam                  string                          // Path parameter of the resource key, DefaultKeyParam when empty
+	Parent                    *RouteOptions                   // Parent resource, the routes are nested under its /:KeyParam path
+	ParentColumn              string                          // Foreign key column of the parent, scopes the Orm List and Get queries
+	Version                   string                          // API version of the routes, see RegisterVersions; nested resources use the one of their root
+	Deprecated                *Deprecation                    // Marks the routes as deprecated
 }
 
 // RouteOptionFunction defines a single function route configuration.
 type RouteOptionFunction struct {
 	PrefixName                string                          // Prefix for the route path
+	Name                      string                          // Name of the route for URL generation, prefixed with RouteOptions.Name
 	DontUseDefaultMiddlewares bool                            // Whether to skip default middlewares
-	HttpMethod                string                          // HTTP method (GET, POST, etc.)
+	HttpMethod                string                          // HTTP method (GET, POST, etc.), or MethodAny
+	HttpMethods               []string                        // Additional HTTP methods for the same handler
 	Function                  func(*gin.Context)              // Function handler for the route
 	Middlewares               []middlewareContract.Middleware // Middlewares specific to this route
+	Deprecated                *Deprecation                    // Marks the route as deprecated, overriding RouteOptions.Deprecated
 }
 
-// Boot initializes the router, CORS, and app configuration.
+// Boot initializes the router, CORS, and app configuration, exiting the process on error.
 func Boot() {
-	// Set the timezone for the application
+	if err := BootE(); err != nil {
+		color.Redln(err)
+		os.Exit(1)
+	}
+}
+
+// BootE initializes the router, CORS, and app configuration.
+// Every configuration problem is reported at once in a *configuration.Errors.
+// Booting again starts from a new router, without the routes, middlewares, versions and
+// shutdown hooks registered before.
+func BootE() error {
+	problems := configuration.NewErrors("router")
+	problems.Add(foundation.App.Err())
+
+	// Timezone of the application, applied once the configuration is valid
+	var location *time.Location
 	if name, ok := foundation.App.Config.Get("app.timezone", "America/Mexico_City").(string); ok {
-		location, err := time.LoadLocation(name)
-		if err != nil {
-			color.Redf(ErrInvalidTimezoneConfig, err)
-			os.Exit(1)
+		var err error
+		if location, err = time.LoadLocation(name); err != nil {
+			problems.Add(fmt.Errorf(ErrInvalidTimezoneConfig, err))
 		}
-		time.Local = location
 	} else {
-		color.Redln(ErrMissingOrInvalidTimezone)
-		os.Exit(1)
+		problems.Add(errors.New(ErrMissingOrInvalidTimezone))
 	}
 
 	// Set the Gin mode based on the app environment
-	if mode, ok := foundation.App.Config.Get("app.env", "dev").(string); ok && strings.EqualFold(mode, "release") {
-		gin.SetMode(gin.ReleaseMode)
-	} else if !ok {
-		color.Redln(ErrMissingOrInvalidAppEnv)
-		os.Exit(1)
+	mode, ok := foundation.App.Config.Get("app.env", "dev").(string)
+	if !ok {
+		problems.Add(errors.New(ErrMissingOrInvalidAppEnv))
 	}
 
-	// Create a new Gin engine
-	router := gin.New()
+	debug, ok := foundation.App.Config.Get("app.debug", true).(bool)
+	if !ok {
+		problems.Add(errors.New(ErrMissingOrInvalidDebugConfig))
+	}
 
-	// Enable recovery middleware in debug mode
-	if debug, ok := foundation.App.Config.Get("app.debug", true).(bool); ok && debug {
-		router.Use(gin.Logger())
-		router.Use(gin.Recovery())
-	} else if !ok {
-		color.Redln(ErrMissingOrInvalidDebugConfig)
-		os.Exit(1)
+	// Origin of the absolute URLs, taken from the request when empty
+	var baseURL string
+	if value := foundation.App.Config.Get("app.url", ""); value != nil {
+		if baseURL, ok = value.(string); !ok {
+			problems.Add(errors.New(ErrMissingOrInvalidAppURL))
+		}
 	}
 
 	// Configure CORS settings
@@ -108,35 +163,94 @@ func Boot() {
 	if methods, ok := foundation.App.Config.Get("cors.allowed_methods", []string{"*"}).([]string); ok {
 		config.AllowMethods = methods
 	} else {
-		color.Redln(ErrMissingOrInvalidCORSMethods)
-		os.Exit(1)
+		problems.Add(errors.New(ErrMissingOrInvalidCORSMethods))
 	}
 
 	if origins, ok := foundation.App.Config.Get("cors.allowed_origins", []string{"*"}).([]string); ok {
 		config.AllowOrigins = origins
 	} else {
-		color.Redln(ErrMissingOrInvalidCORSOrigins)
-		os.Exit(1)
+		problems.Add(errors.New(ErrMissingOrInvalidCORSOrigins))
 	}
 
 	if headers, ok := foundation.App.Config.Get("cors.allowed_headers", []string{"*"}).([]string); ok {
 		config.AllowHeaders = headers
 	} else {
-		color.Redln(ErrMissingOrInvalidCORSHeaders)
-		os.Exit(1)
+		problems.Add(errors.New(ErrMissingOrInvalidCORSHeaders))
 	}
 
 	if credentials, ok := foundation.App.Config.Get("cors.supports_credentials", false).(bool); ok {
 		config.AllowCredentials = credentials
 	} else {
-		color.Redln(ErrMissingOrInvalidCORSCredentials)
-		os.Exit(1)
+		problems.Add(errors.New(ErrMissingOrInvalidCORSCredentials))
+	}
+
+	// Access log, written whatever the debug mode
+	httpValues, _ := foundation.App.Config.Get("http", nil).(map[string]interface{})
+	accessLogValues, _ := httpValues["access_log"].(map[string]interface{})
+	accessLogConfig, accessLogPath, err := accessLogFromConfig(accessLogValues)
+	problems.Add(err)
+
+	if err := problems.Err(); err != nil {
+		return err
+	}
+	var accessLogFile *os.File
+	if accessLogPath != "" {
+		if accessLogFile, err = openAccessLog(accessLogPath); err != nil {
+			return err
+		}
+		accessLogConfig.Output = accessLogFile
+	}
+
+	// Start from a new router, closing the access log of a previous boot
+	RouterManager.reset()
+	if accessLogFile != nil {
+		RouterManager.accessLog = accessLogFile
+		RouterManager.OnShutdown("access log", func(ctx context.Context) error {
+			return accessLogFile.Close()
+		})
+	}
+
+	time.Local = location
+	if strings.EqualFold(mode, "release") {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Create a new Gin engine
+	router := gin.New()
+
+	// Identify every request before it is logged
+	router.Use(middlewares.RequestID{}.Middleware)
+	global := []string{"middlewares.RequestID"}
+
+	if accessLogConfig != nil {
+		router.Use(AccessLog(*accessLogConfig))
+		global = append(global, "router.AccessLog")
+	}
+
+	// Enable recovery middleware in debug mode
+	if debug {
+		router.Use(gin.Recovery())
+		global = append(global, "gin.Recovery")
 	}
 
 	router.Use(cors.New(config))
+	global = append(global, "cors")
 
 	RouterManager.engine = router
+	RouterManager.global = global
+	RouterManager.debug = debug
+	RouterManager.names = map[string]int{}
+	RouterManager.baseURL = strings.TrimRight(baseURL, "/")
 	RouterManager.defaultRoute = RouterManager.engine.Group(DefaultGroupPath)
+	return nil
+}
+
+// Clears the state of a previous boot, closing its access log
+func (router *Router) reset() {
+	if router.accessLog != nil {
+		router.accessLog.Close()
+	}
+	*router = Router{}
 }
 
 // RegisterDefaultsMiddlewares registers a list of default middlewares.
@@ -146,66 +260,119 @@ func (router *Router) RegisterDefaultsMiddlewares(middlewares []middlewareContra
 
 // RegisterResource registers a set of CRUD routes for a resource controller.
 func (router *Router) RegisterResource(option RouteOptions, controller routerContract.ResourceController) {
-	r := RouterManager.defaultRoute
-	if option.BasePath != "" {
-		r = router.engine.Group(option.BasePath)
-	}
-	if option.GroupName == "" {
-		color.Redln(ErrGroupNameRequired)
-		os.Exit(1)
+	r, inherited := router.group(option)
+	item := "/:" + keyParam(option)
+	if option.Deprecated != nil {
+		r.Use(deprecationHeaders(option.Deprecated))
 	}
 
-	r = r.Group(option.GroupName)
-
+	var chain []string
 	if !option.DontUseDefaultMiddlewares {
 		for _, middleware := range router.middlewares {
 			r.Use(middleware.Middleware)
 		}
+		chain = middlewareNames(router.middlewares)
 	}
 
-	for _, middleware := range option.Middlewares {
+	for _, middleware := range append(inherited, option.Middlewares...) {
 		r.Use(middleware.Middleware)
 	}
+	chain = append(chain, middlewareNames(inherited, option.Middlewares)...)
+
+	handle := func(method, relativePath, action string, handler gin.HandlerFunc) {
+		var name string
+		if option.Name != "" {
+			name = option.Name + "." + action
+		}
+		router.addRoute(r, RouteInfo{
+			Name:        name,
+			Method:      method,
+			Path:        relativePath,
+			Handler:     controllerMethodName(controller, handler),
+			Group:       option.GroupName,
+			Version:     rootOptions(option).Version,
+			Deprecated:  option.Deprecated != nil,
+			Middlewares: chain,
+		}, handler)
+	}
 
 	if option.Resource == nil {
-		r.GET("/", controller.Index)
-		r.POST("/", controller.Store)
-		r.GET("/:id", controller.Show)
-		r.PUT("/:id", controller.Update)
-		r.DELETE("/:id", controller.Destroy)
+		handle(http.MethodGet, "/", "index", controller.Index)
+		handle(http.MethodPost, "/", "store", controller.Store)
+		handle(http.MethodGet, item, "show", controller.Show)
+		handle(http.MethodPut, item, "update", controller.Update)
+		handle(http.MethodDelete, item, "destroy", controller.Destroy)
+		if patcher, ok := controller.(routerContract.Patcher); ok {
+			handle(http.MethodPatch, item, "patch", patcher.Patch)
+		}
 	} else {
 		if option.Resource.Index {
-			r.GET("/", controller.Index)
+			handle(http.MethodGet, "/", "index", controller.Index)
 		}
 		if option.Resource.Store {
-			r.POST("/", controller.Store)
+			handle(http.MethodPost, "/", "store", controller.Store)
 		}
 		if option.Resource.Show {
-			r.GET("/:id", controller.Show)
+			handle(http.MethodGet, item, "show", controller.Show)
 		}
 		if option.Resource.Update {
-			r.PUT("/:id", controller.Update)
+			handle(http.MethodPut, item, "update", controller.Update)
 		}
 		if option.Resource.Destroy {
-			r.DELETE("/:id", controller.Destroy)
+			handle(http.MethodDelete, item, "destroy", controller.Destroy)
+		}
+		if option.Resource.Patch {
+			patcher, ok := controller.(routerContract.Patcher)
+			if !ok {
+				color.Redf(ErrControllerMissingMethod, controller, "Patch", "PATCH /:id")
+				os.Exit(1)
+			}
+			handle(http.MethodPatch, item, "patch", patcher.Patch)
+		}
+		if option.Resource.Trashed {
+			trashed, ok := controller.(routerContract.TrashedController)
+			if !ok {
+				color.Redf(ErrControllerMissingMethod, controller, "Trashed", "GET /trashed")
+				os.Exit(1)
+			}
+			handle(http.MethodGet, "/trashed", "trashed", trashed.Trashed)
+		}
+		if option.Resource.Restore {
+			restore, ok := controller.(routerContract.RestoreController)
+			if !ok {
+				color.Redf(ErrControllerMissingMethod, controller, "Restore", "POST /:id/restore")
+				os.Exit(1)
+			}
+			handle(http.MethodPost, item+"/restore", "restore", restore.Restore)
+		}
+		if option.Resource.ForceDelete {
+			forceDelete, ok := controller.(routerContract.ForceDeleteController)
+			if !ok {
+				color.Redf(ErrControllerMissingMethod, controller, "ForceDelete", "DELETE /:id/force")
+				os.Exit(1)
+			}
+			handle(http.MethodDelete, item+"/force", "forceDelete", forceDelete.ForceDelete)
+		}
+		if option.Resource.History {
+			history, ok := controller.(routerContract.HistoryController)
+			if !ok {
+				color.Redf(ErrControllerMissingMethod, controller, "History", "GET /:id/history")
+				os.Exit(1)
+			}
+			handle(http.MethodGet, item+"/history", "history", history.History)
 		}
 	}
 }
 
 // RegisterFunctions registers custom routes with specific handlers and HTTP methods.
 func (router *Router) RegisterFunctions(option RouteOptions, optionsFunctions []RouteOptionFunction) {
-	r := RouterManager.defaultRoute
-	if option.BasePath != "" {
-		r = router.engine.Group(option.BasePath)
-	}
-	if option.GroupName == "" {
-		color.Redln(ErrGroupNameRequired)
-		os.Exit(1)
-	}
-	r = r.Group(option.GroupName)
+	r, inherited := router.group(option)
 
 	for _, optionFunction := range optionsFunctions {
-		httpMethod := optionFunction.HttpMethod
+		httpMethods := optionFunction.HttpMethods
+		if optionFunction.HttpMethod != "" {
+			httpMethods = append([]string{optionFunction.HttpMethod}, httpMethods...)
+		}
 		function := optionFunction.Function
 		prefixName := optionFunction.PrefixName
 
@@ -215,67 +382,121 @@ func (router *Router) RegisterFunctions(option RouteOptions, optionsFunctions []
 		}
 		fullPath := "/" + prefixName
 		middlewares := []gin.HandlerFunc{}
+		deprecated := option.Deprecated
+		if optionFunction.Deprecated != nil {
+			deprecated = optionFunction.Deprecated
+		}
+		if deprecated != nil {
+			middlewares = append(middlewares, deprecationHeaders(deprecated))
+		}
+		var chain []string
 		if !option.DontUseDefaultMiddlewares && !optionFunction.DontUseDefaultMiddlewares {
 			for _, middleware := range router.middlewares {
 				middlewares = append(middlewares, middleware.Middleware)
 			}
-			for _, middleware := range option.Middlewares {
+			for _, middleware := range append(inherited, option.Middlewares...) {
 				middlewares = append(middlewares, middleware.Middleware)
 			}
+			chain = middlewareNames(router.middlewares, inherited, option.Middlewares)
 		}
 		for _, middleware := range optionFunction.Middlewares {
 			middlewares = append(middlewares, middleware.Middleware)
 		}
-		switch httpMethod {
-		case http.MethodGet:
-			r.GET(fullPath, append(middlewares, function)...)
-		case http.MethodPost:
-			r.POST(fullPath, append(middlewares, function)...)
-		case http.MethodPut:
-			r.PUT(fullPath, append(middlewares, function)...)
-		case http.MethodDelete:
-			r.DELETE(fullPath, append(middlewares, function)...)
-		case http.MethodOptions:
-			r.OPTIONS(fullPath, append(middlewares, function)...)
-		default:
-			color.Redf(ErrIncorrectHTTPMethod, httpMethod)
+		chain = append(chain, middlewareNames(optionFunction.Middlewares)...)
+		if len(httpMethods) == 0 {
+			color.Redf(ErrIncorrectHTTPMethod, "")
 			os.Exit(1)
 		}
+		for _, httpMethod := range httpMethods {
+			httpMethod = strings.ToUpper(httpMethod)
+			// Standard methods (GET, POST, PUT, PATCH, DELETE, HEAD, OPTIONS...), custom tokens and MethodAny
+			if !validHTTPMethod.MatchString(httpMethod) {
+				color.Redf(ErrIncorrectHTTPMethod, httpMethod)
+				os.Exit(1)
+			}
+			router.addRoute(r, RouteInfo{
+				Name:        routeName(option.Name, optionFunction.Name),
+				Method:      httpMethod,
+				Path:        fullPath,
+				Handler:     getFunctionName(function),
+				Group:       option.GroupName,
+				Version:     rootOptions(option).Version,
+				Deprecated:  deprecated != nil,
+				Middlewares: chain,
+			}, append(middlewares, function)...)
+		}
 	}
 }
 
-// Run starts the Gin server on the configured port with optional TLS.
+// Run starts the HTTP server and blocks until SIGINT or SIGTERM, draining the in-flight
+// requests before returning; it prints the error and exits the process on failure.
 func (router *Router) Run() *gin.Engine {
-	config := foundation.App.Config
-	port, ok := config.Get("http.port", 8080).(int)
-	if !ok {
-		color.Redln(ErrPortMisconfigured)
+	if err := router.RunE(); err != nil {
+		color.Redln(err)
 		os.Exit(1)
 	}
-	addr := fmt.Sprintf(":%d", port)
-	runtls, ok := config.Get("http.tls.enable", false).(bool)
-	if !ok {
-		color.Redln(ErrTLSConfigError)
-		os.Exit(1)
+	return RouterManager.engine
+}
+
+// RunE starts the HTTP server and blocks until SIGINT or SIGTERM, see RunContext.
+func (router *Router) RunE() error {
+	return router.RunContext(context.Background())
+}
+
+// RunContext starts the HTTP server built from the http.* config and blocks until the context
+// is done, SIGINT or SIGTERM is received or the server fails. On shutdown it waits up to
+// http.shutdown_timeout for the in-flight requests. The shutdown hooks run on every exit path,
+// including a server that cannot start.
+func (router *Router) RunContext(ctx context.Context) error {
+	server, tls, err := newServer(router.Handler())
+	var listener net.Listener
+	if err == nil {
+		if listener, err = net.Listen("tcp", server.Addr); err != nil {
+			err = fmt.Errorf(ErrServerFailed, err)
+		}
+	}
+	if err != nil {
+		hooksCtx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		return errors.Join(err, router.runShutdownHooks(hooksCtx))
 	}
 
-	if runtls {
-		certFile, ok := config.Get("http.tls.ssl.cert", "").(string)
-		if !ok {
-			color.Redln(ErrTLSCertPathNotFound)
-			os.Exit(1)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		var err error
+		if tls != nil {
+			err = server.ServeTLS(listener, tls.certFile, tls.keyFile)
+		} else {
+			err = server.Serve(listener)
 		}
+		serverErr <- err
+	}()
+	color.Greenf("Listening on %s\n", listener.Addr())
 
-		keyFile, ok := config.Get("http.tls.ssl.key", "").(string)
-		if !ok {
-			color.Redln(ErrTLSKeyPathNotFound)
-			os.Exit(1)
+	var errs []error
+	draining := false
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, fmt.Errorf(ErrServerFailed, err))
 		}
-		RouterManager.engine.RunTLS(addr, certFile, keyFile)
-	} else {
-		RouterManager.engine.Run(addr)
+	case <-ctx.Done():
+		stop()
+		color.Yellowf("Shutting down, draining connections for up to %s\n", server.shutdownTimeout)
+		draining = true
 	}
-	return RouterManager.engine
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout)
+	defer cancel()
+	if draining {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf(ErrServerShutdown, err))
+		}
+	}
+	errs = append(errs, router.runShutdownHooks(shutdownCtx))
+	return errors.Join(errs...)
 }
 
 // getFunctionName returns the name of a given function.
@@ -283,7 +504,8 @@ func getFunctionName(function interface{}) string {
 	ptr := reflect.ValueOf(function).Pointer()
 	funcInfo := runtime.FuncForPC(ptr)
 	if funcInfo != nil {
-		return funcInfo.Name()
+		// Method values are suffixed with -fm by the compiler
+		return strings.TrimSuffix(funcInfo.Name(), "-fm")
 	}
 	return "unknown"
 }
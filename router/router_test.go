@@ -0,0 +1,308 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nd-tools/capyvel/configuration"
+	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
+	routerContract "github.com/nd-tools/capyvel/contracts/router"
+	"github.com/nd-tools/capyvel/foundation"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// Replaces a top-level key of the application config for the duration of the test
+func setConfig(t *testing.T, name string, value any) {
+	t.Helper()
+	configurations := *foundation.App.Config.Configurations
+	previous, existed := configurations[name]
+	configurations[name] = value
+	t.Cleanup(func() {
+		if existed {
+			configurations[name] = previous
+		} else {
+			delete(configurations, name)
+		}
+	})
+}
+
+// Replaces RouterManager with a router without routes for the duration of the test
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+	previous := RouterManager
+	engine := gin.New()
+	RouterManager = Router{engine: engine, defaultRoute: engine.Group(DefaultGroupPath), names: map[string]int{}}
+	t.Cleanup(func() { RouterManager = previous })
+	return &RouterManager
+}
+
+// Serves a request with the router and returns the response
+func serve(router *Router, method, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.Handler().ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+// Handler responding with its name
+func respond(name string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, name)
+	}
+}
+
+type resourceController struct{}
+
+func (resourceController) Index(ctx *gin.Context)   { ctx.String(http.StatusOK, "index") }
+func (resourceController) Store(ctx *gin.Context)   { ctx.String(http.StatusOK, "store") }
+func (resourceController) Show(ctx *gin.Context)    { ctx.String(http.StatusOK, "show") }
+func (resourceController) Update(ctx *gin.Context)  { ctx.String(http.StatusOK, "update") }
+func (resourceController) Destroy(ctx *gin.Context) { ctx.String(http.StatusOK, "destroy") }
+
+// Controller implementing every optional route
+type fullController struct {
+	resourceController
+}
+
+func (fullController) Patch(ctx *gin.Context)       { ctx.String(http.StatusOK, "patch") }
+func (fullController) Restore(ctx *gin.Context)     { ctx.String(http.StatusOK, "restore") }
+func (fullController) Trashed(ctx *gin.Context)     { ctx.String(http.StatusOK, "trashed") }
+func (fullController) ForceDelete(ctx *gin.Context) { ctx.String(http.StatusOK, "forceDelete") }
+func (fullController) History(ctx *gin.Context)     { ctx.String(http.StatusOK, "history") }
+
+// Returns the method and path of the routes
+func routeKeys(routes []RouteInfo) []string {
+	keys := make([]string, len(routes))
+	for i, route := range routes {
+		keys[i] = route.Method + " " + route.Path
+	}
+	return keys
+}
+
+func TestRegisterResource(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller routerContract.ResourceController
+		resource   *routerContract.Resource
+		want       []string
+	}{
+		{
+			name:       "default routes",
+			controller: resourceController{},
+			want:       []string{"GET /api/items/", "POST /api/items/", "GET /api/items/:id", "PUT /api/items/:id", "DELETE /api/items/:id"},
+		},
+		{
+			name:       "patch of a patcher",
+			controller: fullController{},
+			want:       []string{"GET /api/items/", "POST /api/items/", "GET /api/items/:id", "PUT /api/items/:id", "DELETE /api/items/:id", "PATCH /api/items/:id"},
+		},
+		{
+			name:       "selected routes",
+			controller: fullController{},
+			resource:   &routerContract.Resource{Show: true, Patch: true},
+			want:       []string{"GET /api/items/:id", "PATCH /api/items/:id"},
+		},
+		{
+			name:       "optional routes",
+			controller: fullController{},
+			resource:   &routerContract.Resource{Index: true, Trashed: true, Restore: true, ForceDelete: true, History: true},
+			want:       []string{"GET /api/items/", "GET /api/items/trashed", "POST /api/items/:id/restore", "DELETE /api/items/:id/force", "GET /api/items/:id/history"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			router.RegisterResource(RouteOptions{GroupName: "items", Resource: tt.resource}, tt.controller)
+			routes := router.Routes()
+			if got := routeKeys(routes); !slices.Equal(got, tt.want) {
+				t.Fatalf("routes = %q, want %q", got, tt.want)
+			}
+			for _, route := range routes {
+				target := strings.NewReplacer(":id", "7").Replace(route.Path)
+				recorder := serve(router, route.Method, target)
+				action := route.Handler[strings.LastIndex(route.Handler, ".")+1:]
+				if recorder.Code != http.StatusOK || !strings.EqualFold(recorder.Body.String(), action) {
+					t.Errorf("%s %s = %d %q, want the %s action", route.Method, target, recorder.Code, recorder.Body.String(), action)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterFunctionsMethods(t *testing.T) {
+	tests := []struct {
+		name        string
+		function    RouteOptionFunction
+		wantRoutes  []string
+		wantServed  []string // Methods answered by the handler
+		wantMissing []string // Methods not routed
+	}{
+		{
+			name:        "patch in lowercase",
+			function:    RouteOptionFunction{PrefixName: "sync", HttpMethod: "patch"},
+			wantRoutes:  []string{"PATCH /api/jobs/sync"},
+			wantServed:  []string{http.MethodPatch},
+			wantMissing: []string{http.MethodGet},
+		},
+		{
+			name:        "head",
+			function:    RouteOptionFunction{PrefixName: "sync", HttpMethod: http.MethodHead},
+			wantRoutes:  []string{"HEAD /api/jobs/sync"},
+			wantServed:  []string{http.MethodHead},
+			wantMissing: []string{http.MethodGet},
+		},
+		{
+			name:        "custom token",
+			function:    RouteOptionFunction{PrefixName: "sync", HttpMethod: "PURGE"},
+			wantRoutes:  []string{"PURGE /api/jobs/sync"},
+			wantServed:  []string{"PURGE"},
+			wantMissing: []string{http.MethodPost},
+		},
+		{
+			name:       "any",
+			function:   RouteOptionFunction{PrefixName: "sync", HttpMethod: MethodAny},
+			wantRoutes: []string{"ANY /api/jobs/sync"},
+			wantServed: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions},
+		},
+		{
+			name:        "several methods",
+			function:    RouteOptionFunction{PrefixName: "sync", HttpMethod: http.MethodPost, HttpMethods: []string{"put", http.MethodPatch}},
+			wantRoutes:  []string{"POST /api/jobs/sync", "PUT /api/jobs/sync", "PATCH /api/jobs/sync"},
+			wantServed:  []string{http.MethodPost, http.MethodPut, http.MethodPatch},
+			wantMissing: []string{http.MethodGet, http.MethodDelete},
+		},
+		{
+			name:       "methods without the main one",
+			function:   RouteOptionFunction{PrefixName: "sync", HttpMethods: []string{http.MethodGet, http.MethodHead}},
+			wantRoutes: []string{"GET /api/jobs/sync", "HEAD /api/jobs/sync"},
+			wantServed: []string{http.MethodGet, http.MethodHead},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			tt.function.Function = respond("sync")
+			router.RegisterFunctions(RouteOptions{GroupName: "jobs"}, []RouteOptionFunction{tt.function})
+			if got := routeKeys(router.Routes()); !slices.Equal(got, tt.wantRoutes) {
+				t.Fatalf("routes = %q, want %q", got, tt.wantRoutes)
+			}
+			for _, method := range tt.wantServed {
+				if code := serve(router, method, "/api/jobs/sync").Code; code != http.StatusOK {
+					t.Errorf("%s = %d, want 200", method, code)
+				}
+			}
+			for _, method := range tt.wantMissing {
+				if code := serve(router, method, "/api/jobs/sync").Code; code != http.StatusNotFound {
+					t.Errorf("%s = %d, want 404", method, code)
+				}
+			}
+		})
+	}
+}
+
+// Clears the env loading error of the test binary, which has no .env, so that BootE runs
+func useBootableApp(t *testing.T) {
+	t.Helper()
+	previous := foundation.App
+	foundation.App = foundation.Application{Config: previous.Config}
+	t.Cleanup(func() { foundation.App = previous })
+}
+
+func TestBootEReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name     string
+		bootable bool // Whether the env error of the test binary is cleared
+		want     []string
+	}{
+		{
+			name:     "every setting",
+			bootable: true,
+			want:     []string{"Invalid timezone configuration", ErrMissingOrInvalidAppEnv, ErrMissingOrInvalidCORSOrigins, fmt.Sprintf(ErrInvalidHTTPConfig, "access_log.format")},
+		},
+		{
+			name: "env error along with the settings",
+			want: []string{ErrMissingOrInvalidAppEnv, ErrMissingOrInvalidCORSOrigins},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestRouter(t).engine
+			if tt.bootable {
+				useBootableApp(t)
+			} else if foundation.App.Err() == nil {
+				t.Skip("the test binary loaded an env file")
+			}
+			setConfig(t, "app", map[string]interface{}{"timezone": "Mars/Olympus", "env": 1})
+			setConfig(t, "cors", map[string]interface{}{"allowed_origins": "*"})
+			setConfig(t, "http", map[string]interface{}{"access_log": map[string]interface{}{"format": "text"}})
+
+			err := BootE()
+			var problems *configuration.Errors
+			if !errors.As(err, &problems) {
+				t.Fatalf("BootE() error = %v, want a *configuration.Errors", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("BootE() error = %q, want it to report %q", err, want)
+				}
+			}
+			if !tt.bootable && !errors.Is(err, foundation.App.Err()) {
+				t.Errorf("BootE() error = %v, want the env error", err)
+			}
+			if RouterManager.engine != engine {
+				t.Fatal("BootE() applied an invalid configuration")
+			}
+		})
+	}
+}
+
+func TestBootEAgainStartsFromANewRouter(t *testing.T) {
+	newTestRouter(t)
+	useBootableApp(t)
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	output := filepath.Join(t.TempDir(), "access.log")
+	setConfig(t, "app", map[string]interface{}{"timezone": "UTC", "env": "dev", "debug": false})
+	setConfig(t, "http", map[string]interface{}{"access_log": map[string]interface{}{"output": output}})
+
+	if err := BootE(); err != nil {
+		t.Fatalf("first BootE() error = %v", err)
+	}
+	first := RouterManager.accessLog
+	RouterManager.RegisterDefaultsMiddlewares([]middlewareContract.Middleware{authMiddleware{}})
+	RouterManager.RegisterVersions(VersioningConfig{Versions: []Version{{Name: "v1"}}})
+	RouterManager.OnShutdown("database", func(context.Context) error { return nil })
+	RouterManager.RegisterFunctions(RouteOptions{GroupName: "ping"}, []RouteOptionFunction{
+		{PrefixName: "ping", HttpMethod: http.MethodGet, Function: func(*gin.Context) {}},
+	})
+
+	if err := BootE(); err != nil {
+		t.Fatalf("second BootE() error = %v", err)
+	}
+	t.Cleanup(func() { RouterManager.accessLog.Close() })
+	if _, err := first.WriteString("line\n"); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("access log of the first boot is open: write error = %v", err)
+	}
+	if len(RouterManager.middlewares) != 0 || len(RouterManager.versions) != 0 || len(RouterManager.routes) != 0 {
+		t.Errorf("router kept middlewares %v, versions %v and routes %v", RouterManager.middlewares, RouterManager.versions, RouterManager.routes)
+	}
+	if len(RouterManager.hooks) != 1 || RouterManager.hooks[0].name != "access log" {
+		t.Errorf("hooks = %+v, want the access log of the second boot", RouterManager.hooks)
+	}
+	if code := serve(&RouterManager, http.MethodGet, "/api/ping/ping").Code; code != http.StatusNotFound {
+		t.Errorf("route of the first boot answered %d", code)
+	}
+}
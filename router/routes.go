@@ -0,0 +1,141 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/color"
+	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
+	"github.com/nd-tools/capyvel/responses"
+)
+
+// RouteInfo describes a registered route with its middleware chain
+type RouteInfo struct {
+	Name        string   `json:"name,omitempty"`       // Name of the route for URL generation
+	Method      string   `json:"method"`               // HTTP method, or MethodAny
+	Path        string   `json:"path"`                 // Full path, including the base path and the group
+	Handler     string   `json:"handler"`              // Name of the handler function
+	Group       string   `json:"group"`                // Group name of the RouteOptions
+	Version     string   `json:"version,omitempty"`    // API version, the name of the route is prefixed with it
+	Deprecated  bool     `json:"deprecated,omitempty"` // Whether the route emits the deprecation headers
+	Middlewares []string `json:"middlewares"`          // Middlewares run before the handler, global ones first
+}
+
+// Routes returns the registered routes in registration order
+func (router *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(router.routes))
+	for i, route := range router.routes {
+		route.Middlewares = append([]string(nil), route.Middlewares...)
+		routes[i] = route
+	}
+	return routes
+}
+
+// PrintRoutes writes the route table to w, one route per line
+func (router *Router) PrintRoutes(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "METHOD\tPATH\tNAME\tHANDLER\tGROUP\tMIDDLEWARES")
+	for _, route := range router.routes {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", route.Method, route.Path, route.Name, route.Handler, route.Group, strings.Join(route.Middlewares, ", "))
+	}
+	return table.Flush()
+}
+
+// RegisterRoutesEndpoint exposes the route table as JSON on GET path when app.debug is enabled.
+// It does nothing outside debug mode, so the route table is never published in production.
+func (router *Router) RegisterRoutesEndpoint(relativePath string) {
+	if !router.debug {
+		color.Yellowf("Routes endpoint %s is only registered in debug mode\n", relativePath)
+		return
+	}
+	handler := func(ctx *gin.Context) {
+		routes := router.Routes()
+		ctx.JSON(http.StatusOK, responses.Api{
+			TotalRows: int64(len(routes)),
+			Data:      routes,
+			Message:   "registered routes",
+			Status:    http.StatusOK,
+			Success:   true,
+		})
+	}
+	router.addRoute(router.engine.Group("/"), RouteInfo{
+		Method:  http.MethodGet,
+		Path:    relativePath,
+		Handler: "router.RoutesEndpoint",
+	}, handler)
+}
+
+// Returns the name of a controller method, using the concrete type of the controller
+// instead of the interface the method value was taken from
+func controllerMethodName(controller any, handler gin.HandlerFunc) string {
+	name := getFunctionName(handler)
+	return fmt.Sprintf("%T.%s", controller, name[strings.LastIndex(name, ".")+1:])
+}
+
+// Registers a handler on the group and records it in the route table. The path of the route
+// is relative to the group and its middlewares exclude the global ones, which are added here.
+func (router *Router) addRoute(group *gin.RouterGroup, route RouteInfo, handlers ...gin.HandlerFunc) {
+	relativePath := route.Path
+	route.Path = joinPaths(group.BasePath(), relativePath)
+	route.Middlewares = append(append([]string(nil), router.global...), route.Middlewares...)
+	if route.Name != "" && route.Version != "" {
+		route.Name = route.Version + "." + route.Name
+	}
+	if route.Name != "" {
+		if router.names == nil {
+			router.names = map[string]int{}
+		}
+		// The methods of a route may share its name, other routes may not
+		if index, ok := router.names[route.Name]; ok {
+			if router.routes[index].Path != route.Path {
+				color.Redf(ErrDuplicateRouteName, route.Name, router.routes[index].Path)
+				os.Exit(1)
+			}
+		} else {
+			router.names[route.Name] = len(router.routes)
+		}
+	}
+	if route.Method == MethodAny {
+		group.Any(relativePath, handlers...)
+	} else {
+		group.Handle(route.Method, relativePath, handlers...)
+	}
+	router.routes = append(router.routes, route)
+}
+
+// Returns the names of a middleware chain
+func middlewareNames(middlewares ...[]middlewareContract.Middleware) []string {
+	var names []string
+	for _, chain := range middlewares {
+		for _, middleware := range chain {
+			names = append(names, fmt.Sprintf("%T", middleware))
+		}
+	}
+	return names
+}
+
+// Joins a group path with a relative path the way gin does, keeping the trailing slash
+func joinPaths(absolutePath, relativePath string) string {
+	if relativePath == "" {
+		return absolutePath
+	}
+	joined := path.Join(absolutePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(joined, "/") {
+		return joined + "/"
+	}
+	return joined
+}
+
+// Returns the name of a route of a group, prefixed with the name of its RouteOptions
+func routeName(prefix, name string) string {
+	if name == "" || prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,194 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	middlewareContract "github.com/nd-tools/capyvel/contracts/middlewares"
+	routerContract "github.com/nd-tools/capyvel/contracts/router"
+)
+
+type authMiddleware struct{}
+
+func (authMiddleware) Middleware(ctx *gin.Context) { ctx.Next() }
+
+type auditMiddleware struct{}
+
+func (auditMiddleware) Middleware(ctx *gin.Context) { ctx.Next() }
+
+type cacheMiddleware struct{}
+
+func (cacheMiddleware) Middleware(ctx *gin.Context) { ctx.Next() }
+
+func TestRoutesMiddlewareChain(t *testing.T) {
+	defaults := []middlewareContract.Middleware{authMiddleware{}}
+	group := []middlewareContract.Middleware{auditMiddleware{}}
+	function := []middlewareContract.Middleware{cacheMiddleware{}}
+	tests := []struct {
+		name     string
+		register func(router *Router)
+		want     map[string][]string // Middlewares by route
+	}{
+		{
+			name: "resource",
+			register: func(router *Router) {
+				router.RegisterResource(RouteOptions{GroupName: "items", Middlewares: group, Resource: &routerContract.Resource{Show: true}}, resourceController{})
+			},
+			want: map[string][]string{"GET /api/items/:id": {"middlewares.RequestID", "router.authMiddleware", "router.auditMiddleware"}},
+		},
+		{
+			name: "resource without default middlewares",
+			register: func(router *Router) {
+				router.RegisterResource(RouteOptions{GroupName: "items", DontUseDefaultMiddlewares: true, Middlewares: group, Resource: &routerContract.Resource{Show: true}}, resourceController{})
+			},
+			want: map[string][]string{"GET /api/items/:id": {"middlewares.RequestID", "router.auditMiddleware"}},
+		},
+		{
+			name: "functions",
+			register: func(router *Router) {
+				router.RegisterFunctions(RouteOptions{GroupName: "jobs", Middlewares: group}, []RouteOptionFunction{
+					{PrefixName: "sync", HttpMethod: http.MethodPost, Function: respond("sync"), Middlewares: function},
+					{PrefixName: "ping", HttpMethod: http.MethodGet, Function: respond("ping"), DontUseDefaultMiddlewares: true, Middlewares: function},
+				})
+			},
+			want: map[string][]string{
+				"POST /api/jobs/sync": {"middlewares.RequestID", "router.authMiddleware", "router.auditMiddleware", "router.cacheMiddleware"},
+				"GET /api/jobs/ping":  {"middlewares.RequestID", "router.cacheMiddleware"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			router.global = []string{"middlewares.RequestID"}
+			router.RegisterDefaultsMiddlewares(defaults)
+			tt.register(router)
+			routes := router.Routes()
+			if len(routes) != len(tt.want) {
+				t.Fatalf("routes = %q, want %d routes", routeKeys(routes), len(tt.want))
+			}
+			for _, route := range routes {
+				if want := tt.want[route.Method+" "+route.Path]; !slices.Equal(route.Middlewares, want) {
+					t.Errorf("%s %s middlewares = %q, want %q", route.Method, route.Path, route.Middlewares, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutesInfo(t *testing.T) {
+	router := newTestRouter(t)
+	router.RegisterResource(RouteOptions{GroupName: "items", Name: "items", Resource: &routerContract.Resource{Show: true}}, resourceController{})
+	router.RegisterFunctions(RouteOptions{GroupName: "jobs", BasePath: "/internal"}, []RouteOptionFunction{
+		{PrefixName: "sync", Name: "jobs.sync", HttpMethod: http.MethodPost, Function: respond("sync")},
+	})
+	want := []RouteInfo{
+		{Name: "items.show", Method: http.MethodGet, Path: "/api/items/:id", Handler: "router.resourceController.Show", Group: "items"},
+		{Name: "jobs.sync", Method: http.MethodPost, Path: "/internal/jobs/sync", Handler: "github.com/nd-tools/capyvel/router.respond.func1", Group: "jobs"},
+	}
+	routes := router.Routes()
+	if len(routes) != len(want) {
+		t.Fatalf("Routes() = %+v, want %+v", routes, want)
+	}
+	for i, route := range routes {
+		if route.Name != want[i].Name || route.Method != want[i].Method || route.Path != want[i].Path || route.Handler != want[i].Handler || route.Group != want[i].Group {
+			t.Errorf("Routes()[%d] = %+v, want %+v", i, route, want[i])
+		}
+	}
+
+	// The registry is not shared with the caller
+	routes[0].Name = "changed"
+	routes[0].Middlewares = append(routes[0].Middlewares, "changed")
+	if again := router.Routes()[0]; again.Name != "items.show" || len(again.Middlewares) != 0 {
+		t.Fatalf("Routes() shares the registry: %+v", again)
+	}
+}
+
+func TestPrintRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	router.global = []string{"middlewares.RequestID", "cors"}
+	router.RegisterResource(RouteOptions{GroupName: "items", Name: "items", Resource: &routerContract.Resource{Index: true, Destroy: true}}, resourceController{})
+	var out bytes.Buffer
+	if err := router.PrintRoutes(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("PrintRoutes() = %q, want a header and 2 routes", out.String())
+	}
+	want := [][]string{
+		{"METHOD", "PATH", "NAME", "HANDLER", "GROUP", "MIDDLEWARES"},
+		{"GET", "/api/items/", "items.index", "router.resourceController.Index", "items", "middlewares.RequestID,", "cors"},
+		{"DELETE", "/api/items/:id", "items.destroy", "router.resourceController.Destroy", "items", "middlewares.RequestID,", "cors"},
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line); !slices.Equal(fields, want[i]) {
+			t.Errorf("line %d = %q, want %q", i, fields, want[i])
+		}
+	}
+	// The columns are aligned
+	if column := strings.Index(lines[0], "PATH"); strings.Index(lines[1], "/api") != column || strings.Index(lines[2], "/api") != column {
+		t.Errorf("PrintRoutes() columns are not aligned:\n%s", out.String())
+	}
+}
+
+func TestRegisterRoutesEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		debug    bool
+		wantCode int
+	}{
+		{name: "debug", debug: true, wantCode: http.StatusOK},
+		{name: "release", debug: false, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			router.debug = tt.debug
+			router.RegisterResource(RouteOptions{GroupName: "items", Resource: &routerContract.Resource{Show: true}}, resourceController{})
+			router.RegisterRoutesEndpoint("/debug/routes")
+			recorder := serve(router, http.MethodGet, "/debug/routes")
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("GET /debug/routes = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			if !tt.debug {
+				if len(router.Routes()) != 1 {
+					t.Fatalf("Routes() = %q, want the endpoint left out", routeKeys(router.Routes()))
+				}
+				return
+			}
+			var body struct {
+				Data      []RouteInfo `json:"data"`
+				TotalRows int64       `json:"count"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if got := routeKeys(body.Data); !slices.Equal(got, []string{"GET /api/items/:id", "GET /debug/routes"}) || body.TotalRows != 2 {
+				t.Fatalf("routes = %q (%d), want the resource and the endpoint", got, body.TotalRows)
+			}
+		})
+	}
+}
+
+func TestJoinPaths(t *testing.T) {
+	tests := []struct {
+		base, relative, want string
+	}{
+		{base: "/api/items", relative: "", want: "/api/items"},
+		{base: "/api/items", relative: "/", want: "/api/items/"},
+		{base: "/api/items", relative: "/:id", want: "/api/items/:id"},
+		{base: "/api/", relative: "/items/", want: "/api/items/"},
+		{base: "/", relative: "/health", want: "/health"},
+	}
+	for _, tt := range tests {
+		if got := joinPaths(tt.base, tt.relative); got != tt.want {
+			t.Errorf("joinPaths(%q, %q) = %q, want %q", tt.base, tt.relative, got, tt.want)
+		}
+	}
+}
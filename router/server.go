@@ -0,0 +1,121 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/configuration"
+	"github.com/nd-tools/capyvel/foundation"
+)
+
+// Default http.* settings of the server
+const (
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultShutdownTimeout   = 30 * time.Second
+	DefaultMaxHeaderBytes    = http.DefaultMaxHeaderBytes
+)
+
+// Hook run when the server shuts down, e.g. to close the database pools
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// HTTP server with its grace period
+type server struct {
+	*http.Server
+	shutdownTimeout time.Duration
+}
+
+// Certificate and key of a TLS server
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// OnShutdown registers a hook run when the server stops, after it is drained, in registration
+// order. The hooks also run when the server fails, e.g. when its port is in use. The context
+// expires at the end of the http.shutdown_timeout grace period.
+func (router *Router) OnShutdown(name string, hook func(ctx context.Context) error) {
+	router.hooks = append(router.hooks, shutdownHook{name: name, fn: hook})
+}
+
+// Runs every shutdown hook, collecting their errors
+func (router *Router) runShutdownHooks(ctx context.Context) error {
+	var errs []error
+	for _, hook := range router.hooks {
+		if err := hook.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf(ErrShutdownHook, hook.name, err))
+			continue
+		}
+		color.Greenf("Shutdown hook %s done\n", hook.name)
+	}
+	return errors.Join(errs...)
+}
+
+// Builds the http.Server from the http.* config. Every configuration problem is reported at once.
+func newServer(handler http.Handler) (*server, *tlsFiles, error) {
+	config := foundation.App.Config
+	problems := configuration.NewErrors("http")
+
+	port, ok := config.Get("http.port", 8080).(int)
+	if !ok {
+		problems.Add(errors.New(ErrPortMisconfigured))
+	}
+
+	values, _ := config.Get("http", nil).(map[string]interface{})
+	duration := func(key string, defaultValue time.Duration) time.Duration {
+		value, err := configuration.Duration(values, key, defaultValue)
+		if err != nil {
+			problems.Add(fmt.Errorf(ErrInvalidHTTPConfig, key))
+		}
+		return value
+	}
+	srv := &server{
+		Server: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           handler,
+			ReadTimeout:       duration("read_timeout", DefaultReadTimeout),
+			ReadHeaderTimeout: duration("read_header_timeout", DefaultReadHeaderTimeout),
+			WriteTimeout:      duration("write_timeout", DefaultWriteTimeout),
+			IdleTimeout:       duration("idle_timeout", DefaultIdleTimeout),
+		},
+		shutdownTimeout: duration("shutdown_timeout", DefaultShutdownTimeout),
+	}
+	switch maxHeaderBytes := values["max_header_bytes"].(type) {
+	case nil:
+		srv.MaxHeaderBytes = DefaultMaxHeaderBytes
+	case int:
+		srv.MaxHeaderBytes = maxHeaderBytes
+	}
+	if srv.MaxHeaderBytes <= 0 {
+		problems.Add(fmt.Errorf(ErrInvalidHTTPConfig, "max_header_bytes"))
+	}
+
+	var tls *tlsFiles
+	runtls, ok := config.Get("http.tls.enable", false).(bool)
+	if !ok {
+		problems.Add(errors.New(ErrTLSConfigError))
+	}
+	if runtls {
+		tls = &tlsFiles{}
+		if tls.certFile, ok = config.Get("http.tls.ssl.cert", "").(string); !ok || tls.certFile == "" {
+			problems.Add(errors.New(ErrTLSCertPathNotFound))
+		}
+		if tls.keyFile, ok = config.Get("http.tls.ssl.key", "").(string); !ok || tls.keyFile == "" {
+			problems.Add(errors.New(ErrTLSKeyPathNotFound))
+		}
+	}
+
+	if err := problems.Err(); err != nil {
+		return nil, nil, err
+	}
+	return srv, tls, nil
+}
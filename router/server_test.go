@@ -0,0 +1,188 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/color"
+	"github.com/nd-tools/capyvel/configuration"
+)
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name         string
+		http         map[string]any
+		wantAddr     string
+		wantRead     time.Duration
+		wantShutdown time.Duration
+		wantHeader   int
+		wantTLS      bool
+		wantProblems []string
+	}{
+		{
+			name:         "defaults",
+			http:         map[string]any{"port": 8080},
+			wantAddr:     ":8080",
+			wantRead:     DefaultReadTimeout,
+			wantShutdown: DefaultShutdownTimeout,
+			wantHeader:   DefaultMaxHeaderBytes,
+		},
+		{
+			name:         "timeouts in seconds and durations",
+			http:         map[string]any{"port": 9000, "read_timeout": 5, "shutdown_timeout": 1500 * time.Millisecond, "max_header_bytes": 4096},
+			wantAddr:     ":9000",
+			wantRead:     5 * time.Second,
+			wantShutdown: 1500 * time.Millisecond,
+			wantHeader:   4096,
+		},
+		{
+			name:         "tls",
+			http:         map[string]any{"port": 443, "tls": map[string]any{"enable": true, "ssl": map[string]any{"cert": "cert.pem", "key": "key.pem"}}},
+			wantAddr:     ":443",
+			wantRead:     DefaultReadTimeout,
+			wantShutdown: DefaultShutdownTimeout,
+			wantHeader:   DefaultMaxHeaderBytes,
+			wantTLS:      true,
+		},
+		{
+			name:         "port",
+			http:         map[string]any{"port": "8080"},
+			wantProblems: []string{ErrPortMisconfigured},
+		},
+		{
+			name: "every problem at once",
+			http: map[string]any{
+				"port": 8080, "read_timeout": "5s", "idle_timeout": -1, "max_header_bytes": 0,
+				"tls": map[string]any{"enable": true, "ssl": map[string]any{"cert": ""}},
+			},
+			wantProblems: []string{
+				fmt.Sprintf(ErrInvalidHTTPConfig, "read_timeout"),
+				fmt.Sprintf(ErrInvalidHTTPConfig, "idle_timeout"),
+				fmt.Sprintf(ErrInvalidHTTPConfig, "max_header_bytes"),
+				ErrTLSCertPathNotFound,
+				ErrTLSKeyPathNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, "http", tt.http)
+			srv, tls, err := newServer(gin.New())
+			if len(tt.wantProblems) > 0 {
+				var problems *configuration.Errors
+				if !errors.As(err, &problems) {
+					t.Fatalf("newServer() error = %v, want configuration errors", err)
+				}
+				var got []string
+				for _, problem := range problems.Problems {
+					got = append(got, problem.Error())
+				}
+				if !slices.Equal(got, tt.wantProblems) {
+					t.Fatalf("newServer() problems = %q, want %q", got, tt.wantProblems)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newServer() error = %v", err)
+			}
+			if srv.Addr != tt.wantAddr || srv.ReadTimeout != tt.wantRead || srv.shutdownTimeout != tt.wantShutdown || srv.MaxHeaderBytes != tt.wantHeader {
+				t.Fatalf("server = %s read %s shutdown %s headers %d, want %s %s %s %d", srv.Addr, srv.ReadTimeout, srv.shutdownTimeout, srv.MaxHeaderBytes, tt.wantAddr, tt.wantRead, tt.wantShutdown, tt.wantHeader)
+			}
+			if srv.ReadHeaderTimeout != DefaultReadHeaderTimeout || srv.WriteTimeout != DefaultWriteTimeout || srv.IdleTimeout != DefaultIdleTimeout {
+				t.Errorf("server = %+v, want the default header, write and idle timeouts", srv.Server)
+			}
+			if (tls != nil) != tt.wantTLS {
+				t.Fatalf("tls = %+v, want %v", tls, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestRunContextShutdownHooks(t *testing.T) {
+	// Port taken by another listener
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	failure := errors.New("flush failed")
+	tests := []struct {
+		name     string
+		http     map[string]any
+		cancel   bool // Whether the context is canceled once the server listens
+		failHook bool
+		wantErr  []string // Fragments of the returned error
+		wantLog  bool     // Whether "Listening on" is printed
+	}{
+		{name: "graceful shutdown", http: map[string]any{"port": 0}, cancel: true, wantLog: true},
+		{name: "failing hook", http: map[string]any{"port": 0}, cancel: true, failHook: true, wantErr: []string{"Shutdown hook flush failed: flush failed"}, wantLog: true},
+		{name: "port in use", http: map[string]any{"port": busyPort}, wantErr: []string{"HTTP server failed"}},
+		{name: "invalid config", http: map[string]any{"port": 0, "read_timeout": "5s"}, failHook: true, wantErr: []string{"read_timeout", "Shutdown hook flush failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, "http", tt.http)
+			var printed bytes.Buffer
+			color.SetOutput(&printed)
+			t.Cleanup(color.ResetOutput)
+			router := &Router{engine: gin.New()}
+			var ran []string
+			router.OnShutdown("close", func(ctx context.Context) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("hook context without deadline")
+				}
+				ran = append(ran, "close")
+				return nil
+			})
+			router.OnShutdown("flush", func(context.Context) error {
+				ran = append(ran, "flush")
+				if tt.failHook {
+					return failure
+				}
+				return nil
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(50*time.Millisecond, cancel)
+			}
+			done := make(chan error, 1)
+			go func() { done <- router.RunContext(ctx) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("RunContext() did not return")
+			}
+
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("RunContext() error = %v, want %q", err, tt.wantErr)
+			}
+			for _, fragment := range tt.wantErr {
+				if !strings.Contains(err.Error(), fragment) {
+					t.Errorf("RunContext() error = %v, want %q", err, fragment)
+				}
+			}
+			if tt.failHook && !errors.Is(err, failure) {
+				t.Errorf("RunContext() error = %v, want the hook error", err)
+			}
+			if !slices.Equal(ran, []string{"close", "flush"}) {
+				t.Fatalf("hooks run = %v, want close and flush in order", ran)
+			}
+			if logged := strings.Contains(printed.String(), "Listening on"); logged != tt.wantLog {
+				t.Errorf("printed %q, want Listening on %v", printed.String(), tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// URL returns the path of the named route with its :param and *param segments filled from
+// params and the encoded query appended, e.g. URL("users.show", map[string]any{"id": 7}, nil)
+// returns "/api/users/7". Parameters missing from the path are not added to the query.
+func (router *Router) URL(name string, params map[string]any, query url.Values) (string, error) {
+	index, ok := router.names[name]
+	if !ok {
+		return "", fmt.Errorf(ErrRouteNotDefined, name)
+	}
+	segments := strings.Split(router.routes[index].Path, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		value, ok := params[segment[1:]]
+		if !ok {
+			return "", fmt.Errorf(ErrRouteParamMissing, name, segment[1:])
+		}
+		if segment[0] == ':' {
+			segments[i] = url.PathEscape(fmt.Sprint(value))
+			continue
+		}
+		// Catch-all parameters keep their slashes, gin stores them with a leading one
+		parts := strings.Split(strings.TrimPrefix(fmt.Sprint(value), "/"), "/")
+		for j, part := range parts {
+			parts[j] = url.PathEscape(part)
+		}
+		segments[i] = strings.Join(parts, "/")
+	}
+	path := strings.Join(segments, "/")
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+	return path, nil
+}
+
+// AbsoluteURL behaves like URL, prefixing the path with app.url or, when it is not configured,
+// with the scheme and host of the request.
+func (router *Router) AbsoluteURL(ctx *gin.Context, name string, params map[string]any, query url.Values) (string, error) {
+	path, err := router.URL(name, params, query)
+	if err != nil {
+		return "", err
+	}
+	return router.origin(ctx) + path, nil
+}
+
+// RouteName returns the name of the route that matched the request, or "" when it has none
+func (router *Router) RouteName(ctx *gin.Context) string {
+	fullPath := ctx.FullPath()
+	for _, route := range router.routes {
+		if route.Name != "" && route.Path == fullPath && (route.Method == ctx.Request.Method || route.Method == MethodAny) {
+			return route.Name
+		}
+	}
+	return ""
+}
+
+// ContextParams returns the path parameters of the request, ready to be passed to URL
+func ContextParams(ctx *gin.Context) map[string]any {
+	params := make(map[string]any, len(ctx.Params))
+	for _, param := range ctx.Params {
+		params[param.Key] = param.Value
+	}
+	return params
+}
+
+// Returns app.url, or the scheme and host of the request behind an optional proxy
+func (router *Router) origin(ctx *gin.Context) string {
+	if router.baseURL != "" {
+		return router.baseURL
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	return scheme + "://" + ctx.Request.Host
+}
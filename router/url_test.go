@@ -0,0 +1,120 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	routerContract "github.com/nd-tools/capyvel/contracts/router"
+)
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		params  map[string]any
+		query   url.Values
+		want    string
+		wantErr string
+	}{
+		{name: "resource item", route: "items.show", params: map[string]any{"id": 7}, want: "/api/items/7"},
+		{name: "resource index with query", route: "items.index", query: url.Values{"page": {"2"}, "search": {"a&b"}}, want: "/api/items/?page=2&search=a%26b"},
+		{name: "escaped parameter", route: "items.show", params: map[string]any{"id": "a/b c"}, want: "/api/items/a%2Fb%20c"},
+		{name: "extra parameters are ignored", route: "items.show", params: map[string]any{"id": 7, "page": 2}, want: "/api/items/7"},
+		{name: "catch-all parameter", route: "files.download", params: map[string]any{"path": "/docs/a b.pdf"}, want: "/api/files/download/docs/a%20b.pdf"},
+		{name: "missing parameter", route: "items.show", wantErr: "Route items.show requires the id parameter"},
+		{name: "undefined route", route: "items.missing", wantErr: "Route items.missing is not defined"},
+	}
+	router := newTestRouter(t)
+	router.RegisterResource(RouteOptions{GroupName: "items", Name: "items", Resource: &routerContract.Resource{Index: true, Show: true}}, resourceController{})
+	router.RegisterFunctions(RouteOptions{GroupName: "files", Name: "files"}, []RouteOptionFunction{
+		{PrefixName: "download/*path", Name: "download", HttpMethod: http.MethodGet, Function: respond("download")},
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := router.URL(tt.route, tt.params, tt.query)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("URL() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("URL() = %q, %v, want %q", got, err, tt.want)
+			}
+			// The generated path is served by the route
+			if tt.route == "files.download" {
+				if recorder := serve(router, http.MethodGet, got); recorder.Body.String() != "download" {
+					t.Fatalf("GET %s = %d, want the download route", got, recorder.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		host    string
+		tls     bool
+		proto   string
+		want    string
+	}{
+		{name: "app.url", baseURL: "https://api.example.com", host: "internal:8080", want: "https://api.example.com/api/items/7"},
+		{name: "request host", host: "localhost:8080", want: "http://localhost:8080/api/items/7"},
+		{name: "tls request", host: "example.com", tls: true, want: "https://example.com/api/items/7"},
+		{name: "behind a proxy", host: "example.com", proto: "https, http", want: "https://example.com/api/items/7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			router.baseURL = tt.baseURL
+			router.RegisterResource(RouteOptions{GroupName: "items", Name: "items", Resource: &routerContract.Resource{Show: true}}, resourceController{})
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx.Request.Host = tt.host
+			if tt.tls {
+				ctx.Request.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				ctx.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got, err := router.AbsoluteURL(ctx, "items.show", map[string]any{"id": 7}, nil); err != nil || got != tt.want {
+				t.Fatalf("AbsoluteURL() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	router := newTestRouter(t)
+	var name string
+	var params map[string]any
+	record := func(ctx *gin.Context) {
+		name, params = router.RouteName(ctx), ContextParams(ctx)
+	}
+	router.RegisterFunctions(RouteOptions{GroupName: "items", Name: "items"}, []RouteOptionFunction{
+		{PrefixName: ":id", Name: "show", HttpMethod: http.MethodGet, Function: record},
+		{PrefixName: ":id", HttpMethod: http.MethodPut, Function: record},
+		{PrefixName: "search", Name: "search", HttpMethod: MethodAny, Function: record},
+	})
+	tests := []struct {
+		method, target string
+		wantName       string
+		wantParams     map[string]any
+	}{
+		{method: http.MethodGet, target: "/api/items/7", wantName: "items.show", wantParams: map[string]any{"id": "7"}},
+		{method: http.MethodPut, target: "/api/items/7", wantParams: map[string]any{"id": "7"}},
+		{method: http.MethodPost, target: "/api/items/search", wantName: "items.search", wantParams: map[string]any{}},
+	}
+	for _, tt := range tests {
+		serve(router, tt.method, tt.target)
+		if name != tt.wantName || len(params) != len(tt.wantParams) || params["id"] != tt.wantParams["id"] {
+			t.Errorf("%s %s = %q %v, want %q %v", tt.method, tt.target, name, params, tt.wantName, tt.wantParams)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package router
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/color"
+)
+
+// DefaultVersionHeader is the custom header selecting the API version of a request
+const DefaultVersionHeader = "X-API-Version"
+
+// Key of the selected version in the context of the request
+type versionContextKey struct{}
+
+// Version is an API version, served under the /{Name} prefix of the groups, e.g. /api/v2
+type Version struct {
+	Name       string       // Name of the version, e.g. "v2"
+	Fallback   string       // Older version serving the routes this version does not define
+	Deprecated *Deprecation // Deprecation of the whole version
+}
+
+// VersioningConfig declares the API versions and how requests select them. The path prefix
+// takes precedence over the custom header, which takes precedence over the Accept header, e.g.
+// "application/vnd.app.v2+json" or "application/json; version=v2".
+type VersioningConfig struct {
+	Versions []Version // Registered versions
+	Header   string    // Custom header selecting the version, DefaultVersionHeader when empty
+	Default  string    // Version of the requests that do not select one, none when empty
+}
+
+// Deprecation marks routes as deprecated, see RFC 9745 and RFC 8594
+type Deprecation struct {
+	Since  time.Time // Date of the deprecation, the Deprecation header is "true" when zero
+	Sunset time.Time // Date the routes stop working, sent in the Sunset header when set
+	Link   string    // Documentation of the deprecation, sent in a Link header with rel="deprecation"
+}
+
+// RegisterVersions declares the API versions; it must be called before registering versioned routes
+func (router *Router) RegisterVersions(config VersioningConfig) {
+	if config.Header == "" {
+		config.Header = DefaultVersionHeader
+	}
+	router.versions = map[string]Version{}
+	for _, version := range config.Versions {
+		if version.Name == "" || strings.Contains(version.Name, "/") {
+			color.Redf(ErrInvalidVersion, version.Name)
+			os.Exit(1)
+		}
+		router.versions[version.Name] = version
+	}
+	// Every fallback chain must end on a registered version without fallback
+	for _, version := range config.Versions {
+		seen := map[string]bool{}
+		for name := version.Name; name != ""; name = router.versions[name].Fallback {
+			if _, ok := router.versions[name]; !ok || seen[name] {
+				color.Redf(ErrInvalidVersionFallback, version.Name, name)
+				os.Exit(1)
+			}
+			seen[name] = true
+		}
+	}
+	if _, ok := router.versions[config.Default]; config.Default != "" && !ok {
+		color.Redf(ErrVersionNotRegistered, config.Default)
+		os.Exit(1)
+	}
+	router.versioning = config
+}
+
+// Handler returns the HTTP handler of the router: the gin engine behind the version selection
+func (router *Router) Handler() http.Handler {
+	if len(router.versions) == 0 {
+		return router.engine
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if version, ok := router.selectVersion(r); ok {
+			if deprecated := router.versions[version].Deprecated; deprecated != nil {
+				deprecated.setHeaders(w.Header())
+			}
+			r = r.WithContext(context.WithValue(r.Context(), versionContextKey{}, version))
+		}
+		router.engine.ServeHTTP(w, r)
+	})
+}
+
+// ContextVersion returns the API version selected by the request, or "" when it selects none
+func ContextVersion(ctx *gin.Context) string {
+	version, _ := ctx.Request.Context().Value(versionContextKey{}).(string)
+	return version
+}
+
+// Selects the version of the request and rewrites its path to the first version of the
+// fallback chain defining the route. Requests that do not select a version keep their path.
+func (router *Router) selectVersion(r *http.Request) (string, bool) {
+	for _, base := range router.versionBases {
+		if r.URL.Path != base && !strings.HasPrefix(r.URL.Path, base+"/") {
+			continue
+		}
+		rest := strings.TrimPrefix(r.URL.Path, base)
+		segment, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+		version, ok := router.versions[segment]
+		if ok {
+			rest = strings.TrimPrefix(strings.TrimPrefix(rest, "/"), segment)
+		} else if version, ok = router.versions[router.requestedVersion(r)]; !ok {
+			return "", false
+		}
+		for name := version.Name; name != ""; name = router.versions[name].Fallback {
+			if path, found := router.matchRoute(r.Method, base+"/"+name+rest); found {
+				r.URL.Path = path
+				r.URL.RawPath = ""
+				break
+			}
+		}
+		return version.Name, true
+	}
+	return "", false
+}
+
+// Returns the version selected by the custom header, the Accept header or the default one
+func (router *Router) requestedVersion(r *http.Request) string {
+	if version := r.Header.Get(router.versioning.Header); version != "" {
+		return version
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if version, ok := params["version"]; ok {
+			return version
+		}
+		// Vendor media types carry the version as their last segment, e.g. application/vnd.app.v2+json
+		if vendor, found := strings.CutPrefix(mediaType, "application/vnd."); found {
+			vendor, _, _ = strings.Cut(vendor, "+")
+			if version := vendor[strings.LastIndex(vendor, ".")+1:]; router.versions[version].Name != "" {
+				return version
+			}
+		}
+	}
+	return router.versioning.Default
+}
+
+// Returns the path of a request matching a registered route, with the trailing slash of the route
+func (router *Router) matchRoute(method, path string) (string, bool) {
+	for _, route := range router.routes {
+		if route.Method != method && route.Method != MethodAny {
+			continue
+		}
+		if matchPath(strings.TrimSuffix(route.Path, "/"), strings.TrimSuffix(path, "/")) {
+			if strings.HasSuffix(route.Path, "/") && !strings.HasSuffix(path, "/") {
+				path += "/"
+			}
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a path matches a route pattern with :param and *param segments
+func matchPath(pattern, path string) bool {
+	patterns := strings.Split(pattern, "/")
+	segments := strings.Split(path, "/")
+	for i, p := range patterns {
+		if p != "" && p[0] == '*' {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if p != "" && p[0] == ':' {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != segments[i] {
+			return false
+		}
+	}
+	return len(patterns) == len(segments)
+}
+
+// Returns the middleware emitting the deprecation headers of the routes
+func deprecationHeaders(deprecation *Deprecation) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		deprecation.setHeaders(ctx.Writer.Header())
+		ctx.Next()
+	}
+}
+
+// Sets the Deprecation, Sunset and Link headers
+func (deprecation *Deprecation) setHeaders(header http.Header) {
+	if deprecation.Since.IsZero() {
+		header.Set("Deprecation", "true")
+	} else {
+		header.Set("Deprecation", "@"+strconv.FormatInt(deprecation.Since.Unix(), 10))
+	}
+	if !deprecation.Sunset.IsZero() {
+		header.Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if deprecation.Link != "" {
+		header.Add("Link", "<"+deprecation.Link+`>; rel="deprecation"`)
+	}
+}
@@ -0,0 +1,168 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler responding with its name and the version selected by the request
+func respondVersion(name string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, name+" "+ContextVersion(ctx))
+	}
+}
+
+func TestVersionFallback(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultVersion string
+		method         string
+		target         string
+		headers        map[string]string
+		wantCode       int
+		wantBody       string
+		wantDeprecated bool
+	}{
+		{name: "version route", target: "/api/v2/users/7", wantBody: "v2.show v2"},
+		{name: "fallback to an older version", target: "/api/v2/users/7/export", wantBody: "v1.export v2"},
+		{name: "fallback chain", target: "/api/v3/users/7/export", wantBody: "v1.export v3", wantDeprecated: true},
+		{name: "nearest version of the chain", target: "/api/v3/users/7", wantBody: "v2.show v3", wantDeprecated: true},
+		{name: "older versions do not fall forward", method: http.MethodPost, target: "/api/v1/users/sync", wantCode: http.StatusNotFound},
+		{name: "route of no version", target: "/api/v3/orders", wantCode: http.StatusNotFound, wantDeprecated: true},
+		{name: "custom header", target: "/api/users/7", headers: map[string]string{DefaultVersionHeader: "v2"}, wantBody: "v2.show v2"},
+		{name: "vendor media type", target: "/api/users/7/export", headers: map[string]string{"Accept": "text/html, application/vnd.app.v2+json"}, wantBody: "v1.export v2"},
+		{name: "version parameter of the media type", target: "/api/users/7", headers: map[string]string{"Accept": "application/json; version=v1"}, wantBody: "v1.show v1"},
+		{name: "custom header over the Accept header", target: "/api/users/7", headers: map[string]string{DefaultVersionHeader: "v1", "Accept": "application/vnd.app.v2+json"}, wantBody: "v1.show v1"},
+		{name: "path over the headers", target: "/api/v1/users/7", headers: map[string]string{DefaultVersionHeader: "v2"}, wantBody: "v1.show v1"},
+		{name: "no version selected", target: "/api/users/7", wantCode: http.StatusNotFound},
+		{name: "default version", defaultVersion: "v2", target: "/api/users/7", wantBody: "v2.show v2"},
+		{name: "unknown version is not served", defaultVersion: "v1", target: "/api/users/7", headers: map[string]string{DefaultVersionHeader: "v9"}, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			router.RegisterVersions(VersioningConfig{
+				Versions: []Version{
+					{Name: "v1"},
+					{Name: "v2", Fallback: "v1"},
+					{Name: "v3", Fallback: "v2", Deprecated: &Deprecation{}},
+				},
+				Default: tt.defaultVersion,
+			})
+			router.RegisterFunctions(RouteOptions{GroupName: "users", Version: "v1"}, []RouteOptionFunction{
+				{PrefixName: ":id", HttpMethod: http.MethodGet, Function: respondVersion("v1.show")},
+				{PrefixName: ":id/export", HttpMethod: http.MethodGet, Function: respondVersion("v1.export")},
+			})
+			router.RegisterFunctions(RouteOptions{GroupName: "users", Version: "v2"}, []RouteOptionFunction{
+				{PrefixName: ":id", HttpMethod: http.MethodGet, Function: respondVersion("v2.show")},
+			})
+			router.RegisterFunctions(RouteOptions{GroupName: "users", Version: "v3"}, []RouteOptionFunction{
+				{PrefixName: "sync", HttpMethod: http.MethodPost, Function: respondVersion("v3.sync")},
+			})
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request := httptest.NewRequest(method, tt.target, nil)
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			router.Handler().ServeHTTP(recorder, request)
+			wantCode := tt.wantCode
+			if wantCode == 0 {
+				wantCode = http.StatusOK
+			}
+			if recorder.Code != wantCode || (tt.wantBody != "" && recorder.Body.String() != tt.wantBody) {
+				t.Fatalf("%s %s = %d %q, want %d %q", method, tt.target, recorder.Code, recorder.Body.String(), wantCode, tt.wantBody)
+			}
+			if deprecated := recorder.Header().Get("Deprecation") != ""; deprecated != tt.wantDeprecated {
+				t.Fatalf("Deprecation header = %q, want deprecated %v", recorder.Header().Get("Deprecation"), tt.wantDeprecated)
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/api/v1/users", path: "/api/v1/users", want: true},
+		{pattern: "/api/v1/users/:id", path: "/api/v1/users/7", want: true},
+		{pattern: "/api/v1/users/:id", path: "/api/v1/users/", want: false},
+		{pattern: "/api/v1/users/:id", path: "/api/v1/users/7/export", want: false},
+		{pattern: "/api/v1/users/:id/export", path: "/api/v1/users/7", want: false},
+		{pattern: "/api/v1/files/*path", path: "/api/v1/files/a/b.png", want: true},
+		{pattern: "/api/v1/users", path: "/api/v2/users", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchPath(tt.pattern, tt.path); got != tt.want {
+				t.Fatalf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeprecationHeaders(t *testing.T) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2026, 7, 1, 12, 0, 0, 0, time.FixedZone("CST", -6*3600))
+	tests := []struct {
+		name        string
+		deprecation Deprecation
+		want        map[string]string
+	}{
+		{name: "deprecated", want: map[string]string{"Deprecation": "true", "Sunset": "", "Link": ""}},
+		{
+			name:        "every header",
+			deprecation: Deprecation{Since: since, Sunset: sunset, Link: "https://docs.example.com/v1"},
+			want: map[string]string{
+				"Deprecation": "@" + strconv.FormatInt(since.Unix(), 10),
+				"Sunset":      "Wed, 01 Jul 2026 18:00:00 GMT",
+				"Link":        `<https://docs.example.com/v1>; rel="deprecation"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			tt.deprecation.setHeaders(header)
+			for key, want := range tt.want {
+				if got := header.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteDeprecation(t *testing.T) {
+	router := newTestRouter(t)
+	router.RegisterFunctions(RouteOptions{GroupName: "reports", Deprecated: &Deprecation{Link: "https://docs.example.com/reports"}}, []RouteOptionFunction{
+		{PrefixName: "daily", HttpMethod: http.MethodGet, Function: respond("daily")},
+		{PrefixName: "weekly", HttpMethod: http.MethodGet, Function: respond("weekly"), Deprecated: &Deprecation{Link: "https://docs.example.com/weekly"}},
+	})
+	tests := []struct {
+		target   string
+		wantLink string
+	}{
+		{target: "/api/reports/daily", wantLink: `<https://docs.example.com/reports>; rel="deprecation"`},
+		{target: "/api/reports/weekly", wantLink: `<https://docs.example.com/weekly>; rel="deprecation"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			recorder := serve(router, http.MethodGet, tt.target)
+			if recorder.Header().Get("Deprecation") != "true" || recorder.Header().Values("Link")[0] != tt.wantLink {
+				t.Fatalf("GET %s headers = %v, want the deprecation link %s", tt.target, recorder.Header(), tt.wantLink)
+			}
+		})
+	}
+}